@@ -20,6 +20,8 @@ package config
 import (
 	_ "embed"
 	"fmt"
+	"net/mail"
+	"slices"
 	"strings"
 
 	"github.com/BurntSushi/toml"
@@ -28,31 +30,118 @@ import (
 
 var validate = validator.New()
 
+// Template engines selectable with template_engine in [general].
+const (
+	// TemplateEnginePlaceholder substitutes flat %KEY% placeholders (default).
+	TemplateEnginePlaceholder = "placeholder"
+	// TemplateEngineGo renders subject and body with Go's text/template.
+	TemplateEngineGo = "go"
+)
+
 // tomlConfig mirrors the TOML file structure for decoding.
 type tomlConfig struct {
 	General    tomlGeneral     `toml:"general"    validate:"required"`
-	Recipients []tomlRecipient `toml:"recipients" validate:"required,min=1,dive"`
+	DKIM       *tomlDKIM       `toml:"dkim"`
+	SMIME      *tomlSMIME      `toml:"smime"`
+	PGP        *tomlPGP        `toml:"pgp"`
+	Recipients []tomlRecipient `toml:"recipients" validate:"dive"`
 }
 
 // tomlGeneral holds the [general] section fields.
 type tomlGeneral struct {
-	From        string   `toml:"from"        validate:"required"`
-	Subject     string   `toml:"subject"     validate:"required"`
-	ReplyTo     string   `toml:"reply_to"`
-	Cc          []string `toml:"cc"`
-	Attachments []string `toml:"attachments"`
+	From           string   `toml:"from"            validate:"required"`
+	Subject        string   `toml:"subject"`
+	ReplyTo        string   `toml:"reply_to"`
+	Cc             []string `toml:"cc"`
+	Bcc            []string `toml:"bcc"`
+	Attachments    []string `toml:"attachments"`
+	TemplateEngine string   `toml:"template_engine" validate:"omitempty,oneof=placeholder go"`
+	HTMLTemplate   string   `toml:"html_template"`
+	RecipientsCSV  string   `toml:"recipients_csv"`
+	// SuppressionList names a file of addresses, domains or patterns not to
+	// mail (see -bounces).
+	SuppressionList string `toml:"suppression_list"`
+	SuppressionMode string `toml:"suppression_mode" validate:"omitempty,oneof=skip warn"`
+	// UnsubscribeMailto and UnsubscribeURL are the List-Unsubscribe targets,
+	// with placeholders substituted per recipient.
+	UnsubscribeMailto string `toml:"unsubscribe_mailto"`
+	UnsubscribeURL    string `toml:"unsubscribe_url"`
+	// Headers are extra header fields added to every message.
+	Headers map[string]string `toml:"headers"`
+}
+
+// tomlDKIM holds the optional [dkim] section.
+type tomlDKIM struct {
+	PrivateKey       string   `toml:"private_key"      validate:"required"`
+	Selector         string   `toml:"selector"         validate:"required"`
+	Domain           string   `toml:"domain"`
+	Headers          []string `toml:"headers"`
+	Canonicalization string   `toml:"canonicalization" validate:"omitempty,oneof=simple relaxed simple/simple simple/relaxed relaxed/simple relaxed/relaxed"`
+}
+
+// tomlSMIME holds the optional [smime] section.
+type tomlSMIME struct {
+	Cert string `toml:"cert" validate:"required"`
+	Key  string `toml:"key"  validate:"required"`
+}
+
+// tomlPGP holds the optional [pgp] section.
+type tomlPGP struct {
+	SecretKey  string `toml:"secret_key"`
+	Keyring    string `toml:"keyring"`
+	MissingKey string `toml:"missing_key" validate:"omitempty,oneof=skip plaintext fail"`
 }
 
 // tomlRecipient holds a single [[recipients]] entry.
 type tomlRecipient struct {
-	Email            string            `toml:"email"             validate:"required,email"`
-	First            string            `toml:"first"             validate:"required"`
-	Last             string            `toml:"last"`
-	Data             map[string]string `toml:"data"`
-	Cc               []string          `toml:"cc"`
-	CcExtra          []string          `toml:"cc_extra"`
-	Attachments      []string          `toml:"attachments"`
-	AttachmentsExtra []string          `toml:"attachments_extra"`
+	Email            string               `toml:"email"             validate:"required,email"`
+	First            string               `toml:"first"             validate:"required"`
+	Last             string               `toml:"last"`
+	From             string               `toml:"from"`
+	ReplyTo          string               `toml:"reply_to"`
+	Subject          string               `toml:"subject"`
+	Data             map[string]dataValue `toml:"data"`
+	Cc               []string             `toml:"cc"`
+	CcExtra          []string             `toml:"cc_extra"`
+	Bcc              []string             `toml:"bcc"`
+	BccExtra         []string             `toml:"bcc_extra"`
+	Attachments      []string             `toml:"attachments"`
+	AttachmentsExtra []string             `toml:"attachments_extra"`
+	SMIMECert        string               `toml:"smime_cert"`
+	PGPKey           string               `toml:"pgp_key"`
+	Headers          map[string]string    `toml:"headers"`
+	HeadersExtra     map[string]string    `toml:"headers_extra"`
+}
+
+// dataValue is a recipient data value: either a string or a list of strings
+// (for {{range}} in the go template engine).
+type dataValue struct {
+	str    string
+	list   []string
+	isList bool
+}
+
+// UnmarshalTOML implements toml.Unmarshaler, accepting only strings and
+// arrays of strings.
+func (d *dataValue) UnmarshalTOML(v any) error {
+	switch v := v.(type) {
+	case string:
+		d.str = v
+		return nil
+	case []any:
+		d.isList = true
+		d.list = make([]string, 0, len(v))
+		for _, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return fmt.Errorf("data list items must be strings, got %T", item)
+			}
+			d.list = append(d.list, s)
+		}
+		return nil
+	default:
+		return fmt.Errorf("data values must be strings or lists of strings, got %T", v)
+	}
 }
 
 // Recipient holds a parsed recipient entry from the config file.
@@ -60,21 +149,90 @@ type Recipient struct {
 	Email            string
 	First            string
 	Last             string
+	From             string // replaces the [general] from; a template like the subject
+	ReplyTo          string // replaces the [general] reply_to; a template like the subject
+	Subject          string // replaces the [general] subject
 	Data             map[string]string
-	Cc               []string // replaces global Cc
-	CcExtra          []string // appends to global Cc
-	Attachments      []string // replaces global attachments
-	AttachmentsExtra []string // appends to global attachments
+	Lists            map[string][]string // list-valued data, keyed like Data
+	Cc               []string            // replaces global Cc
+	CcExtra          []string            // appends to global Cc
+	Bcc              []string            // replaces global Bcc
+	BccExtra         []string            // appends to global Bcc
+	Attachments      []string            // replaces global attachments
+	AttachmentsExtra []string            // appends to global attachments
+	SMIMECert        string              // S/MIME certificate to encrypt to; empty sends unencrypted
+	PGPKey           string              // OpenPGP public key to encrypt to; empty looks in the [pgp] keyring
+	Headers          map[string]string   // replaces global headers
+	HeadersExtra     map[string]string   // adds to (or overrides) global headers
+}
+
+// DKIMConfig holds the [dkim] section: how outgoing messages are signed.
+type DKIMConfig struct {
+	PrivateKey       string   // path to the PEM private key (RSA or Ed25519)
+	Selector         string   // s= tag: the key is published at <selector>._domainkey.<domain>
+	Domain           string   // d= tag; empty means the From domain
+	Headers          []string // header fields to sign; empty means a default set
+	Canonicalization string   // "header/body", e.g. "relaxed/relaxed"; empty means relaxed/relaxed
+}
+
+// SMIMEConfig holds the [smime] section: the certificate and key messages
+// are S/MIME-signed with.
+type SMIMEConfig struct {
+	Cert string // PEM signing certificate, optionally followed by its intermediates
+	Key  string // PEM private key for Cert
+}
+
+// What to do with a recipient that has no OpenPGP key when encrypting.
+const (
+	PGPMissingKeySkip      = "skip"      // do not send to the recipient
+	PGPMissingKeyPlaintext = "plaintext" // send unencrypted (but signed, if signing)
+	PGPMissingKeyFail      = "fail"      // reject the run before sending
+)
+
+// PGPConfig holds the [pgp] section: the OpenPGP key messages are signed
+// with, where recipients' public keys come from, and what to do when one is
+// missing. Encryption is on if Keyring is set or any recipient has a PGPKey.
+type PGPConfig struct {
+	SecretKey  string // secret key file to sign with; empty means no signing
+	Keyring    string // public keyring file, searched by recipient address
+	MissingKey string // PGPMissingKeySkip, PGPMissingKeyPlaintext or PGPMissingKeyFail
+}
+
+// Encrypts reports whether messages to recipients are to be encrypted.
+func (c *PGPConfig) Encrypts(recipients []Recipient) bool {
+	return c.Keyring != "" || slices.ContainsFunc(recipients, func(r Recipient) bool { return r.PGPKey != "" })
 }
 
 // MailConfig holds the fully parsed configuration for a mailing run.
 type MailConfig struct {
-	From        string
-	ReplyTo     string
-	Cc          []string
-	Subject     string
-	Recipients  []Recipient
-	Attachments []string
+	From           string
+	ReplyTo        string
+	Cc             []string
+	Bcc            []string // blind copies: envelope recipients only
+	Subject        string   // may be empty if the template front matter sets it
+	Recipients     []Recipient
+	RecipientsCSV  string // path of the recipients_csv file Recipients were partly loaded from, if any
+	Attachments    []string
+	TemplateEngine string       // TemplateEnginePlaceholder or TemplateEngineGo
+	HTMLTemplate   string       // path to an optional HTML body template
+	DKIM           *DKIMConfig  // nil if messages are not DKIM-signed
+	SMIME          *SMIMEConfig // nil if messages are not S/MIME-signed
+	PGP            *PGPConfig   // nil if OpenPGP is not used
+	// SuppressionList is the suppression list file, if any; SuppressionMode
+	// (SuppressionSkip or SuppressionWarn) says what to do with recipients
+	// and Cc addresses on it. Suppressed holds its entries once
+	// LoadSuppressionList has read it.
+	SuppressionList string
+	SuppressionMode string
+	Suppressed      *Suppressions
+	// UnsubscribeMailto (an address or mailto: URL) and UnsubscribeURL (an
+	// https URL for one-click unsubscription) are templates for the
+	// List-Unsubscribe header; empty for none.
+	UnsubscribeMailto string
+	UnsubscribeURL    string
+	// Headers are extra header fields for every message, by name; values
+	// are templates like the subject.
+	Headers map[string]string
 }
 
 // Parse decodes TOML-formatted configuration bytes into a MailConfig.
@@ -88,22 +246,76 @@ func Parse(bs []byte) (MailConfig, error) {
 		return MailConfig{}, formatValidationError(err)
 	}
 
-	if strings.TrimSpace(tc.General.Subject) == "" {
-		return MailConfig{}, fmt.Errorf("subject must not be empty or whitespace")
+	if tc.General.Subject != "" && strings.TrimSpace(tc.General.Subject) == "" {
+		return MailConfig{}, fmt.Errorf("subject must not be whitespace")
+	}
+
+	if err := CheckHeaders(tc.General.Headers); err != nil {
+		return MailConfig{}, fmt.Errorf("[general] headers: %w", err)
 	}
 
 	recipients, err := convertRecipients(tc.Recipients)
 	if err != nil {
 		return MailConfig{}, err
 	}
+	if tc.General.RecipientsCSV != "" {
+		csvRecipients, err := loadRecipientsCSV(tc.General.RecipientsCSV)
+		if err != nil {
+			return MailConfig{}, err
+		}
+		recipients = append(recipients, csvRecipients...)
+	}
+	if len(recipients) == 0 {
+		return MailConfig{}, fmt.Errorf("no [[recipients]] entries found (and no recipients_csv in [general])")
+	}
+
+	engine := tc.General.TemplateEngine
+	if engine == "" {
+		engine = TemplateEnginePlaceholder
+	}
 
 	cfg := MailConfig{
-		From:        tc.General.From,
-		Subject:     tc.General.Subject,
-		ReplyTo:     tc.General.ReplyTo,
-		Cc:          tc.General.Cc,
-		Attachments: tc.General.Attachments,
-		Recipients:  recipients,
+		From:           tc.General.From,
+		Subject:        tc.General.Subject,
+		ReplyTo:        tc.General.ReplyTo,
+		Cc:             tc.General.Cc,
+		Bcc:            tc.General.Bcc,
+		Attachments:    tc.General.Attachments,
+		Recipients:     recipients,
+		RecipientsCSV:  tc.General.RecipientsCSV,
+		TemplateEngine: engine,
+		HTMLTemplate:   tc.General.HTMLTemplate,
+
+		SuppressionList: tc.General.SuppressionList,
+		SuppressionMode: tc.General.SuppressionMode,
+
+		UnsubscribeMailto: tc.General.UnsubscribeMailto,
+		UnsubscribeURL:    tc.General.UnsubscribeURL,
+		Headers:           tc.General.Headers,
+	}
+	if cfg.SuppressionMode == "" {
+		cfg.SuppressionMode = SuppressionSkip
+	}
+	if tc.SMIME != nil {
+		cfg.SMIME = &SMIMEConfig{Cert: tc.SMIME.Cert, Key: tc.SMIME.Key}
+	}
+	if tc.PGP != nil {
+		cfg.PGP = &PGPConfig{SecretKey: tc.PGP.SecretKey, Keyring: tc.PGP.Keyring, MissingKey: tc.PGP.MissingKey}
+		if cfg.PGP.MissingKey == "" {
+			cfg.PGP.MissingKey = PGPMissingKeyFail
+		}
+	}
+	if err := checkPGP(cfg); err != nil {
+		return MailConfig{}, err
+	}
+	if tc.DKIM != nil {
+		cfg.DKIM = &DKIMConfig{
+			PrivateKey:       tc.DKIM.PrivateKey,
+			Selector:         tc.DKIM.Selector,
+			Domain:           tc.DKIM.Domain,
+			Headers:          tc.DKIM.Headers,
+			Canonicalization: tc.DKIM.Canonicalization,
+		}
 	}
 
 	return cfg, nil
@@ -120,10 +332,22 @@ func formatValidationError(err error) error {
 		switch fe.StructNamespace() {
 		case "tomlConfig.General.From":
 			msgs = append(msgs, "missing required key 'from' in [general]")
-		case "tomlConfig.General.Subject":
-			msgs = append(msgs, "missing required key 'subject' in [general]")
-		case "tomlConfig.Recipients":
-			msgs = append(msgs, "no [[recipients]] entries found")
+		case "tomlConfig.General.TemplateEngine":
+			msgs = append(msgs, fmt.Sprintf("template_engine in [general] must be %q or %q, got %q", TemplateEnginePlaceholder, TemplateEngineGo, fe.Value()))
+		case "tomlConfig.DKIM.PrivateKey":
+			msgs = append(msgs, "missing required key 'private_key' in [dkim]")
+		case "tomlConfig.DKIM.Selector":
+			msgs = append(msgs, "missing required key 'selector' in [dkim]")
+		case "tomlConfig.SMIME.Cert":
+			msgs = append(msgs, "missing required key 'cert' in [smime]")
+		case "tomlConfig.SMIME.Key":
+			msgs = append(msgs, "missing required key 'key' in [smime]")
+		case "tomlConfig.General.SuppressionMode":
+			msgs = append(msgs, fmt.Sprintf("suppression_mode in [general] must be %q or %q, got %q", SuppressionSkip, SuppressionWarn, fe.Value()))
+		case "tomlConfig.PGP.MissingKey":
+			msgs = append(msgs, fmt.Sprintf("missing_key in [pgp] must be %q, %q or %q, got %q", PGPMissingKeySkip, PGPMissingKeyPlaintext, PGPMissingKeyFail, fe.Value()))
+		case "tomlConfig.DKIM.Canonicalization":
+			msgs = append(msgs, fmt.Sprintf("canonicalization in [dkim] must be simple or relaxed, optionally as header/body (e.g. \"relaxed/simple\"), got %q", fe.Value()))
 		default:
 			field := fe.StructNamespace()
 			if fe.Tag() == "required" {
@@ -143,40 +367,123 @@ func formatValidationError(err error) error {
 // silently shadowed during substitution, so it is rejected at parse time.
 var reservedDataKeys = map[string]struct{}{"EA": {}, "FN": {}, "LN": {}}
 
-// convertRecipients transforms TOML recipient entries into Recipient structs.
-// Data keys are upper-cased to match %KEY% placeholders; it returns an error if
-// two keys collide after folding, or a key collides with a reserved placeholder,
-// since either case would silently drop or nondeterministically pick a value.
+// foldDataKey upper-cases data key k to match %KEY% placeholders. It returns
+// an error if the folded key collides with a reserved placeholder or with a key
+// already present in seen, since either case would silently drop or
+// nondeterministically pick a value.
+func foldDataKey(k string, seen map[string]string) (string, error) {
+	key := strings.ToUpper(k)
+	if _, ok := reservedDataKeys[key]; ok {
+		return "", fmt.Errorf("data key %q collides with reserved placeholder %%%s%%", k, key)
+	}
+	if _, ok := seen[key]; ok {
+		return "", fmt.Errorf("data keys collide after upper-casing to %q", key)
+	}
+	return key, nil
+}
+
+// checkLiteralAddress checks an address field that is not a template, i.e.
+// has no "%" or "{{", so a typo shows up in -validate before any rendering.
+// Templated values are checked once rendered (see email.PrepMails).
+func checkLiteralAddress(value string) error {
+	if value == "" || strings.Contains(value, "%") || strings.Contains(value, "{{") {
+		return nil
+	}
+	if _, err := mail.ParseAddress(value); err != nil {
+		return fmt.Errorf("invalid address %q: %w", value, err)
+	}
+	return nil
+}
+
+// convertRecipients transforms TOML recipient entries into Recipient structs,
+// folding data keys with foldDataKey. String and list values share one key
+// space, so a list may not shadow a string key or vice versa.
 func convertRecipients(entries []tomlRecipient) ([]Recipient, error) {
 	recipients := make([]Recipient, 0, len(entries))
 
-	for _, e := range entries {
+	for i, e := range entries {
+		if e.Subject != "" && strings.TrimSpace(e.Subject) == "" {
+			return nil, fmt.Errorf("recipient %q: subject must not be whitespace", e.Email)
+		}
+		for _, field := range []struct{ name, value string }{{"from", e.From}, {"reply_to", e.ReplyTo}} {
+			if err := checkLiteralAddress(field.value); err != nil {
+				return nil, fmt.Errorf("[[recipients]] entry %d (%q): %s: %w", i+1, e.Email, field.name, err)
+			}
+		}
 		data := make(map[string]string, len(e.Data))
+		var lists map[string][]string
+		seen := make(map[string]string, len(e.Data))
 		for k, v := range e.Data {
-			key := strings.ToUpper(k)
-			if _, ok := reservedDataKeys[key]; ok {
-				return nil, fmt.Errorf("recipient %q: data key %q collides with reserved placeholder %%%s%%", e.Email, k, key)
+			key, err := foldDataKey(k, seen)
+			if err != nil {
+				return nil, fmt.Errorf("recipient %q: %w", e.Email, err)
 			}
-			if _, ok := data[key]; ok {
-				return nil, fmt.Errorf("recipient %q: data keys collide after upper-casing to %q", e.Email, key)
+			seen[key] = k
+			if !v.isList {
+				data[key] = v.str
+				continue
 			}
-			data[key] = v
+			if lists == nil {
+				lists = make(map[string][]string)
+			}
+			lists[key] = v.list
+		}
+		if err := CheckHeaders(e.Headers); err != nil {
+			return nil, fmt.Errorf("recipient %q: headers: %w", e.Email, err)
+		}
+		if err := CheckHeaders(e.HeadersExtra); err != nil {
+			return nil, fmt.Errorf("recipient %q: headers_extra: %w", e.Email, err)
 		}
 
 		recipients = append(recipients, Recipient{
 			Email:            e.Email,
 			First:            e.First,
 			Last:             e.Last,
+			From:             e.From,
+			ReplyTo:          e.ReplyTo,
+			Subject:          e.Subject,
 			Data:             data,
+			Lists:            lists,
 			Cc:               e.Cc,
 			CcExtra:          e.CcExtra,
+			Bcc:              e.Bcc,
+			BccExtra:         e.BccExtra,
 			Attachments:      e.Attachments,
 			AttachmentsExtra: e.AttachmentsExtra,
+			SMIMECert:        e.SMIMECert,
+			PGPKey:           e.PGPKey,
+			Headers:          e.Headers,
+			HeadersExtra:     e.HeadersExtra,
 		})
 	}
 	return recipients, nil
 }
 
+// checkPGP rejects OpenPGP settings that cannot take effect or that conflict
+// with S/MIME: a message is protected by one or the other, not both.
+func checkPGP(cfg MailConfig) error {
+	if cfg.PGP == nil {
+		for _, r := range cfg.Recipients {
+			if r.PGPKey != "" {
+				return fmt.Errorf("recipient %q: pgp_key requires a [pgp] section", r.Email)
+			}
+		}
+		return nil
+	}
+	if cfg.PGP.SecretKey == "" && !cfg.PGP.Encrypts(cfg.Recipients) {
+		return fmt.Errorf("[pgp] needs secret_key, keyring or a recipient pgp_key")
+	}
+	if cfg.SMIME != nil {
+		return fmt.Errorf("[pgp] and [smime] cannot be combined")
+	}
+	for _, r := range cfg.Recipients {
+		if r.SMIMECert != "" {
+			return fmt.Errorf("recipient %q: smime_cert cannot be combined with [pgp]", r.Email)
+		}
+	}
+	return nil
+}
+
 //go:embed samples/config.toml
 var sampleConfigContent string
 
@@ -82,15 +82,15 @@ first = "A"
 }
 
 func TestParseMissingSubject(t *testing.T) {
-	_, err := Parse([]byte(`
+	cfg, err := Parse([]byte(`
 [general]
 from = "x <x@x.com>"
 [[recipients]]
 email = "a@b.com"
 first = "A"
 `))
-	require.Error(t, err)
-	assert.Contains(t, err.Error(), "missing required key 'subject'")
+	require.NoError(t, err, "the subject may come from the template front matter")
+	assert.Empty(t, cfg.Subject)
 }
 
 func TestParseNoRecipients(t *testing.T) {
@@ -116,6 +116,8 @@ from = "Frodo Baggins <rts@example.com>"
 cc = ["weirdo@nsb.gov", "cc@example.com"]
 reply_to = "John Doe <jd@mail.com>"
 subject = "Hello %FN%!"
+unsubscribe_mailto = "unsubscribe@example.com"
+unsubscribe_url = "https://example.com/unsubscribe?email=%EA%"
 
 [[recipients]]
 email = "jd@example.com"
@@ -134,6 +136,8 @@ data = { ORG = "Disney" }
 	assert.Equal(t, "John Doe <jd@mail.com>", cfg.ReplyTo)
 	assert.Equal(t, "Hello %FN%!", cfg.Subject)
 	assert.Equal(t, []string{"weirdo@nsb.gov", "cc@example.com"}, cfg.Cc)
+	assert.Equal(t, "unsubscribe@example.com", cfg.UnsubscribeMailto)
+	assert.Equal(t, "https://example.com/unsubscribe?email=%EA%", cfg.UnsubscribeURL)
 
 	expected := []Recipient{
 		{Email: "jd@example.com", First: "John", Last: "Doe Jr.", Data: map[string]string{"ORG": "EFF", "TITLE": "PhD"}},
@@ -226,6 +230,81 @@ cc_extra = ["extra@cc.com"]
 	assert.Equal(t, []string{"extra@cc.com"}, cfg.Recipients[0].CcExtra)
 }
 
+func TestParseRecipientSenderOverrides(t *testing.T) {
+	cfg := parseTestConfig(t, []byte(`
+[general]
+from = "test <t@example.com>"
+subject = "test"
+[[recipients]]
+email = "a@b.com"
+first = "A"
+from = "%MANAGER% <%MANAGER_EMAIL%>"
+reply_to = "support-emea@example.com"
+subject = "Hallo %FN%"
+`))
+	require.Len(t, cfg.Recipients, 1)
+	assert.Equal(t, "%MANAGER% <%MANAGER_EMAIL%>", cfg.Recipients[0].From)
+	assert.Equal(t, "support-emea@example.com", cfg.Recipients[0].ReplyTo)
+	assert.Equal(t, "Hallo %FN%", cfg.Recipients[0].Subject)
+
+	_, err := Parse([]byte(`
+[general]
+from = "test <t@example.com>"
+subject = "test"
+[[recipients]]
+email = "a@b.com"
+first = "A"
+subject = "  "
+`))
+	assert.ErrorContains(t, err, `recipient "a@b.com": subject must not be whitespace`)
+
+	_, err = Parse([]byte(`
+[general]
+from = "test <t@example.com>"
+subject = "test"
+[[recipients]]
+email = "a@b.com"
+first = "A"
+[[recipients]]
+email = "c@d.com"
+first = "C"
+reply_to = "support at example.com"
+`))
+	assert.ErrorContains(t, err, `[[recipients]] entry 2 ("c@d.com"): reply_to: invalid address "support at example.com"`)
+
+	_, err = Parse([]byte(`
+[general]
+from = "test <t@example.com>"
+subject = "test"
+[[recipients]]
+email = "a@b.com"
+first = "A"
+from = "Ann <ann@example.com"
+`))
+	assert.ErrorContains(t, err, `[[recipients]] entry 1 ("a@b.com"): from: invalid address "Ann <ann@example.com"`)
+}
+
+func TestParseBcc(t *testing.T) {
+	cfg := parseTestConfig(t, []byte(`
+[general]
+from = "test <t@example.com>"
+subject = "test"
+bcc = ["archive@example.com"]
+[[recipients]]
+email = "a@b.com"
+first = "A"
+bcc = ["supervisor@example.com"]
+[[recipients]]
+email = "c@d.com"
+first = "C"
+bcc_extra = ["audit@example.com"]
+`))
+	assert.Equal(t, []string{"archive@example.com"}, cfg.Bcc)
+	sortRecipients(cfg.Recipients)
+	assert.Equal(t, []string{"audit@example.com"}, cfg.Recipients[0].BccExtra)
+	assert.Equal(t, []string{"supervisor@example.com"}, cfg.Recipients[1].Bcc)
+}
+
 func TestParseRecipientAttachReplace(t *testing.T) {
 	cfg := parseTestConfig(t, []byte(`
 [general]
@@ -322,7 +401,7 @@ email = "a@b.com"
 first = "Alice"
 `))
 	require.Error(t, err)
-	assert.Contains(t, err.Error(), "subject must not be empty")
+	assert.Contains(t, err.Error(), "subject must not be whitespace")
 }
 
 func TestSampleConfigParses(t *testing.T) {
@@ -343,3 +422,195 @@ func TestSampleTemplateNotEmpty(t *testing.T) {
 	assert.Contains(t, tmpl, "%FN%")
 	assert.Contains(t, tmpl, "%LN%")
 }
+
+func TestParseDataListValues(t *testing.T) {
+	cfg := parseTestConfig(t, []byte(`
+[general]
+from = "test <t@example.com>"
+subject = "test"
+template_engine = "go"
+[[recipients]]
+email = "a@b.com"
+first = "A"
+data = { org = "EFF", items = ["laptop", "badge"] }
+`))
+	require.Len(t, cfg.Recipients, 1)
+	assert.Equal(t, TemplateEngineGo, cfg.TemplateEngine)
+	assert.Equal(t, map[string]string{"ORG": "EFF"}, cfg.Recipients[0].Data)
+	assert.Equal(t, map[string][]string{"ITEMS": {"laptop", "badge"}}, cfg.Recipients[0].Lists)
+}
+
+func TestParseDataInvalidValue(t *testing.T) {
+	_, err := Parse([]byte(`
+[general]
+from = "test <t@example.com>"
+subject = "test"
+[[recipients]]
+email = "a@b.com"
+first = "A"
+data = { age = 42 }
+`))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "data values must be strings or lists of strings")
+}
+
+func TestParseDataListKeyCollision(t *testing.T) {
+	_, err := Parse([]byte(`
+[general]
+from = "test <t@example.com>"
+subject = "test"
+[[recipients]]
+email = "a@b.com"
+first = "A"
+data = { items = "x", ITEMS = ["y"] }
+`))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "collide after upper-casing")
+}
+
+func TestParseTemplateEngine(t *testing.T) {
+	cfg := parseTestConfig(t, []byte(`
+[general]
+from = "test <t@example.com>"
+subject = "test"
+[[recipients]]
+email = "a@b.com"
+first = "A"
+`))
+	assert.Equal(t, TemplateEnginePlaceholder, cfg.TemplateEngine)
+
+	_, err := Parse([]byte(`
+[general]
+from = "test <t@example.com>"
+subject = "test"
+template_engine = "jinja"
+[[recipients]]
+email = "a@b.com"
+first = "A"
+`))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `template_engine in [general] must be "placeholder" or "go", got "jinja"`)
+}
+
+func TestParseDKIM(t *testing.T) {
+	base := `
+[general]
+from = "test <t@example.com>"
+subject = "test"
+[[recipients]]
+email = "a@b.com"
+first = "A"
+`
+	cfg := parseTestConfig(t, []byte(base))
+	assert.Nil(t, cfg.DKIM)
+
+	cfg = parseTestConfig(t, []byte(base+`
+[dkim]
+private_key = "dkim.pem"
+selector = "gmt"
+domain = "example.com"
+headers = ["From", "Subject"]
+canonicalization = "relaxed/simple"
+`))
+	require.NotNil(t, cfg.DKIM)
+	assert.Equal(t, DKIMConfig{PrivateKey: "dkim.pem", Selector: "gmt", Domain: "example.com", Headers: []string{"From", "Subject"}, Canonicalization: "relaxed/simple"}, *cfg.DKIM)
+
+	_, err := Parse([]byte(base + `
+[dkim]
+private_key = "dkim.pem"
+`))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "missing required key 'selector' in [dkim]")
+
+	_, err = Parse([]byte(base + `
+[dkim]
+private_key = "dkim.pem"
+selector = "gmt"
+canonicalization = "loose"
+`))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "canonicalization in [dkim] must be simple or relaxed")
+}
+
+func TestParseSMIME(t *testing.T) {
+	base := `
+[general]
+from = "test <t@example.com>"
+subject = "test"
+[[recipients]]
+email = "a@b.com"
+first = "A"
+smime_cert = "certs/a.pem"
+`
+	cfg := parseTestConfig(t, []byte(base))
+	assert.Nil(t, cfg.SMIME)
+	assert.Equal(t, "certs/a.pem", cfg.Recipients[0].SMIMECert)
+
+	cfg = parseTestConfig(t, []byte(base+`
+[smime]
+cert = "signer.pem"
+key = "signer.key"
+`))
+	require.NotNil(t, cfg.SMIME)
+	assert.Equal(t, SMIMEConfig{Cert: "signer.pem", Key: "signer.key"}, *cfg.SMIME)
+
+	_, err := Parse([]byte(base + `
+[smime]
+cert = "signer.pem"
+`))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "missing required key 'key' in [smime]")
+}
+
+func TestParsePGP(t *testing.T) {
+	base := `
+[general]
+from = "test <t@example.com>"
+subject = "test"
+[[recipients]]
+email = "a@b.com"
+first = "A"
+pgp_key = "keys/a.asc"
+`
+	_, err := Parse([]byte(base))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "pgp_key requires a [pgp] section")
+
+	cfg := parseTestConfig(t, []byte(base+`
+[pgp]
+secret_key = "signer.asc"
+`))
+	require.NotNil(t, cfg.PGP)
+	assert.Equal(t, PGPConfig{SecretKey: "signer.asc", MissingKey: PGPMissingKeyFail}, *cfg.PGP)
+	assert.Equal(t, "keys/a.asc", cfg.Recipients[0].PGPKey)
+	assert.True(t, cfg.PGP.Encrypts(cfg.Recipients))
+
+	tests := []struct {
+		name    string
+		extra   string
+		wantErr string
+	}{
+		{"bad policy", "[pgp]\nkeyring = \"k.asc\"\nmissing_key = \"ignore\"\n", `missing_key in [pgp] must be "skip", "plaintext" or "fail", got "ignore"`},
+		{"with smime", "[pgp]\nkeyring = \"k.asc\"\n[smime]\ncert = \"c.pem\"\nkey = \"k.pem\"\n", "[pgp] and [smime] cannot be combined"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse([]byte(base + tt.extra))
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+		})
+	}
+
+	_, err = Parse([]byte(`
+[general]
+from = "test <t@example.com>"
+subject = "test"
+[[recipients]]
+email = "a@b.com"
+first = "A"
+[pgp]
+missing_key = "skip"
+`))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "[pgp] needs secret_key, keyring or a recipient pgp_key")
+}
@@ -0,0 +1,226 @@
+// gmt sends emails in bulk based on a template and a config file.
+// Copyright (C) 2019-2025  "Muharem Hrnjadovic" <muharem@linux.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package config
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// csvListSeparator separates the values of a list-valued cell (cc,
+// attachments, ...), since the comma is already the field separator.
+const csvListSeparator = ";"
+
+// csvFixedColumns are the column names mapped to Recipient fields rather than
+// to data keys. The list-valued ones (cc, attachments, ...) carry the same
+// replace/append semantics as their [[recipients]] counterparts.
+var csvFixedColumns = map[string]struct{}{
+	"email": {}, "first": {}, "last": {},
+	"cc": {}, "cc_extra": {}, "bcc": {}, "bcc_extra": {}, "attachments": {}, "attachments_extra": {},
+	"smime_cert": {}, "pgp_key": {},
+}
+
+// loadRecipientsCSV reads recipients from the CSV file at path. The first row
+// is a header naming the columns: "email", "first" and "last" map to the fixed
+// recipient fields, list columns (cc, attachments, ...) hold csvListSeparator
+// delimited values, and every other column becomes a data key. Errors name the
+// file, row and column (both 1-based, the header being row 1).
+func loadRecipientsCSV(path string) ([]Recipient, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read recipients_csv %q: %w", path, err)
+	}
+	defer f.Close() //nolint:errcheck
+
+	recipients, err := parseRecipientsCSV(f)
+	if err != nil {
+		return nil, fmt.Errorf("recipients_csv %q: %w", path, err)
+	}
+	if len(recipients) == 0 {
+		return nil, fmt.Errorf("recipients_csv %q: no recipient rows found", path)
+	}
+	return recipients, nil
+}
+
+// csvColumn describes one header column: its 1-based position, its name as
+// written, and the data key it maps to (empty for fixed columns).
+type csvColumn struct {
+	pos     int
+	name    string
+	dataKey string
+}
+
+// parseRecipientsCSV decodes CSV recipient rows from r; see loadRecipientsCSV.
+func parseRecipientsCSV(r io.Reader) ([]Recipient, error) {
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("missing header row")
+	}
+	if err != nil {
+		return nil, formatCSVError(err, 1)
+	}
+	columns, err := parseCSVHeader(header)
+	if err != nil {
+		return nil, err
+	}
+
+	var recipients []Recipient
+	for row := 2; ; row++ {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, formatCSVError(err, row)
+		}
+		rcpt, err := csvRecipient(columns, record, row)
+		if err != nil {
+			return nil, err
+		}
+		recipients = append(recipients, rcpt)
+	}
+	return recipients, nil
+}
+
+// parseCSVHeader maps header names to columns. Fixed and list column names are
+// matched case-insensitively; data columns are folded with foldDataKey so they
+// get the same reserved-key and case-collision checks as [[recipients]] data.
+func parseCSVHeader(header []string) ([]csvColumn, error) {
+	columns := make([]csvColumn, len(header))
+	fixed := make(map[string]int)
+	data := make(map[string]string)
+	for i, raw := range header {
+		name := strings.TrimSpace(raw)
+		if i == 0 {
+			// Spreadsheet exports often start with a UTF-8 byte order mark.
+			name = strings.TrimPrefix(name, "\ufeff")
+		}
+		col := csvColumn{pos: i + 1, name: name}
+		if name == "" {
+			return nil, fmt.Errorf("row 1, column %d: empty column name", col.pos)
+		}
+
+		lower := strings.ToLower(name)
+		if _, ok := csvFixedColumns[lower]; ok {
+			if prev, ok := fixed[lower]; ok {
+				return nil, fmt.Errorf("row 1, column %d: duplicate column %q (first seen in column %d)", col.pos, name, prev)
+			}
+			fixed[lower] = col.pos
+			col.name = lower
+		} else {
+			key, err := foldDataKey(name, data)
+			if err != nil {
+				return nil, fmt.Errorf("row 1, column %d: %w", col.pos, err)
+			}
+			data[key] = name
+			col.dataKey = key
+		}
+		columns[i] = col
+	}
+
+	for _, required := range []string{"email", "first"} {
+		if _, ok := fixed[required]; !ok {
+			return nil, fmt.Errorf("row 1: missing required column %q", required)
+		}
+	}
+	return columns, nil
+}
+
+// csvRecipient converts one data row into a Recipient, applying the same
+// required-field and email checks as the [[recipients]] validation tags.
+func csvRecipient(columns []csvColumn, record []string, row int) (Recipient, error) {
+	rcpt := Recipient{Data: make(map[string]string)}
+	for i, col := range columns {
+		value := strings.TrimSpace(record[i])
+		if col.dataKey != "" {
+			rcpt.Data[col.dataKey] = value
+			continue
+		}
+		switch col.name {
+		case "email":
+			rcpt.Email = value
+		case "first":
+			rcpt.First = value
+		case "last":
+			rcpt.Last = value
+		case "cc":
+			rcpt.Cc = splitCSVList(value)
+		case "cc_extra":
+			rcpt.CcExtra = splitCSVList(value)
+		case "bcc":
+			rcpt.Bcc = splitCSVList(value)
+		case "bcc_extra":
+			rcpt.BccExtra = splitCSVList(value)
+		case "attachments":
+			rcpt.Attachments = splitCSVList(value)
+		case "attachments_extra":
+			rcpt.AttachmentsExtra = splitCSVList(value)
+		case "smime_cert":
+			rcpt.SMIMECert = value
+		case "pgp_key":
+			rcpt.PGPKey = value
+		}
+	}
+
+	for _, col := range columns {
+		switch col.name {
+		case "email":
+			if rcpt.Email == "" {
+				return Recipient{}, fmt.Errorf("row %d, column %d (email): missing required value", row, col.pos)
+			}
+			if err := validate.Var(rcpt.Email, "email"); err != nil {
+				return Recipient{}, fmt.Errorf("row %d, column %d (email): invalid email address %q", row, col.pos, rcpt.Email)
+			}
+		case "first":
+			if rcpt.First == "" {
+				return Recipient{}, fmt.Errorf("row %d, column %d (first): missing required value", row, col.pos)
+			}
+		}
+	}
+	return rcpt, nil
+}
+
+// splitCSVList splits a list-valued cell on csvListSeparator, dropping blank
+// entries. An empty cell yields nil so it does not override the global list.
+func splitCSVList(cell string) []string {
+	var values []string
+	for v := range strings.SplitSeq(cell, csvListSeparator) {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// formatCSVError reports an encoding/csv parse error against the record (row)
+// being read, matching the wording of the other recipients_csv errors; the
+// csv package itself counts physical lines, which differ once a quoted cell
+// spans several lines.
+func formatCSVError(err error, row int) error {
+	var pe *csv.ParseError
+	if errors.As(err, &pe) {
+		return fmt.Errorf("row %d: %w", row, pe.Err)
+	}
+	return fmt.Errorf("row %d: %w", row, err)
+}
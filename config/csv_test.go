@@ -0,0 +1,133 @@
+// gmt sends emails in bulk based on a template and a config file.
+// Copyright (C) 2019-2025  "Muharem Hrnjadovic" <muharem@linux.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeCSV writes content to a temp CSV file and returns its path.
+func writeCSV(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "recipients.csv")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	return path
+}
+
+func TestParseRecipientsCSV(t *testing.T) {
+	path := writeCSV(t, "email,first,last,org,cc,bcc_extra,attachments_extra,smime_cert\n"+
+		"jd@example.com,John,Doe,EFF,a@cc.com; b@cc.com,,,certs/jd.pem\n"+
+		"mm@gmail.com,Mickey,,Disney,,audit@example.com,x.pdf;y.pdf,\n")
+
+	cfg := parseTestConfig(t, []byte(`
+[general]
+from = "test <t@example.com>"
+subject = "test"
+recipients_csv = "`+path+`"
+`))
+
+	expected := []Recipient{
+		{Email: "jd@example.com", First: "John", Last: "Doe", Data: map[string]string{"ORG": "EFF"}, Cc: []string{"a@cc.com", "b@cc.com"}, SMIMECert: "certs/jd.pem"},
+		{Email: "mm@gmail.com", First: "Mickey", Data: map[string]string{"ORG": "Disney"}, BccExtra: []string{"audit@example.com"}, AttachmentsExtra: []string{"x.pdf", "y.pdf"}},
+	}
+	assert.Equal(t, expected, cfg.Recipients)
+}
+
+func TestParseRecipientsCSVAppendsToTables(t *testing.T) {
+	path := writeCSV(t, "Email,First\ncsv@example.com,Carla\n")
+
+	cfg := parseTestConfig(t, []byte(`
+[general]
+from = "test <t@example.com>"
+subject = "test"
+recipients_csv = "`+path+`"
+
+[[recipients]]
+email = "toml@example.com"
+first = "Tom"
+`))
+	require.Len(t, cfg.Recipients, 2)
+	assert.Equal(t, "toml@example.com", cfg.Recipients[0].Email)
+	assert.Equal(t, "csv@example.com", cfg.Recipients[1].Email)
+}
+
+func TestParseRecipientsCSVByteOrderMark(t *testing.T) {
+	path := writeCSV(t, "\ufeffemail,first\na@b.com,Alice\n")
+
+	cfg := parseTestConfig(t, []byte(`
+[general]
+from = "test <t@example.com>"
+subject = "test"
+recipients_csv = "`+path+`"
+`))
+	require.Len(t, cfg.Recipients, 1)
+	assert.Equal(t, "a@b.com", cfg.Recipients[0].Email)
+}
+
+func TestParseRecipientsCSVErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{"missing email column", "first,last\nJohn,Doe\n", `missing required column "email"`},
+		{"duplicate column", "email,first,EMAIL\na@b.com,A,c@d.com\n", `row 1, column 3: duplicate column "EMAIL"`},
+		{"reserved data key", "email,first,fn\na@b.com,A,x\n", "row 1, column 3: data key \"fn\" collides with reserved placeholder %FN%"},
+		{"case collision", "email,first,url,URL\na@b.com,A,x,y\n", "row 1, column 4: data keys collide after upper-casing"},
+		{"invalid email", "email,first\na@b.com,A\nnot-an-email,B\n", `row 3, column 1 (email): invalid email address "not-an-email"`},
+		{"missing first", "email,last,first\na@b.com,Doe,\n", "row 2, column 3 (first): missing required value"},
+		{"wrong field count", "email,first\na@b.com,A,extra\n", "row 2: wrong number of fields"},
+		{"header only", "email,first\n", "no recipient rows found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeCSV(t, tt.content)
+			_, err := Parse([]byte(`
+[general]
+from = "test <t@example.com>"
+subject = "test"
+recipients_csv = "` + path + `"
+`))
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+			assert.True(t, strings.HasPrefix(err.Error(), "recipients_csv "), err.Error())
+		})
+	}
+}
+
+func TestParseRecipientsCSVMissingFile(t *testing.T) {
+	_, err := Parse([]byte(`
+[general]
+from = "test <t@example.com>"
+subject = "test"
+recipients_csv = "/nonexistent/recipients.csv"
+`))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to read recipients_csv")
+}
+
+func TestSplitCSVList(t *testing.T) {
+	assert.Nil(t, splitCSVList(""))
+	assert.Nil(t, splitCSVList(" ; "))
+	assert.Equal(t, []string{"a", "b"}, splitCSVList("a; ;b;"))
+}
@@ -0,0 +1,94 @@
+// gmt sends emails in bulk based on a template and a config file.
+// Copyright (C) 2019-2025  "Muharem Hrnjadovic" <muharem@linux.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// structuralHeaders are written by gmt itself, from the addresses, subject
+// and body of each message or by signing, and cannot be set with headers.
+// Keys are lower-case.
+var structuralHeaders = map[string]string{
+	"from":                      "",
+	"sender":                    "",
+	"to":                        "",
+	"cc":                        "",
+	"bcc":                       "",
+	"reply-to":                  "",
+	"subject":                   "",
+	"date":                      "",
+	"message-id":                "",
+	"mime-version":              "",
+	"content-type":              "",
+	"content-transfer-encoding": "",
+	"content-disposition":       "",
+	"return-path":               "",
+	"dkim-signature":            "",
+	"list-unsubscribe":          "use unsubscribe_mailto or unsubscribe_url",
+	"list-unsubscribe-post":     "use unsubscribe_url",
+}
+
+// CheckHeaderName returns an error if name is not a valid header field name
+// (RFC 5322: printable ASCII other than ':') or names a header gmt writes
+// itself.
+func CheckHeaderName(name string) error {
+	if name == "" {
+		return fmt.Errorf("empty header name")
+	}
+	for _, c := range name {
+		if c <= ' ' || c > '~' || c == ':' {
+			return fmt.Errorf("invalid header name %q", name)
+		}
+	}
+	if hint, ok := structuralHeaders[strings.ToLower(name)]; ok {
+		if hint != "" {
+			return fmt.Errorf("header %q cannot be set in headers; %s", name, hint)
+		}
+		return fmt.Errorf("header %q is set by gmt and cannot be overridden", name)
+	}
+	return nil
+}
+
+// CheckHeaderValue returns an error if value could end the header field it
+// is written to and start another (header injection).
+func CheckHeaderValue(name, value string) error {
+	if strings.ContainsAny(value, "\r\n\x00") {
+		return fmt.Errorf("header %q: value must not contain line breaks or NUL: %q", name, value)
+	}
+	return nil
+}
+
+// CheckHeaders checks the names and values of a headers table. Names that
+// differ only in case would set the same field, so they are rejected too.
+func CheckHeaders(headers map[string]string) error {
+	seen := make(map[string]string, len(headers))
+	for name, value := range headers {
+		if err := CheckHeaderName(name); err != nil {
+			return err
+		}
+		if other, ok := seen[strings.ToLower(name)]; ok {
+			return fmt.Errorf("headers %q and %q name the same field", other, name)
+		}
+		seen[strings.ToLower(name)] = name
+		if err := CheckHeaderValue(name, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+// gmt sends emails in bulk based on a template and a config file.
+// Copyright (C) 2019-2025  "Muharem Hrnjadovic" <muharem@linux.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseHeaders(t *testing.T) {
+	cfg := parseTestConfig(t, []byte(`
+[general]
+from = "test <t@example.com>"
+subject = "test"
+headers = { X-Campaign-ID = "autumn", Precedence = "bulk" }
+
+[[recipients]]
+email = "a@b.com"
+first = "A"
+headers = { Organization = "%ORG%" }
+
+[[recipients]]
+email = "c@d.com"
+first = "C"
+headers_extra = { precedence = "list" }
+`))
+	assert.Equal(t, map[string]string{"X-Campaign-ID": "autumn", "Precedence": "bulk"}, cfg.Headers)
+	sortRecipients(cfg.Recipients)
+	assert.Equal(t, map[string]string{"precedence": "list"}, cfg.Recipients[0].HeadersExtra)
+	assert.Equal(t, map[string]string{"Organization": "%ORG%"}, cfg.Recipients[1].Headers)
+}
+
+func TestParseHeadersErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		general string
+		rcpt    string
+		wantErr string
+	}{
+		{"structural", `headers = { From = "x@example.com" }`, "", `[general] headers: header "From" is set by gmt and cannot be overridden`},
+		{"structural any case", "", `headers_extra = { content-type = "text/html" }`, `recipient "a@b.com": headers_extra: header "content-type" is set by gmt`},
+		{"unsubscribe", `headers = { List-Unsubscribe = "<mailto:u@example.com>" }`, "", "use unsubscribe_mailto or unsubscribe_url"},
+		{"invalid name", `headers = { "X Campaign" = "1" }`, "", `invalid header name "X Campaign"`},
+		{"line break", `headers = { X-Campaign = "1\r\nBcc: evil@example.com" }`, "", `header "X-Campaign": value must not contain line breaks`},
+		{"same field", "", `headers = { X-Tag = "1", x-tag = "2" }`, "name the same field"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse([]byte(`
+[general]
+from = "test <t@example.com>"
+subject = "test"
+` + tt.general + `
+[[recipients]]
+email = "a@b.com"
+first = "A"
+` + tt.rcpt + "\n"))
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestCheckHeaderValue(t *testing.T) {
+	require.NoError(t, CheckHeaderValue("X-Tag", "a value: with punctuation"))
+	assert.Error(t, CheckHeaderValue("X-Tag", "a\nb"))
+	assert.Error(t, CheckHeaderValue("X-Tag", "a\rb"))
+}
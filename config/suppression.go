@@ -0,0 +1,333 @@
+// gmt sends emails in bulk based on a template and a config file.
+// Copyright (C) 2019-2025  "Muharem Hrnjadovic" <muharem@linux.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package config
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/mail"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// What to do with a recipient on the suppression list.
+const (
+	SuppressionSkip = "skip" // do not send to the recipient
+	SuppressionWarn = "warn" // send, but warn before sending
+)
+
+// suppressionHeader names the columns of a suppression list file.
+var suppressionHeader = []string{"address", "status", "diagnostic", "date"}
+
+// Suppression is an entry of a suppression list: an address (or domain or
+// pattern, see Suppressions) not to be mailed, e.g. because it bounced or
+// opted out. Only Address is required; the other fields record why.
+type Suppression struct {
+	Address    string
+	Status     string    // RFC 3463 status code, e.g. "5.1.1"
+	Diagnostic string    // the remote server's reply, e.g. "smtp; 550 5.1.1 no such user", or a note
+	Date       time.Time // when the address bounced; zero if unknown
+}
+
+// ReadSuppressionList reads a suppression list: a CSV file with the columns
+// address, status, diagnostic and date (RFC 3339), headed by a row naming
+// them. Rows may hold just an address, so addresses can be added by hand;
+// lines starting with # are comments.
+func ReadSuppressionList(path string) ([]Suppression, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read suppression list %q: %w", path, err)
+	}
+	defer f.Close() //nolint:errcheck
+
+	r := csv.NewReader(f)
+	r.Comment = '#'
+	r.FieldsPerRecord = -1
+	var list []Suppression
+	for row := 1; ; row++ {
+		rec, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			return list, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("suppression list %q: %w", path, err)
+		}
+		if row == 1 && strings.EqualFold(strings.TrimSpace(rec[0]), suppressionHeader[0]) {
+			continue
+		}
+		s := Suppression{Address: strings.TrimSpace(rec[0])}
+		if s.Address == "" {
+			return nil, fmt.Errorf("suppression list %q: row %d: empty address", path, row)
+		}
+		if len(rec) > 1 {
+			s.Status = strings.TrimSpace(rec[1])
+		}
+		if len(rec) > 2 {
+			s.Diagnostic = strings.TrimSpace(rec[2])
+		}
+		if len(rec) > 3 && strings.TrimSpace(rec[3]) != "" {
+			if s.Date, err = time.Parse(time.RFC3339, strings.TrimSpace(rec[3])); err != nil {
+				return nil, fmt.Errorf("suppression list %q: row %d: invalid date %q", path, row, rec[3])
+			}
+		}
+		list = append(list, s)
+	}
+}
+
+// WriteSuppressionList writes list to path. An existing file is updated in
+// place, since the list may be edited by hand: comment and blank lines stay
+// where they are, a row for an address on list is replaced by its entry, and
+// entries for addresses not yet in the file are added at the end. Rows for
+// addresses not on list are dropped. The result is written to a temporary
+// file in the same directory and renamed over path, so a crash or a full disk
+// never leaves the old list truncated; the file keeps its mode (0600 for a
+// new file).
+func WriteSuppressionList(path string, list []Suppression) error {
+	mode := os.FileMode(0o600)
+	var old string
+	if fi, err := os.Stat(path); err == nil {
+		mode = fi.Mode().Perm()
+		bs, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read suppression list %q: %w", path, err)
+		}
+		old = string(bs)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to read suppression list %q: %w", path, err)
+	}
+
+	entries := make(map[string]Suppression, len(list))
+	for _, e := range list {
+		entries[strings.ToLower(e.Address)] = e
+	}
+	written := make(map[string]bool, len(list))
+	var b strings.Builder
+	header := false
+	for line := range strings.Lines(old) {
+		text := strings.TrimRight(line, "\r\n")
+		if strings.TrimSpace(text) == "" || strings.HasPrefix(text, "#") {
+			b.WriteString(text + "\n")
+			continue
+		}
+		rec, err := csv.NewReader(strings.NewReader(text)).Read()
+		if err != nil {
+			b.WriteString(text + "\n") // e.g. one line of a multi-line record
+			continue
+		}
+		address := strings.TrimSpace(rec[0])
+		if !header && strings.EqualFold(address, suppressionHeader[0]) {
+			header = true
+			b.WriteString(text + "\n")
+			continue
+		}
+		header = true
+		key := strings.ToLower(address)
+		e, ok := entries[key]
+		if !ok || written[key] {
+			continue
+		}
+		written[key] = true
+		b.WriteString(suppressionRow(e))
+	}
+	if !header {
+		b.WriteString(strings.Join(suppressionHeader, ",") + "\n")
+	}
+	for _, e := range list {
+		if key := strings.ToLower(e.Address); !written[key] {
+			written[key] = true
+			b.WriteString(suppressionRow(e))
+		}
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write suppression list %q: %w", path, err)
+	}
+	tmp := f.Name()
+	_, err = f.WriteString(b.String())
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp, mode)
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("failed to write suppression list %q: %w", path, err)
+	}
+	return nil
+}
+
+// suppressionRow formats s as one CSV line of a suppression list. Line breaks
+// in the diagnostic are replaced by spaces, so every entry is a single line.
+func suppressionRow(s Suppression) string {
+	date := ""
+	if !s.Date.IsZero() {
+		date = s.Date.UTC().Format(time.RFC3339)
+	}
+	diagnostic := strings.NewReplacer("\r\n", " ", "\r", " ", "\n", " ").Replace(s.Diagnostic)
+	var b strings.Builder
+	w := csv.NewWriter(&b)
+	_ = w.Write([]string{s.Address, s.Status, diagnostic, date})
+	w.Flush()
+	return b.String()
+}
+
+// MergeSuppressions adds the entries of add to list. An address already on
+// the list keeps one entry: the one with the later date. The result is sorted
+// by address.
+func MergeSuppressions(list, add []Suppression) []Suppression {
+	byAddress := make(map[string]Suppression, len(list)+len(add))
+	for _, s := range slices.Concat(list, add) {
+		key := strings.ToLower(s.Address)
+		if old, ok := byAddress[key]; !ok || s.Date.After(old.Date) {
+			byAddress[key] = s
+		}
+	}
+	merged := make([]Suppression, 0, len(byAddress))
+	for _, s := range byAddress {
+		merged = append(merged, s)
+	}
+	slices.SortFunc(merged, func(a, b Suppression) int {
+		return strings.Compare(strings.ToLower(a.Address), strings.ToLower(b.Address))
+	})
+	return merged
+}
+
+// Suppressions is a loaded suppression list. Its entries are addresses,
+// domains ("example.com" or "@example.com") or wildcard patterns in the
+// syntax of path.Match ("*@example.com", "info@*", "*.example.com"), all
+// matched case-insensitively.
+type Suppressions struct {
+	exact    map[string]Suppression // by lower-cased address
+	patterns []suppressionPattern
+}
+
+// suppressionPattern is a domain or wildcard entry of a suppression list.
+type suppressionPattern struct {
+	glob   string // lower-cased
+	domain bool   // glob is matched against the domain only
+	entry  Suppression
+}
+
+// NewSuppressions indexes list for matching. It fails on a malformed
+// wildcard pattern.
+func NewSuppressions(list []Suppression) (*Suppressions, error) {
+	s := &Suppressions{exact: make(map[string]Suppression, len(list))}
+	for _, e := range list {
+		glob := strings.ToLower(e.Address)
+		if !isSuppressionPattern(glob) {
+			s.exact[glob] = e
+			continue
+		}
+		domain := !strings.Contains(glob, "@") || strings.HasPrefix(glob, "@")
+		glob = strings.TrimPrefix(glob, "@")
+		if _, err := path.Match(glob, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", e.Address, err)
+		}
+		s.patterns = append(s.patterns, suppressionPattern{glob: glob, domain: domain, entry: e})
+	}
+	return s, nil
+}
+
+// Len returns the number of entries.
+func (s *Suppressions) Len() int { return len(s.exact) + len(s.patterns) }
+
+// Match returns the entry that address matches, if any: the entry for the
+// address itself, or else the first matching domain or pattern. address may
+// include a display name, as in "Jane <jane@example.com>".
+func (s *Suppressions) Match(address string) (Suppression, bool) {
+	if a, err := mail.ParseAddress(address); err == nil {
+		address = a.Address
+	}
+	address = strings.ToLower(address)
+	if e, ok := s.exact[address]; ok {
+		return e, true
+	}
+	domain := address[strings.LastIndex(address, "@")+1:]
+	for _, p := range s.patterns {
+		subject := address
+		if p.domain {
+			subject = domain
+		}
+		if ok, _ := path.Match(p.glob, subject); ok {
+			return p.entry, true
+		}
+	}
+	return Suppression{}, false
+}
+
+// Reason describes why an address on the list is suppressed, e.g.
+// "bounced 5.1.1: smtp; 550 5.1.1 user unknown" or
+// `matches "*@example.com": opted out`.
+func (e Suppression) Reason() string {
+	var parts []string
+	if isSuppressionPattern(e.Address) {
+		parts = append(parts, fmt.Sprintf("matches %q", e.Address))
+	}
+	if e.Status != "" {
+		parts = append(parts, "bounced "+e.Status)
+	}
+	if e.Diagnostic != "" {
+		parts = append(parts, e.Diagnostic)
+	}
+	if len(parts) == 0 {
+		return "on the suppression list"
+	}
+	return strings.Join(parts, ": ")
+}
+
+// isSuppressionPattern reports whether a suppression list entry is a domain
+// or wildcard pattern rather than an address.
+func isSuppressionPattern(entry string) bool {
+	return !strings.Contains(entry, "@") || strings.HasPrefix(entry, "@") || strings.ContainsAny(entry, `*?[\`)
+}
+
+// LoadSuppressionList reads c.SuppressionList, if set, into c.Suppressed.
+func (c *MailConfig) LoadSuppressionList() error {
+	if c.SuppressionList == "" {
+		return nil
+	}
+	list, err := ReadSuppressionList(c.SuppressionList)
+	if err != nil {
+		return err
+	}
+	if c.Suppressed, err = NewSuppressions(list); err != nil {
+		return fmt.Errorf("suppression list %q: %w", c.SuppressionList, err)
+	}
+	return nil
+}
+
+// Suppression returns the suppression list entry address matches, if any.
+func (c *MailConfig) Suppression(address string) (Suppression, bool) {
+	if c.Suppressed == nil {
+		return Suppression{}, false
+	}
+	return c.Suppressed.Match(address)
+}
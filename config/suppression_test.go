@@ -0,0 +1,213 @@
+// gmt sends emails in bulk based on a template and a config file.
+// Copyright (C) 2019-2025  "Muharem Hrnjadovic" <muharem@linux.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSuppressionListRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "suppressed.csv")
+	date := time.Date(2025, 3, 1, 10, 30, 0, 0, time.UTC)
+	list := []Suppression{
+		{Address: "gone@example.com", Status: "5.1.1", Diagnostic: "smtp; 550 5.1.1 no such user, sorry", Date: date},
+		{Address: "manual@example.com"},
+	}
+	require.NoError(t, WriteSuppressionList(path, list))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "address,status,diagnostic,date\n"+
+		"gone@example.com,5.1.1,\"smtp; 550 5.1.1 no such user, sorry\",2025-03-01T10:30:00Z\n"+
+		"manual@example.com,,,\n", string(data))
+
+	got, err := ReadSuppressionList(path)
+	require.NoError(t, err)
+	assert.Equal(t, list, got)
+
+	require.NoError(t, WriteSuppressionList(path, list[1:]))
+	entries, err := os.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	require.Len(t, entries, 1, "no temporary file is left behind")
+	got, err = ReadSuppressionList(path)
+	require.NoError(t, err)
+	assert.Equal(t, list[1:], got)
+
+	assert.ErrorContains(t, WriteSuppressionList(filepath.Join(filepath.Dir(path), "missing", "sub.csv"), list), "failed to write suppression list")
+
+	fi, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), fi.Mode().Perm(), "a new list is not world-readable")
+}
+
+func TestWriteSuppressionListKeepsEdits(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "suppressed.csv")
+	require.NoError(t, os.WriteFile(path, []byte("# opt-outs, see ticket 42\n"+
+		"address,status,diagnostic,date\n"+
+		"\n"+
+		"# asked by phone\n"+
+		"Jane@example.org,,unsubscribed,\n"+
+		"gone@example.com,5.1.1,old bounce,2025-01-01T00:00:00Z\n"), 0o640))
+
+	list, err := ReadSuppressionList(path)
+	require.NoError(t, err)
+	date := time.Date(2025, 3, 1, 10, 30, 0, 0, time.UTC)
+	merged := MergeSuppressions(list, []Suppression{
+		{Address: "gone@example.com", Status: "5.2.2", Diagnostic: "mailbox\r\nfull", Date: date},
+		{Address: "new@example.net", Status: "5.1.1", Diagnostic: "no such user", Date: date},
+	})
+	require.NoError(t, WriteSuppressionList(path, merged))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "# opt-outs, see ticket 42\n"+
+		"address,status,diagnostic,date\n"+
+		"\n"+
+		"# asked by phone\n"+
+		"Jane@example.org,,unsubscribed,\n"+
+		"gone@example.com,5.2.2,mailbox full,2025-03-01T10:30:00Z\n"+
+		"new@example.net,5.1.1,no such user,2025-03-01T10:30:00Z\n", string(data))
+
+	fi, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o640), fi.Mode().Perm(), "the file keeps its mode")
+}
+
+func TestReadSuppressionListByHand(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "suppressed.csv")
+	require.NoError(t, os.WriteFile(path, []byte("# unsubscribed\nann@example.com\n bob@example.com ,5.2.2\n"), 0o644))
+	got, err := ReadSuppressionList(path)
+	require.NoError(t, err)
+	assert.Equal(t, []Suppression{{Address: "ann@example.com"}, {Address: "bob@example.com", Status: "5.2.2"}}, got)
+
+	require.NoError(t, os.WriteFile(path, []byte("address,status,diagnostic,date\nann@example.com,,,yesterday\n"), 0o644))
+	_, err = ReadSuppressionList(path)
+	assert.ErrorContains(t, err, `row 2: invalid date "yesterday"`)
+
+	_, err = ReadSuppressionList(filepath.Join(t.TempDir(), "missing.csv"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestMergeSuppressions(t *testing.T) {
+	older := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	newer := older.AddDate(0, 1, 0)
+	list := []Suppression{
+		{Address: "b@example.com", Status: "5.1.1", Date: older},
+		{Address: "c@example.com", Status: "5.2.2", Date: newer},
+	}
+	merged := MergeSuppressions(list, []Suppression{
+		{Address: "B@example.com", Status: "5.7.1", Date: newer},
+		{Address: "c@example.com", Status: "5.1.1", Date: older},
+		{Address: "a@example.com", Status: "5.1.2", Date: older},
+	})
+	assert.Equal(t, []Suppression{
+		{Address: "a@example.com", Status: "5.1.2", Date: older},
+		{Address: "B@example.com", Status: "5.7.1", Date: newer},
+		{Address: "c@example.com", Status: "5.2.2", Date: newer},
+	}, merged)
+}
+
+func TestParseSuppression(t *testing.T) {
+	base := `
+[general]
+from = "test <t@example.com>"
+subject = "test"
+[[recipients]]
+email = "a@b.com"
+first = "A"
+`
+	cfg := parseTestConfig(t, []byte(base))
+	assert.Equal(t, SuppressionSkip, cfg.SuppressionMode)
+	require.NoError(t, cfg.LoadSuppressionList(), "no list configured")
+
+	path := filepath.Join(t.TempDir(), "suppressed.csv")
+	require.NoError(t, WriteSuppressionList(path, []Suppression{{Address: "A@B.com", Status: "5.1.1"}}))
+	cfg = parseTestConfig(t, []byte(`
+[general]
+from = "test <t@example.com>"
+subject = "test"
+suppression_list = "`+path+`"
+suppression_mode = "warn"
+[[recipients]]
+email = "a@b.com"
+first = "A"
+`))
+	assert.Equal(t, SuppressionWarn, cfg.SuppressionMode)
+	require.NoError(t, cfg.LoadSuppressionList())
+	s, ok := cfg.Suppression("a@b.com")
+	assert.True(t, ok)
+	assert.Equal(t, "5.1.1", s.Status)
+	_, ok = cfg.Suppression("x@b.com")
+	assert.False(t, ok)
+
+	_, err := Parse([]byte(`
+[general]
+from = "test <t@example.com>"
+subject = "test"
+suppression_mode = "ignore"
+[[recipients]]
+email = "a@b.com"
+first = "A"
+`))
+	assert.ErrorContains(t, err, `suppression_mode in [general] must be "skip" or "warn", got "ignore"`)
+}
+
+func TestSuppressionsMatch(t *testing.T) {
+	s, err := NewSuppressions([]Suppression{
+		{Address: "Jane@Example.com", Status: "5.1.1", Diagnostic: "smtp; 550 user unknown"},
+		{Address: "competitor.example"},
+		{Address: "@old.example"},
+		{Address: "*.corp.example", Diagnostic: "opted out"},
+		{Address: "info@*"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 5, s.Len())
+
+	for address, want := range map[string]string{
+		"jane@example.com":            "Jane@Example.com",
+		"Jane Doe <JANE@example.com>": "Jane@Example.com",
+		"sales@competitor.example":    "competitor.example",
+		"bob@old.example":             "@old.example",
+		"bob@eu.corp.example":         "*.corp.example",
+		"info@example.org":            "info@*",
+	} {
+		e, ok := s.Match(address)
+		if assert.True(t, ok, address) {
+			assert.Equal(t, want, e.Address, address)
+		}
+	}
+	for _, address := range []string{"joe@example.com", "bob@corp.example", "bob@sub.competitor.example", "information@example.org"} {
+		_, ok := s.Match(address)
+		assert.False(t, ok, address)
+	}
+
+	_, err = NewSuppressions([]Suppression{{Address: "[a-@example.com"}})
+	assert.ErrorContains(t, err, `invalid pattern "[a-@example.com"`)
+}
+
+func TestSuppressionReason(t *testing.T) {
+	assert.Equal(t, "bounced 5.1.1: smtp; 550 user unknown", Suppression{Address: "a@b.com", Status: "5.1.1", Diagnostic: "smtp; 550 user unknown"}.Reason())
+	assert.Equal(t, `matches "*@b.com": opted out`, Suppression{Address: "*@b.com", Diagnostic: "opted out"}.Reason())
+	assert.Equal(t, `matches "b.com"`, Suppression{Address: "b.com"}.Reason())
+	assert.Equal(t, "on the suppression list", Suppression{Address: "a@b.com"}.Reason())
+}
@@ -0,0 +1,133 @@
+// gmt sends emails in bulk based on a template and a config file.
+// Copyright (C) 2019-2025  "Muharem Hrnjadovic" <muharem@linux.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package email
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	mail "github.com/wneessen/go-mail"
+)
+
+// Archive formats.
+const (
+	ArchiveMbox = "mbox"
+	ArchiveEML  = "eml"
+)
+
+// reArchiveUnsafe matches the characters replaced in .eml file names.
+var reArchiveUnsafe = regexp.MustCompile(`[^A-Za-z0-9@._+-]`)
+
+// Archive keeps a local copy of every sent message, exactly as rendered for
+// the transport: appended to an mbox file, or as one .eml file per message
+// in a directory. An Archive is safe for concurrent use.
+type Archive struct {
+	mu  sync.Mutex
+	f   *os.File // mbox; nil for a directory of .eml files
+	dir string
+}
+
+// ArchiveFormatFor returns the archive format implied by path: mbox for a
+// ".mbox" file, a directory of .eml files otherwise.
+func ArchiveFormatFor(path string) string {
+	if strings.EqualFold(filepath.Ext(path), ".mbox") {
+		return ArchiveMbox
+	}
+	return ArchiveEML
+}
+
+// OpenArchive opens the archive at path: an mbox file is created or appended
+// to, an .eml directory is created if needed. The caller must call Close.
+func OpenArchive(path, format string) (*Archive, error) {
+	switch format {
+	case ArchiveMbox:
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open archive %q: %w", path, err)
+		}
+		return &Archive{f: f}, nil
+	case ArchiveEML:
+		if err := os.MkdirAll(path, 0o700); err != nil {
+			return nil, fmt.Errorf("failed to create archive directory %q: %w", path, err)
+		}
+		return &Archive{dir: path}, nil
+	default:
+		return nil, fmt.Errorf("unknown archive format %q (want %q or %q)", format, ArchiveMbox, ArchiveEML)
+	}
+}
+
+// Add stores msg, sent to recipient. In a directory, the file is named
+// "<recipient>_<Message-ID>.eml".
+func (a *Archive) Add(msg *mail.Msg, recipient string) error {
+	data, err := renderCopy(msg)
+	if err != nil {
+		return err
+	}
+	if a.f == nil {
+		return a.writeEML(archiveName(recipient, msg.GetMessageID()), data)
+	}
+	from, err := envelopeFrom(msg)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, err := a.f.Write(mboxEntry(from, time.Now(), data)); err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+	return nil
+}
+
+// Close closes the mbox file, if any.
+func (a *Archive) Close() error {
+	if a.f == nil {
+		return nil
+	}
+	return a.f.Close()
+}
+
+// --- internal ---
+
+// writeEML writes data to a new file name in the archive directory. An
+// existing file is never overwritten.
+func (a *Archive) writeEML(name string, data []byte) error {
+	path := filepath.Join(a.dir, name)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+	return nil
+}
+
+// archiveName returns the .eml file name for a message to recipient, with
+// characters that are unsafe in file names replaced.
+func archiveName(recipient, messageID string) string {
+	messageID = strings.TrimSuffix(strings.TrimPrefix(messageID, "<"), ">")
+	return reArchiveUnsafe.ReplaceAllString(recipient, "_") + "_" + reArchiveUnsafe.ReplaceAllString(messageID, "_") + ".eml"
+}
@@ -0,0 +1,158 @@
+// gmt sends emails in bulk based on a template and a config file.
+// Copyright (C) 2019-2025  "Muharem Hrnjadovic" <muharem@linux.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package email
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/al-maisan/gmt/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	mail "github.com/wneessen/go-mail"
+)
+
+func TestArchiveFormatFor(t *testing.T) {
+	assert.Equal(t, ArchiveMbox, ArchiveFormatFor("sent.mbox"))
+	assert.Equal(t, ArchiveMbox, ArchiveFormatFor("SENT.MBOX"))
+	assert.Equal(t, ArchiveEML, ArchiveFormatFor("archive/2025-03"))
+}
+
+func TestOpenArchiveUnknownFormat(t *testing.T) {
+	_, err := OpenArchive(t.TempDir(), "maildir")
+	assert.ErrorContains(t, err, `unknown archive format "maildir"`)
+}
+
+func TestArchiveName(t *testing.T) {
+	assert.Equal(t, "jane@example.com_1234.5678@host.example.eml", archiveName("jane@example.com", "<1234.5678@host.example>"))
+	assert.Equal(t, "o_brien@example.com_a_b@h.eml", archiveName("o'brien@example.com", "a/b@h"))
+}
+
+func TestSendAllArchivesEML(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "archive")
+	archive, err := OpenArchive(dir, ArchiveEML)
+	require.NoError(t, err)
+	attachment := filepath.Join(t.TempDir(), "notes.txt")
+	require.NoError(t, os.WriteFile(attachment, []byte("attached\n"), 0o600))
+
+	sender := &renderingSender{}
+	var out bytes.Buffer
+	result := NewBatchSender(&out, sender, config.MailConfig{From: "sender@example.com"}, SendOptions{Archive: archive}).SendAll([]Message{
+		{Address: "jane@example.com", Subject: "Hi", Body: "Hello", Cc: []string{"cc@example.com"}, Attachments: []string{attachment}},
+	})
+	require.Equal(t, 1, result.Sent)
+	require.NoError(t, archive.Close())
+
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	assert.Regexp(t, `^jane@example\.com_.+\.eml$`, files[0].Name())
+	data, err := os.ReadFile(filepath.Join(dir, files[0].Name()))
+	require.NoError(t, err)
+	assert.Equal(t, sender.sent[0], data, "archived exactly as sent")
+	assert.Contains(t, string(data), "Cc: <cc@example.com>")
+	assert.Contains(t, string(data), `filename="notes.txt"`)
+}
+
+func TestSendAllArchivesMbox(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sent.mbox")
+	archive, err := OpenArchive(path, ArchiveMbox)
+	require.NoError(t, err)
+
+	var out bytes.Buffer
+	result := NewBatchSender(&out, &renderingSender{}, config.MailConfig{From: "sender@example.com"}, SendOptions{Archive: archive}).SendAll([]Message{
+		{Address: "a@example.com", Subject: "Hi", Body: "Hello"},
+		{Address: "b@example.com", Subject: "Hi", Body: "Hello"},
+	})
+	require.Equal(t, 2, result.Sent)
+	require.NoError(t, archive.Close())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Len(t, regexp.MustCompile(`(?m)^From sender@example\.com `).FindAll(data, -1), 2)
+	assert.Contains(t, string(data), "To: <b@example.com>\n")
+}
+
+// failingSender rejects every message.
+type failingSender struct{}
+
+func (failingSender) Send(*mail.Msg) error { return errors.New("boom") }
+func (failingSender) Reconnect() error     { return nil }
+func (failingSender) Close() error         { return nil }
+
+func TestSendAllArchivesOnlyDelivered(t *testing.T) {
+	dir := t.TempDir()
+	archive, err := OpenArchive(dir, ArchiveEML)
+	require.NoError(t, err)
+	var out bytes.Buffer
+	result := NewBatchSender(&out, failingSender{}, config.MailConfig{From: "sender@example.com"}, SendOptions{Archive: archive}).SendAll([]Message{
+		{Address: "a@example.com", Subject: "Hi", Body: "Hello"},
+	})
+	require.Equal(t, 1, result.Failed)
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, files)
+}
+
+func TestSendAllArchiveFailureKeepsDelivery(t *testing.T) {
+	dir := t.TempDir()
+	archive, err := OpenArchive(dir, ArchiveEML)
+	require.NoError(t, err)
+	require.NoError(t, os.Chmod(dir, 0o500))
+	t.Cleanup(func() { os.Chmod(dir, 0o700) }) //nolint:errcheck
+	if f, err := os.Create(filepath.Join(dir, "probe")); err == nil {
+		f.Close()
+		t.Skip("directory permissions are not enforced (running as root?)")
+	}
+
+	var out bytes.Buffer
+	result := NewBatchSender(&out, &renderingSender{}, config.MailConfig{From: "sender@example.com"}, SendOptions{Archive: archive}).SendAll([]Message{
+		{Address: "a@example.com", Subject: "Hi", Body: "Hello"},
+	})
+	assert.Equal(t, 1, result.Sent)
+	assert.Equal(t, 1, result.NotArchived)
+	assert.Contains(t, out.String(), "warning: failed to write archive")
+}
+
+func TestSendAllArchivesBcc(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "archive")
+	archive, err := OpenArchive(dir, ArchiveEML)
+	require.NoError(t, err)
+
+	sender := &envelopeSender{}
+	var out bytes.Buffer
+	result := NewBatchSender(&out, sender, config.MailConfig{From: "sender@example.com"}, SendOptions{Archive: archive}).SendAll([]Message{
+		{Address: "jane@example.com", Subject: "Hi", Body: "Hello", Bcc: []string{"audit@example.com"}},
+	})
+	require.Equal(t, 1, result.Sent)
+	require.NoError(t, archive.Close())
+
+	assert.Equal(t, [][]string{{"jane@example.com", "audit@example.com"}}, sender.rcpts)
+	assert.NotContains(t, sender.sent[0], "audit@example.com", "Bcc must not be in the sent headers")
+	assert.Contains(t, out.String(), "  Bcc: audit@example.com\n")
+
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	data, err := os.ReadFile(filepath.Join(dir, files[0].Name()))
+	require.NoError(t, err)
+	assert.Equal(t, "Bcc: <audit@example.com>\r\n"+sender.sent[0], string(data), "archived copy names the blind copies")
+}
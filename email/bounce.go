@@ -0,0 +1,220 @@
+// gmt sends emails in bulk based on a template and a config file.
+// Copyright (C) 2019-2025  "Muharem Hrnjadovic" <muharem@linux.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package email
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	netmail "net/mail"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/al-maisan/gmt/config"
+)
+
+// reMboxQuoted matches the lines mboxrd quoting has escaped with ">".
+var reMboxQuoted = regexp.MustCompile(`(?m)^>(>*From )`)
+
+// BounceScan is the result of reading the bounces in a mailbox.
+type BounceScan struct {
+	Messages int                  // messages read
+	Reports  int                  // of which delivery status notifications
+	Failed   []config.Suppression // recipients the reports mark as failed
+}
+
+// ReadBounces reads the mbox file or Maildir at path and collects the failed
+// recipients of every RFC 3464 delivery status notification in it, with
+// their status code and the remote server's diagnostic. Other messages are
+// ignored, as are recipients a report marks as merely delayed.
+func ReadBounces(path string) (BounceScan, error) {
+	var scan BounceScan
+	msgs, err := readMailbox(path)
+	if err != nil {
+		return scan, err
+	}
+	for _, data := range msgs {
+		scan.Messages++
+		failed, ok := parseDSN(data)
+		if ok {
+			scan.Reports++
+			scan.Failed = append(scan.Failed, failed...)
+		}
+	}
+	return scan, nil
+}
+
+// --- internal ---
+
+// readMailbox returns the messages in the mbox file or Maildir at path.
+func readMailbox(path string) ([][]byte, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read mailbox: %w", err)
+	}
+	if !fi.IsDir() {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read mailbox: %w", err)
+		}
+		return splitMbox(data), nil
+	}
+
+	var msgs [][]byte
+	found := false
+	for _, sub := range []string{"new", "cur"} {
+		entries, err := os.ReadDir(filepath.Join(path, sub))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read Maildir %q: %w", path, err)
+		}
+		found = true
+		for _, e := range entries {
+			if e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+				continue
+			}
+			data, err := os.ReadFile(filepath.Join(path, sub, e.Name()))
+			if err != nil {
+				return nil, fmt.Errorf("failed to read Maildir %q: %w", path, err)
+			}
+			msgs = append(msgs, data)
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("%q is not a Maildir: it has no new or cur directory", path)
+	}
+	return msgs, nil
+}
+
+// splitMbox splits an mbox file into its messages, dropping the "From "
+// separator lines and undoing mboxrd quoting.
+func splitMbox(data []byte) [][]byte {
+	var msgs [][]byte
+	var cur []byte
+	inMsg := false
+	prevBlank := true
+	for len(data) > 0 {
+		line := data
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			line = data[:i+1]
+		}
+		data = data[len(line):]
+		if prevBlank && bytes.HasPrefix(line, []byte("From ")) {
+			if inMsg {
+				msgs = append(msgs, reMboxQuoted.ReplaceAll(cur, []byte("$1")))
+			}
+			cur, inMsg = nil, true
+		} else if inMsg {
+			cur = append(cur, line...)
+		}
+		prevBlank = len(bytes.TrimRight(line, "\r\n")) == 0
+	}
+	if inMsg {
+		msgs = append(msgs, reMboxQuoted.ReplaceAll(cur, []byte("$1")))
+	}
+	return msgs
+}
+
+// parseDSN returns the failed recipients of data if it is a delivery status
+// notification (a multipart/report with a message/delivery-status part).
+func parseDSN(data []byte) ([]config.Suppression, bool) {
+	msg, err := netmail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		return nil, false
+	}
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/report" || params["boundary"] == "" {
+		return nil, false
+	}
+	date, _ := msg.Header.Date()
+
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if err != nil {
+			return nil, false
+		}
+		partType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		if partType != "message/delivery-status" && partType != "message/global-delivery-status" {
+			continue
+		}
+		var body io.Reader = part
+		if strings.EqualFold(part.Header.Get("Content-Transfer-Encoding"), "base64") {
+			body = base64.NewDecoder(base64.StdEncoding, part)
+		}
+		return parseDeliveryStatus(body, date), true
+	}
+}
+
+// parseDeliveryStatus parses the fields of a delivery-status part: a block of
+// per-message fields, then one block per recipient (RFC 3464 section 2).
+func parseDeliveryStatus(r io.Reader, date time.Time) []config.Suppression {
+	tp := textproto.NewReader(bufio.NewReader(r))
+	perMessage, err := tp.ReadMIMEHeader()
+	if err != nil && len(perMessage) == 0 {
+		return nil
+	}
+	if arrival, err := netmail.ParseDate(perMessage.Get("Arrival-Date")); err == nil {
+		date = arrival
+	}
+
+	var failed []config.Suppression
+	for err == nil {
+		var fields textproto.MIMEHeader
+		fields, err = tp.ReadMIMEHeader()
+		if !strings.EqualFold(strings.TrimSpace(fields.Get("Action")), "failed") {
+			continue
+		}
+		address := dsnAddress(fields.Get("Original-Recipient"))
+		if address == "" {
+			address = dsnAddress(fields.Get("Final-Recipient"))
+		}
+		if address == "" {
+			continue
+		}
+		failed = append(failed, config.Suppression{
+			Address:    address,
+			Status:     strings.TrimSpace(fields.Get("Status")),
+			Diagnostic: strings.Join(strings.Fields(fields.Get("Diagnostic-Code")), " "),
+			Date:       date.UTC(),
+		})
+	}
+	return failed
+}
+
+// dsnAddress returns the address of a recipient field such as
+// "rfc822; jane@example.com", or "" for an address type other than rfc822 or
+// (in a message/global-delivery-status) utf-8.
+func dsnAddress(field string) string {
+	kind, address, ok := strings.Cut(field, ";")
+	kind = strings.TrimSpace(kind)
+	if !ok || !strings.EqualFold(kind, "rfc822") && !strings.EqualFold(kind, "utf-8") {
+		return ""
+	}
+	return strings.Trim(strings.TrimSpace(address), "<>")
+}
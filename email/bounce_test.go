@@ -0,0 +1,159 @@
+// gmt sends emails in bulk based on a template and a config file.
+// Copyright (C) 2019-2025  "Muharem Hrnjadovic" <muharem@linux.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package email
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/al-maisan/gmt/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testDSN is a bounce report as sent by Postfix: jane's mailbox does not
+// exist, joe's delivery is only delayed.
+const testDSN = `From: MAILER-DAEMON@mx.example.com (Mail Delivery System)
+To: sender@example.com
+Date: Sat, 01 Mar 2025 10:30:00 +0000
+Subject: Undelivered Mail Returned to Sender
+MIME-Version: 1.0
+Content-Type: multipart/report; report-type=delivery-status;
+	boundary="B1"
+
+--B1
+Content-Type: text/plain
+
+This is the mail system at host mx.example.com.
+From the mail system: your message could not be delivered.
+
+--B1
+Content-Type: message/delivery-status
+
+Reporting-MTA: dns; mx.example.com
+X-Postfix-Queue-ID: 4XYZ
+
+Final-Recipient: rfc822; jane@example.org
+Original-Recipient: rfc822;Jane@Example.org
+Action: failed
+Status: 5.1.1
+Remote-MTA: dns; mx.example.org
+Diagnostic-Code: smtp; 550 5.1.1 <jane@example.org>:
+    Recipient address rejected: User unknown
+
+Final-Recipient: rfc822; joe@example.org
+Action: delayed
+Status: 4.4.1
+
+--B1
+Content-Type: message/rfc822
+
+From: sender@example.com
+Subject: Hi
+
+>From the start
+--B1--
+`
+
+// testDSNBase64 reports a failure with a base64-encoded status part and an
+// Arrival-Date.
+var testDSNBase64 = `From: postmaster@example.net
+Date: Sun, 02 Mar 2025 08:00:00 +0000
+Subject: Delivery Status Notification (Failure)
+MIME-Version: 1.0
+Content-Type: multipart/report; report-type="delivery-status"; boundary=B2
+
+--B2
+Content-Type: message/delivery-status
+Content-Transfer-Encoding: base64
+
+` + base64.StdEncoding.EncodeToString([]byte("Reporting-MTA: dns; mx.example.net\r\nArrival-Date: Sun, 02 Mar 2025 07:55:00 +0000\r\n\r\nFinal-Recipient: rfc822; <full@example.net>\r\nAction: failed\r\nStatus: 5.2.2\r\nDiagnostic-Code: smtp; 552 5.2.2 mailbox full\r\n")) + `
+--B2--
+`
+
+const testPlainMessage = `From: friend@example.com
+Subject: Re: Hi
+
+Thanks!
+`
+
+// mboxOf joins messages into an mbox file, mboxrd-quoted.
+func mboxOf(msgs ...string) string {
+	var b strings.Builder
+	for _, m := range msgs {
+		b.Write(mboxEntry("MAILER-DAEMON", time.Date(2025, 3, 1, 10, 30, 0, 0, time.UTC), []byte(m)))
+	}
+	return b.String()
+}
+
+func TestReadBouncesMbox(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bounces.mbox")
+	require.NoError(t, os.WriteFile(path, []byte(mboxOf(testDSN, testPlainMessage, testDSNBase64)), 0o600))
+
+	scan, err := ReadBounces(path)
+	require.NoError(t, err)
+	assert.Equal(t, 3, scan.Messages)
+	assert.Equal(t, 2, scan.Reports)
+	assert.Equal(t, []config.Suppression{
+		{
+			Address:    "Jane@Example.org",
+			Status:     "5.1.1",
+			Diagnostic: "smtp; 550 5.1.1 <jane@example.org>: Recipient address rejected: User unknown",
+			Date:       time.Date(2025, 3, 1, 10, 30, 0, 0, time.UTC),
+		},
+		{
+			Address:    "full@example.net",
+			Status:     "5.2.2",
+			Diagnostic: "smtp; 552 5.2.2 mailbox full",
+			Date:       time.Date(2025, 3, 2, 7, 55, 0, 0, time.UTC),
+		},
+	}, scan.Failed)
+}
+
+func TestSplitMbox(t *testing.T) {
+	msgs := splitMbox([]byte(mboxOf(testDSN, testPlainMessage)))
+	require.Len(t, msgs, 2)
+	assert.Contains(t, string(msgs[0]), "\n>From the start\n", "mboxrd quoting undone once")
+	assert.Equal(t, testPlainMessage+"\n", string(msgs[1]))
+	assert.Empty(t, splitMbox(nil))
+}
+
+func TestReadBouncesMaildir(t *testing.T) {
+	dir := t.TempDir()
+	for _, sub := range []string{"new", "cur", "tmp"} {
+		require.NoError(t, os.Mkdir(filepath.Join(dir, sub), 0o700))
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "new", "1.host"), []byte(testDSN), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "cur", "2.host:2,S"), []byte(testPlainMessage), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "tmp", "3.host"), []byte(testDSNBase64), 0o600))
+
+	scan, err := ReadBounces(dir)
+	require.NoError(t, err)
+	assert.Equal(t, 2, scan.Messages, "tmp/ is not read")
+	assert.Equal(t, 1, scan.Reports)
+	require.Len(t, scan.Failed, 1)
+	assert.Equal(t, "Jane@Example.org", scan.Failed[0].Address)
+
+	_, err = ReadBounces(t.TempDir())
+	assert.ErrorContains(t, err, "is not a Maildir")
+	_, err = ReadBounces(filepath.Join(dir, "missing.mbox"))
+	assert.ErrorContains(t, err, "failed to read mailbox")
+}
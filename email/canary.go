@@ -0,0 +1,96 @@
+// gmt sends emails in bulk based on a template and a config file.
+// Copyright (C) 2019-2025  "Muharem Hrnjadovic" <muharem@linux.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package email
+
+import (
+	"fmt"
+	netmail "net/mail"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Canary configures a staged rollout: the canary messages go out first, then
+// the batch stops at a checkpoint and Confirm decides whether the rest follow.
+type Canary struct {
+	Count int      // the first Count messages are the canary, or
+	Seeds []string // the messages to these addresses
+	// Confirm is called once the canary messages are done, with the results
+	// so far and the number of messages remaining. Unless it returns true,
+	// the rest are held back (SendResult.Held).
+	Confirm func(sent SendResult, remaining int) bool
+}
+
+// ParseCanary parses a canary spec: a number of recipients, e.g. "10", or a
+// comma-separated seed list of recipient addresses.
+func ParseCanary(s string) (Canary, error) {
+	if n, err := strconv.Atoi(strings.TrimSpace(s)); err == nil {
+		if n < 1 {
+			return Canary{}, fmt.Errorf("count must be >= 1, got %d", n)
+		}
+		return Canary{Count: n}, nil
+	}
+	var c Canary
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		addr, err := netmail.ParseAddress(field)
+		if err != nil {
+			return Canary{}, fmt.Errorf("%q is neither a count nor an address: %w", field, err)
+		}
+		c.Seeds = append(c.Seeds, addr.Address)
+	}
+	if len(c.Seeds) == 0 {
+		return Canary{}, fmt.Errorf("no count or addresses in %q", s)
+	}
+	return c, nil
+}
+
+// Check verifies that every seed address is one of the recipients in msgs.
+func (c Canary) Check(msgs []Message) error {
+	var missing []string
+	for _, seed := range c.Seeds {
+		if !slices.ContainsFunc(msgs, func(m Message) bool { return strings.EqualFold(m.Address, seed) }) {
+			missing = append(missing, seed)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("canary address(es) not among the recipients: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// --- internal ---
+
+// split orders msgs with the canary messages first and returns how many
+// there are.
+func (c Canary) split(msgs []Message) ([]Message, int) {
+	if len(c.Seeds) == 0 {
+		return msgs, min(c.Count, len(msgs))
+	}
+	var canary, rest []Message
+	for _, m := range msgs {
+		if containsFold(c.Seeds, m.Address) {
+			canary = append(canary, m)
+		} else {
+			rest = append(rest, m)
+		}
+	}
+	return append(canary, rest...), len(canary)
+}
@@ -0,0 +1,129 @@
+// gmt sends emails in bulk based on a template and a config file.
+// Copyright (C) 2019-2025  "Muharem Hrnjadovic" <muharem@linux.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package email
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/al-maisan/gmt/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func canaryMessages(n int) []Message {
+	msgs := make([]Message, n)
+	for i := range msgs {
+		msgs[i] = Message{Address: fmt.Sprintf("user%d@example.com", i+1), Subject: "Hi", Body: "Hello"}
+	}
+	return msgs
+}
+
+func TestParseCanary(t *testing.T) {
+	c, err := ParseCanary("10")
+	require.NoError(t, err)
+	assert.Equal(t, Canary{Count: 10}, c)
+
+	c, err = ParseCanary("me@example.com, Boss <boss@example.com>")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"me@example.com", "boss@example.com"}, c.Seeds)
+
+	_, err = ParseCanary("0")
+	assert.ErrorContains(t, err, "count must be >= 1")
+	_, err = ParseCanary("ten")
+	assert.ErrorContains(t, err, `"ten" is neither a count nor an address`)
+	_, err = ParseCanary(",")
+	assert.ErrorContains(t, err, "no count or addresses")
+}
+
+func TestCanaryCheck(t *testing.T) {
+	msgs := canaryMessages(3)
+	assert.NoError(t, Canary{Count: 5}.Check(msgs))
+	assert.NoError(t, Canary{Seeds: []string{"USER2@example.com"}}.Check(msgs))
+	assert.EqualError(t, Canary{Seeds: []string{"user2@example.com", "x@example.com"}}.Check(msgs),
+		"canary address(es) not among the recipients: x@example.com")
+}
+
+func TestSendAllCanaryHeld(t *testing.T) {
+	sender := &envelopeSender{}
+	var reportOut bytes.Buffer
+	report, err := NewReport(&reportOut, ReportCSV)
+	require.NoError(t, err)
+	var remaining int
+	var out bytes.Buffer
+	opts := SendOptions{Report: report, Canary: &Canary{Count: 2, Confirm: func(sent SendResult, n int) bool {
+		assert.Equal(t, 2, sent.Sent)
+		remaining = n
+		return false
+	}}}
+	result := NewBatchSender(&out, sender, config.MailConfig{From: "sender@example.com"}, opts).SendAll(canaryMessages(5))
+
+	assert.Equal(t, 2, result.Sent)
+	assert.Equal(t, 3, remaining)
+	require.Len(t, result.Held, 3)
+	assert.Equal(t, "user3@example.com", result.Held[0].Address)
+	assert.Equal(t, [][]string{{"user1@example.com"}, {"user2@example.com"}}, sender.rcpts)
+	assert.Contains(t, out.String(), "Canary: 2 sent, 0 failed; 3 recipient(s) remaining")
+	assert.Contains(t, out.String(), "Canary: holding back 3 recipient(s)")
+	assert.Equal(t, 3, strings.Count(reportOut.String(), ",pending,"))
+}
+
+func TestSendAllCanaryConfirmed(t *testing.T) {
+	senders := []Sender{&mockSender{}, &mockSender{}, &mockSender{}}
+	var out bytes.Buffer
+	confirmed := false
+	opts := SendOptions{Canary: &Canary{Count: 4, Confirm: func(sent SendResult, n int) bool {
+		// All canary messages are done before the checkpoint, even when
+		// sending over several connections.
+		assert.Equal(t, 4, sent.Sent)
+		assert.Equal(t, 6, n)
+		confirmed = true
+		return true
+	}}}
+	result := NewPooledBatchSender(&out, senders, config.MailConfig{From: "sender@example.com"}, opts).SendAll(canaryMessages(10))
+
+	assert.True(t, confirmed)
+	assert.Equal(t, 10, result.Sent)
+	assert.Empty(t, result.Held)
+	assert.Contains(t, out.String(), "[10/10]")
+}
+
+func TestSendAllCanarySeedsFirst(t *testing.T) {
+	sender := &envelopeSender{}
+	var out bytes.Buffer
+	opts := SendOptions{Canary: &Canary{Seeds: []string{"user4@example.com", "user2@example.com"}}}
+	result := NewBatchSender(&out, sender, config.MailConfig{From: "sender@example.com"}, opts).SendAll(canaryMessages(4))
+
+	assert.Equal(t, 2, result.Sent)
+	assert.Equal(t, [][]string{{"user2@example.com"}, {"user4@example.com"}}, sender.rcpts)
+	require.Len(t, result.Held, 2)
+	assert.Equal(t, "user1@example.com", result.Held[0].Address)
+	assert.Contains(t, out.String(), "[1/4]")
+}
+
+func TestSendAllCanaryNoCheckpointWhenAllInCanary(t *testing.T) {
+	var out bytes.Buffer
+	opts := SendOptions{Canary: &Canary{Count: 5, Confirm: func(SendResult, int) bool {
+		t.Error("no checkpoint expected")
+		return false
+	}}}
+	result := NewBatchSender(&out, &mockSender{}, config.MailConfig{From: "sender@example.com"}, opts).SendAll(canaryMessages(3))
+	assert.Equal(t, 3, result.Sent)
+	assert.Empty(t, result.Held)
+}
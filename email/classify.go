@@ -0,0 +1,119 @@
+// gmt sends emails in bulk based on a template and a config file.
+// Copyright (C) 2019-2025  "Muharem Hrnjadovic" <muharem@linux.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package email
+
+import (
+	"errors"
+	"math"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrorClass says whether a failed send may succeed if tried again.
+type ErrorClass string
+
+const (
+	ClassTemporary ErrorClass = "temporary"
+	ClassPermanent ErrorClass = "permanent"
+)
+
+// smtpStatus is implemented by errors carrying the server's reply, such as
+// *mail.SendError.
+type smtpStatus interface {
+	ErrorCode() int             // basic reply code, e.g. 550; 0 if none
+	EnhancedStatusCode() string // RFC 3463 code, e.g. "5.1.1"; "" if none
+}
+
+// classifyError returns the class of a send error, or "" for errors that are
+// neither a server reply nor a connection failure (e.g. a missing
+// attachment). The RFC 3463 enhanced status code wins over the basic reply
+// code, since servers pair e.g. "552" with "4.2.2" (mailbox full, try later);
+// in both, a leading 4 is temporary and a leading 5 permanent. Errors without
+// a code that lost (or may have lost) the connection are temporary.
+func classifyError(err error) ErrorClass {
+	if err == nil {
+		return ""
+	}
+	var st smtpStatus
+	if errors.As(err, &st) {
+		if class := classFromDigit(st.EnhancedStatusCode()); class != "" {
+			return class
+		}
+		if class := classFromDigit(strconv.Itoa(st.ErrorCode())); class != "" {
+			return class
+		}
+	}
+	if isConnectionError(err) {
+		return ClassTemporary
+	}
+	return ""
+}
+
+// classFromDigit maps the leading digit of a reply or enhanced status code
+// to a class.
+func classFromDigit(code string) ErrorClass {
+	switch {
+	case strings.HasPrefix(code, "4"):
+		return ClassTemporary
+	case strings.HasPrefix(code, "5"):
+		return ClassPermanent
+	}
+	return ""
+}
+
+// errorLabel describes err's class and codes for the progress output, e.g.
+// "permanent 550 5.1.1" or "unclassified".
+func errorLabel(err error) string {
+	label := string(classifyError(err))
+	if label == "" {
+		label = "unclassified"
+	}
+	var st smtpStatus
+	if errors.As(err, &st) {
+		if code := st.ErrorCode(); code != 0 {
+			label += " " + strconv.Itoa(code)
+		}
+		if esc := st.EnhancedStatusCode(); esc != "" {
+			label += " " + esc
+		}
+	}
+	return label
+}
+
+// backoff returns the wait before retry number retry (1-based): base doubled
+// for each earlier retry, capped at ceiling (if positive), with "equal jitter"
+// (a random value in the upper half of the delay) so parallel workers and
+// runs do not retry in lockstep.
+func backoff(base, ceiling time.Duration, retry int) time.Duration {
+	if base <= 0 {
+		return 0
+	}
+	d := base
+	for range retry - 1 {
+		if (ceiling > 0 && d >= ceiling) || d > math.MaxInt64/2 {
+			break
+		}
+		d *= 2
+	}
+	if ceiling > 0 {
+		d = min(d, ceiling)
+	}
+	half := d / 2
+	return half + rand.N(d-half+1)
+}
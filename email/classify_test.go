@@ -0,0 +1,114 @@
+// gmt sends emails in bulk based on a template and a config file.
+// Copyright (C) 2019-2025  "Muharem Hrnjadovic" <muharem@linux.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package email
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/al-maisan/gmt/config"
+	"github.com/stretchr/testify/assert"
+	mail "github.com/wneessen/go-mail"
+)
+
+// smtpReplyError is a server rejection with a reply code and an optional
+// enhanced status code; *mail.SendError's fields cannot be set from outside.
+type smtpReplyError struct {
+	code int
+	esc  string
+	text string
+}
+
+func (e *smtpReplyError) Error() string              { return fmt.Sprintf("%d %s %s", e.code, e.esc, e.text) }
+func (e *smtpReplyError) ErrorCode() int             { return e.code }
+func (e *smtpReplyError) EnhancedStatusCode() string { return e.esc }
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		name  string
+		err   error
+		class ErrorClass
+		label string
+	}{
+		{"5xx", &smtpReplyError{code: 550, esc: "5.1.1"}, ClassPermanent, "permanent 550 5.1.1"},
+		{"4xx", &smtpReplyError{code: 451, esc: "4.3.0"}, ClassTemporary, "temporary 451 4.3.0"},
+		{"enhanced code wins", &smtpReplyError{code: 552, esc: "4.2.2"}, ClassTemporary, "temporary 552 4.2.2"},
+		{"reply code only", &smtpReplyError{code: 554}, ClassPermanent, "permanent 554"},
+		{"wrapped", fmt.Errorf("send: %w", &smtpReplyError{code: 421}), ClassTemporary, "temporary 421"},
+		{"dropped connection", &mail.SendError{Reason: mail.ErrConnCheck}, ClassTemporary, "temporary"},
+		{"plain error", fmt.Errorf("attachment missing"), "", "unclassified"},
+		{"nil", nil, "", "unclassified"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.class, classifyError(tt.err))
+			assert.Equal(t, tt.label, errorLabel(tt.err))
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	assert.Zero(t, backoff(0, time.Minute, 3))
+	for range 50 {
+		d := backoff(time.Second, 0, 1)
+		assert.True(t, d >= 500*time.Millisecond && d <= time.Second, d)
+		d = backoff(time.Second, 0, 3)
+		assert.True(t, d >= 2*time.Second && d <= 4*time.Second, d)
+		d = backoff(time.Second, 5*time.Second, 10)
+		assert.True(t, d >= 2500*time.Millisecond && d <= 5*time.Second, d)
+	}
+	assert.Positive(t, backoff(time.Second, 0, 100), "must not overflow")
+}
+
+func TestSendAllPermanentErrorNotRetried(t *testing.T) {
+	sender := &mockSender{sendErr: &smtpReplyError{code: 550, esc: "5.1.1", text: "no such user"}}
+	cfg := config.MailConfig{From: "sender@example.com"}
+	msgs := []Message{{Name: "John", Address: "jd@example.com", Subject: "Hi", Body: "Hello"}}
+
+	var buf bytes.Buffer
+	result := NewBatchSender(&buf, sender, cfg, SendOptions{Retries: 3, RetryDelay: time.Hour}).SendAll(msgs)
+	assert.Equal(t, 1, result.Failed)
+	assert.NotContains(t, buf.String(), "retrying")
+	assert.Contains(t, buf.String(), "! John <jd@example.com> (failed to send [permanent 550 5.1.1], not retried: 550 5.1.1 no such user)")
+}
+
+// countingSender fails every send with err and counts the attempts.
+type countingSender struct {
+	err   error
+	sends int
+}
+
+func (c *countingSender) Send(_ *mail.Msg) error { c.sends++; return c.err }
+func (c *countingSender) Reconnect() error       { return nil }
+func (c *countingSender) Close() error           { return nil }
+
+func TestSendAllTemporaryErrorBacksOff(t *testing.T) {
+	sender := &countingSender{err: &smtpReplyError{code: 451, esc: "4.7.1", text: "try again later"}}
+	cfg := config.MailConfig{From: "sender@example.com"}
+	msgs := []Message{{Name: "John", Address: "jd@example.com", Subject: "Hi", Body: "Hello"}}
+
+	var buf bytes.Buffer
+	opts := SendOptions{Retries: 3, RetryDelay: 4 * time.Millisecond, RetryMaxDelay: 8 * time.Millisecond}
+	result := NewBatchSender(&buf, sender, cfg, opts).SendAll(msgs)
+	assert.Equal(t, 1, result.Failed)
+	assert.Equal(t, 4, sender.sends)
+	assert.Equal(t, 3, strings.Count(buf.String(), "after [temporary 451 4.7.1] error"))
+	assert.Contains(t, buf.String(), "(failed to send [temporary 451 4.7.1]: ")
+}
@@ -0,0 +1,225 @@
+// gmt sends emails in bulk based on a template and a config file.
+// Copyright (C) 2019-2025  "Muharem Hrnjadovic" <muharem@linux.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package email
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net/mail"
+	"os"
+	"strings"
+
+	"github.com/al-maisan/gmt/config"
+	"github.com/emersion/go-msgauth/dkim"
+	gomail "github.com/wneessen/go-mail"
+)
+
+// defaultDKIMHeaders are signed when [dkim] sets no headers: the fields
+// RFC 6376 section 5.4.1 recommends that gmt writes, and the List-Unsubscribe
+// fields, which RFC 8058 requires to be signed.
+var defaultDKIMHeaders = []string{
+	"From", "Reply-To", "Subject", "Date", "To", "Cc", "Message-ID",
+	"MIME-Version", "Content-Type", "Content-Transfer-Encoding",
+	"List-Unsubscribe", "List-Unsubscribe-Post",
+}
+
+// DKIMSigner adds a DKIM-Signature header to outgoing messages. It is safe
+// for concurrent use.
+type DKIMSigner struct {
+	opts dkim.SignOptions
+}
+
+// NewDKIMSigner loads the private key named in cfg and checks that the
+// signing domain matches the domain of from (or is a parent of it), as
+// DMARC alignment requires.
+func NewDKIMSigner(cfg config.DKIMConfig, from string) (*DKIMSigner, error) {
+	signer, err := loadDKIMKey(cfg.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	fromDomain, err := addressDomain(from)
+	if err != nil {
+		return nil, fmt.Errorf("dkim: %w", err)
+	}
+	domain := strings.ToLower(cfg.Domain)
+	if domain == "" {
+		domain = fromDomain
+	}
+	if err := checkAlignment(domain, fromDomain); err != nil {
+		return nil, err
+	}
+
+	headers := cfg.Headers
+	if len(headers) == 0 {
+		headers = defaultDKIMHeaders
+	}
+	if !containsFold(headers, "From") {
+		return nil, fmt.Errorf("dkim: headers must include From")
+	}
+	hc, bc, err := parseCanonicalization(cfg.Canonicalization)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DKIMSigner{opts: dkim.SignOptions{
+		Domain:                 domain,
+		Selector:               cfg.Selector,
+		Signer:                 signer,
+		Hash:                   crypto.SHA256,
+		HeaderCanonicalization: hc,
+		BodyCanonicalization:   bc,
+		HeaderKeys:             headers,
+	}}, nil
+}
+
+// CheckFrom returns an error if the signing domain does not match the domain
+// of from, e.g. a recipient's own from address.
+func (s *DKIMSigner) CheckFrom(from string) error {
+	fromDomain, err := addressDomain(from)
+	if err != nil {
+		return fmt.Errorf("dkim: %w", err)
+	}
+	return checkAlignment(s.opts.Domain, fromDomain)
+}
+
+// checkAlignment returns an error unless the From domain is the signing
+// domain or a subdomain of it (relaxed DMARC alignment).
+func checkAlignment(domain, fromDomain string) error {
+	if fromDomain != domain && !strings.HasSuffix(fromDomain, "."+domain) {
+		return fmt.Errorf("dkim: signing domain %q does not match the From domain %q", domain, fromDomain)
+	}
+	return nil
+}
+
+// Domain returns the signing domain (the d= tag).
+func (s *DKIMSigner) Domain() string { return s.opts.Domain }
+
+// Selector returns the key selector (the s= tag).
+func (s *DKIMSigner) Selector() string { return s.opts.Selector }
+
+// Sign computes the signature over msg as it will be written and adds it as
+// a DKIM-Signature header. It must be called once the message is complete,
+// attachments included. Rendering fixes the Date header and the MIME
+// boundaries, so the message is written the same way when it is sent.
+func (s *DKIMSigner) Sign(msg *gomail.Msg) error {
+	data, err := renderMessage(msg)
+	if err != nil {
+		return err
+	}
+	opts := s.opts
+	signer, err := dkim.NewSigner(&opts)
+	if err != nil {
+		return fmt.Errorf("dkim: %w", err)
+	}
+	if _, err := signer.Write(data); err != nil {
+		_ = signer.Close()
+		return fmt.Errorf("dkim: failed to sign: %w", err)
+	}
+	if err := signer.Close(); err != nil {
+		return fmt.Errorf("dkim: failed to sign: %w", err)
+	}
+	// Signature returns the whole folded header field, CRLF-terminated.
+	field := strings.TrimSuffix(signer.Signature(), "\r\n")
+	name, value, _ := strings.Cut(field, ": ")
+	msg.SetGenHeaderPreformatted(gomail.Header(name), value)
+	return nil
+}
+
+// --- internal ---
+
+// loadDKIMKey reads a PEM-encoded RSA (PKCS #1 or #8) or Ed25519 (PKCS #8)
+// private key.
+func loadDKIMKey(path string) (crypto.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("dkim: failed to read private key: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("dkim: no PEM block in private key file %q", path)
+	}
+	var key any
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("dkim: unsupported PEM block %q in %q (want RSA PRIVATE KEY or PRIVATE KEY)", block.Type, path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("dkim: invalid private key %q: %w", path, err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("dkim: unsupported key type %T in %q", key, path)
+	}
+	return signer, nil
+}
+
+// parseCanonicalization parses "header/body" canonicalization. A single
+// value applies to the header, with simple body canonicalization (RFC 6376
+// section 3.5); empty means relaxed/relaxed.
+func parseCanonicalization(s string) (dkim.Canonicalization, dkim.Canonicalization, error) {
+	if s == "" {
+		return dkim.CanonicalizationRelaxed, dkim.CanonicalizationRelaxed, nil
+	}
+	header, body, ok := strings.Cut(s, "/")
+	if !ok {
+		body = string(dkim.CanonicalizationSimple)
+	}
+	for _, c := range []string{header, body} {
+		if c != string(dkim.CanonicalizationSimple) && c != string(dkim.CanonicalizationRelaxed) {
+			return "", "", fmt.Errorf("dkim: invalid canonicalization %q", s)
+		}
+	}
+	return dkim.Canonicalization(header), dkim.Canonicalization(body), nil
+}
+
+// addressDomain returns the lower-cased domain of an RFC 5322 address such
+// as `"Name" <user@example.com>`.
+func addressDomain(address string) (string, error) {
+	addr, err := mail.ParseAddress(address)
+	if err != nil {
+		return "", fmt.Errorf("invalid address %q: %w", address, err)
+	}
+	_, domain, ok := strings.Cut(addr.Address, "@")
+	if !ok || domain == "" {
+		return "", fmt.Errorf("address %q has no domain", address)
+	}
+	return strings.ToLower(domain), nil
+}
+
+// plainAddress returns the bare address in an RFC 5322 address, or address
+// itself if it does not parse.
+func plainAddress(address string) string {
+	if addr, err := mail.ParseAddress(address); err == nil {
+		return addr.Address
+	}
+	return address
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
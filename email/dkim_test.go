@@ -0,0 +1,180 @@
+// gmt sends emails in bulk based on a template and a config file.
+// Copyright (C) 2019-2025  "Muharem Hrnjadovic" <muharem@linux.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package email
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/al-maisan/gmt/config"
+	"github.com/emersion/go-msgauth/dkim"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	mail "github.com/wneessen/go-mail"
+)
+
+// writeDKIMKey writes a fresh Ed25519 key as PKCS #8 PEM and returns its path
+// and the DNS TXT record publishing the public half.
+func writeDKIMKey(t *testing.T) (string, string) {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "dkim.pem")
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600))
+	return path, "v=DKIM1; k=ed25519; p=" + base64.StdEncoding.EncodeToString(pub)
+}
+
+// verifyDKIM checks the DKIM signature of msg as it would be sent, with txt
+// as the published key.
+func verifyDKIM(t *testing.T, msg *mail.Msg, txt string) *dkim.Verification {
+	t.Helper()
+	var buf bytes.Buffer
+	_, err := msg.WriteTo(&buf)
+	require.NoError(t, err)
+	verifications, err := dkim.VerifyWithOptions(&buf, &dkim.VerifyOptions{
+		LookupTXT: func(string) ([]string, error) { return []string{txt}, nil },
+	})
+	require.NoError(t, err)
+	require.Len(t, verifications, 1)
+	return verifications[0]
+}
+
+func TestDKIMSignVerifies(t *testing.T) {
+	keyPath, txt := writeDKIMKey(t)
+	signer, err := NewDKIMSigner(config.DKIMConfig{PrivateKey: keyPath, Selector: "gmt"}, `"Sender" <sender@example.com>`)
+	require.NoError(t, err)
+	assert.Equal(t, "example.com", signer.Domain())
+
+	attachment := filepath.Join(t.TempDir(), "notes.txt")
+	require.NoError(t, os.WriteFile(attachment, []byte("attached\n"), 0o600))
+	msg, err := createMessage("sender@example.com", "", Message{
+		Name: "Jane", Address: "jane@example.com", Subject: "Hi", Body: "Hello", HTMLBody: "<p>Hello</p>",
+		Cc: []string{"cc@example.com"},
+	})
+	require.NoError(t, err)
+	require.NoError(t, attachFiles(msg, []string{attachment}))
+	require.NoError(t, signer.Sign(msg))
+
+	v := verifyDKIM(t, msg, txt)
+	assert.NoError(t, v.Err)
+	assert.Equal(t, "example.com", v.Domain)
+	assert.Contains(t, v.HeaderKeys, "Subject")
+}
+
+func TestDKIMSignRSAAndCustomHeaders(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	keyPath := filepath.Join(t.TempDir(), "rsa.pem")
+	require.NoError(t, os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}), 0o600))
+	pub, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	require.NoError(t, err)
+	txt := "v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(pub)
+
+	cfg := config.DKIMConfig{PrivateKey: keyPath, Selector: "s1", Domain: "example.com", Headers: []string{"From", "Subject"}, Canonicalization: "simple/simple"}
+	signer, err := NewDKIMSigner(cfg, "news@mail.example.com")
+	require.NoError(t, err)
+	msg, err := createMessage("news@mail.example.com", "", Message{Address: "jane@example.com", Subject: "Hi", Body: "Hello"})
+	require.NoError(t, err)
+	require.NoError(t, signer.Sign(msg))
+
+	v := verifyDKIM(t, msg, txt)
+	assert.NoError(t, v.Err)
+	assert.Equal(t, []string{"From", "Subject"}, v.HeaderKeys)
+}
+
+func TestNewDKIMSignerErrors(t *testing.T) {
+	keyPath, _ := writeDKIMKey(t)
+	notPEM := filepath.Join(t.TempDir(), "key.txt")
+	require.NoError(t, os.WriteFile(notPEM, []byte("not a key"), 0o600))
+
+	tests := []struct {
+		name    string
+		cfg     config.DKIMConfig
+		from    string
+		wantErr string
+	}{
+		{"missing key", config.DKIMConfig{PrivateKey: "/nonexistent.pem", Selector: "s"}, "a@example.com", "failed to read private key"},
+		{"not PEM", config.DKIMConfig{PrivateKey: notPEM, Selector: "s"}, "a@example.com", "no PEM block"},
+		{"other domain", config.DKIMConfig{PrivateKey: keyPath, Selector: "s", Domain: "example.org"}, "a@example.com", `does not match the From domain "example.com"`},
+		{"child domain", config.DKIMConfig{PrivateKey: keyPath, Selector: "s", Domain: "mail.example.com"}, "a@example.com", "does not match"},
+		{"no From", config.DKIMConfig{PrivateKey: keyPath, Selector: "s", Headers: []string{"Subject"}}, "a@example.com", "must include From"},
+		{"bad canonicalization", config.DKIMConfig{PrivateKey: keyPath, Selector: "s", Canonicalization: "strict"}, "a@example.com", "invalid canonicalization"},
+		{"bad From", config.DKIMConfig{PrivateKey: keyPath, Selector: "s"}, "not-an-address", "invalid address"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewDKIMSigner(tt.cfg, tt.from)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+		})
+	}
+}
+
+func TestParseCanonicalization(t *testing.T) {
+	h, b, err := parseCanonicalization("")
+	require.NoError(t, err)
+	assert.EqualValues(t, dkim.CanonicalizationRelaxed, h)
+	assert.EqualValues(t, dkim.CanonicalizationRelaxed, b)
+
+	h, b, err = parseCanonicalization("relaxed")
+	require.NoError(t, err)
+	assert.EqualValues(t, dkim.CanonicalizationRelaxed, h)
+	assert.EqualValues(t, dkim.CanonicalizationSimple, b)
+}
+
+// renderingSender renders each message as it would go over the wire.
+type renderingSender struct{ sent [][]byte }
+
+func (r *renderingSender) Send(msg *mail.Msg) error {
+	data, err := renderMessage(msg)
+	r.sent = append(r.sent, data)
+	return err
+}
+func (r *renderingSender) Reconnect() error { return nil }
+func (r *renderingSender) Close() error     { return nil }
+
+func TestSendAllSignsWithDKIM(t *testing.T) {
+	keyPath, txt := writeDKIMKey(t)
+	signer, err := NewDKIMSigner(config.DKIMConfig{PrivateKey: keyPath, Selector: "gmt"}, "sender@example.com")
+	require.NoError(t, err)
+
+	sender := &renderingSender{}
+	cfg := config.MailConfig{From: "sender@example.com"}
+	var out bytes.Buffer
+	result := NewBatchSender(&out, sender, cfg, SendOptions{DKIM: signer}).SendAll([]Message{
+		{Address: "a@example.com", Subject: "Hi", Body: "Hello"},
+	})
+	require.Equal(t, 1, result.Sent)
+	require.Len(t, sender.sent, 1)
+
+	verifications, err := dkim.VerifyWithOptions(bytes.NewReader(sender.sent[0]), &dkim.VerifyOptions{
+		LookupTXT: func(string) ([]string, error) { return []string{txt}, nil },
+	})
+	require.NoError(t, err)
+	require.Len(t, verifications, 1)
+	assert.NoError(t, verifications[0].Err)
+}
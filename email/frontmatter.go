@@ -0,0 +1,228 @@
+// gmt sends emails in bulk based on a template and a config file.
+// Copyright (C) 2019-2025  "Muharem Hrnjadovic" <muharem@linux.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package email
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+
+	"github.com/al-maisan/gmt/config"
+)
+
+// Body formats a template's front matter may declare.
+const (
+	FormatText     = "text"     // the template is the text/plain body (default)
+	FormatHTML     = "html"     // the template is the HTML body; the text part is generated
+	FormatMarkdown = "markdown" // the template is the text body and is rendered to HTML
+)
+
+// Front matter fences: TOML between "+++" lines, or RFC 822-style
+// "Name: value" lines between "---" lines.
+const (
+	fenceTOML   = "+++"
+	fenceHeader = "---"
+)
+
+// FrontMatter is the block a template may begin with to declare its own
+// subject, headers, attachments and body format. Set fields take precedence
+// over the [general] ones; see Templates.
+type FrontMatter struct {
+	Subject     string            `toml:"subject"`
+	Format      string            `toml:"format"`
+	Attachments []string          `toml:"attachments"`
+	Headers     map[string]string `toml:"headers"`
+}
+
+// SplitFrontMatter separates the front matter at the top of template from the
+// body that follows it. The first line must be a fence ("+++" or "---") and
+// the block ends at the next line holding the same fence. Without a fence on
+// the first line, the front matter is nil and body is template unchanged.
+func SplitFrontMatter(template string) (fm *FrontMatter, body string, err error) {
+	first, rest, _ := strings.Cut(template, "\n")
+	fence := strings.TrimRight(first, " \t\r")
+	if fence != fenceTOML && fence != fenceHeader {
+		return nil, template, nil
+	}
+	var block []string
+	closed := false
+	for rest != "" {
+		var line string
+		line, rest, _ = strings.Cut(rest, "\n")
+		line = strings.TrimSuffix(line, "\r")
+		if strings.TrimRight(line, " \t") == fence {
+			closed = true
+			break
+		}
+		block = append(block, line)
+	}
+	if !closed {
+		return nil, "", fmt.Errorf("front matter: no closing %q line", fence)
+	}
+
+	if fence == fenceTOML {
+		fm, err = parseTOMLFrontMatter(strings.Join(block, "\n"))
+	} else {
+		fm, err = parseHeaderFrontMatter(block)
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("front matter: %w", err)
+	}
+	if err := fm.check(); err != nil {
+		return nil, "", fmt.Errorf("front matter: %w", err)
+	}
+	return fm, rest, nil
+}
+
+// TemplateFrontMatter returns the front matter for a run, given the front
+// matter split off the text template and off the HTML template (either may
+// be nil). Only one of them may have any. The HTML template is the HTML body
+// already, so its front matter may only declare FormatHTML, and the format
+// is dropped from the result.
+func TemplateFrontMatter(text, html *FrontMatter) (*FrontMatter, error) {
+	switch {
+	case text != nil && html != nil:
+		return nil, fmt.Errorf("both the text and the HTML template have front matter; keep it in one of them")
+	case html != nil:
+		if html.Format != "" && html.Format != FormatHTML {
+			return nil, fmt.Errorf("HTML template: front matter: format must be %q or left out, got %q", FormatHTML, html.Format)
+		}
+		fm := *html
+		fm.Format = ""
+		return &fm, nil
+	}
+	return text, nil
+}
+
+func parseTOMLFrontMatter(text string) (*FrontMatter, error) {
+	var fm FrontMatter
+	md, err := toml.Decode(text, &fm)
+	if err != nil {
+		return nil, fmt.Errorf("TOML syntax error: %w", err)
+	}
+	if undecoded := md.Undecoded(); len(undecoded) > 0 {
+		return nil, fmt.Errorf("unknown key %q", undecoded[0].String())
+	}
+	return &fm, nil
+}
+
+// parseHeaderFrontMatter reads "Name: value" lines. A line starting with a
+// space or tab continues the previous one. Subject, Format and Attachments (a
+// comma-separated list) set those fields; any other name is an extra header.
+func parseHeaderFrontMatter(lines []string) (*FrontMatter, error) {
+	type field struct{ name, value string }
+	var fields []field
+	for i, line := range lines {
+		switch {
+		case strings.TrimSpace(line) == "":
+			continue
+		case line[0] == ' ' || line[0] == '\t':
+			if len(fields) == 0 {
+				return nil, fmt.Errorf("line %d: continuation line without a header", i+2)
+			}
+			fields[len(fields)-1].value += " " + strings.TrimSpace(line)
+		default:
+			name, value, ok := strings.Cut(line, ":")
+			if !ok {
+				return nil, fmt.Errorf("line %d: expected \"Name: value\", got %q", i+2, line)
+			}
+			fields = append(fields, field{strings.TrimSpace(name), strings.TrimSpace(value)})
+		}
+	}
+
+	var fm FrontMatter
+	seen := make(map[string]bool, len(fields))
+	for _, f := range fields {
+		key := strings.ToLower(f.name)
+		if seen[key] {
+			return nil, fmt.Errorf("%q is given more than once", f.name)
+		}
+		seen[key] = true
+		switch key {
+		case "subject":
+			fm.Subject = f.value
+		case "format":
+			fm.Format = strings.ToLower(f.value)
+		case "attachments":
+			for _, path := range strings.Split(f.value, ",") {
+				if path = strings.TrimSpace(path); path != "" {
+					fm.Attachments = append(fm.Attachments, path)
+				}
+			}
+		default:
+			if fm.Headers == nil {
+				fm.Headers = make(map[string]string)
+			}
+			fm.Headers[f.name] = f.value
+		}
+	}
+	return &fm, nil
+}
+
+// check validates the fields common to both front matter styles.
+func (fm *FrontMatter) check() error {
+	switch fm.Format {
+	case "", FormatText, FormatHTML, FormatMarkdown:
+	default:
+		return fmt.Errorf("format must be %q, %q or %q, got %q", FormatText, FormatHTML, FormatMarkdown, fm.Format)
+	}
+	if fm.Subject != "" && strings.TrimSpace(fm.Subject) == "" {
+		return fmt.Errorf("subject must not be whitespace")
+	}
+	if err := config.CheckHeaderValue("Subject", fm.Subject); err != nil {
+		return err
+	}
+	if err := config.CheckHeaders(fm.Headers); err != nil {
+		return fmt.Errorf("headers: %w", err)
+	}
+	return nil
+}
+
+// markdown converts Markdown bodies to HTML: CommonMark plus the GitHub
+// extensions (tables, strikethrough, autolinks, task lists). Raw HTML in the
+// source is omitted.
+var markdown = goldmark.New(goldmark.WithExtensions(extension.GFM))
+
+// markdownToHTML renders a substituted Markdown body as HTML.
+func markdownToHTML(source string) (string, error) {
+	var b bytes.Buffer
+	if err := markdown.Convert([]byte(source), &b); err != nil {
+		return "", fmt.Errorf("rendering Markdown: %w", err)
+	}
+	return b.String(), nil
+}
+
+// markdownPunctuation is the ASCII punctuation CommonMark allows to be
+// backslash-escaped.
+const markdownPunctuation = "!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~"
+
+// escapeMarkdown backslash-escapes the punctuation in s, so it renders as
+// literal text rather than as markup, links or raw HTML.
+func escapeMarkdown(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(markdownPunctuation, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,208 @@
+// gmt sends emails in bulk based on a template and a config file.
+// Copyright (C) 2019-2025  "Muharem Hrnjadovic" <muharem@linux.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package email
+
+import (
+	"testing"
+
+	"github.com/al-maisan/gmt/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitFrontMatter(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		want     *FrontMatter
+		wantBody string
+	}{
+		{"none", "Hello %FN%\n", nil, "Hello %FN%\n"},
+		{"fence not on first line", "Hi\n---\nSubject: x\n---\n", nil, "Hi\n---\nSubject: x\n---\n"},
+		{
+			"TOML",
+			"+++\nsubject = \"Hi %FN%\"\nformat = \"markdown\"\nattachments = [\"a.pdf\", \"b, c.pdf\"]\n[headers]\nX-Campaign = \"autumn\"\n+++\nHello\n",
+			&FrontMatter{Subject: "Hi %FN%", Format: FormatMarkdown, Attachments: []string{"a.pdf", "b, c.pdf"}, Headers: map[string]string{"X-Campaign": "autumn"}},
+			"Hello\n",
+		},
+		{
+			"headers",
+			"---\r\nsubject: Hi %FN%\r\nFormat: HTML\r\nAttachments: a.pdf, b.pdf\r\nX-Campaign: autumn,\r\n  winter\r\n\r\n---\r\n<p>Hello</p>\r\n",
+			&FrontMatter{Subject: "Hi %FN%", Format: FormatHTML, Attachments: []string{"a.pdf", "b.pdf"}, Headers: map[string]string{"X-Campaign": "autumn, winter"}},
+			"<p>Hello</p>\r\n",
+		},
+		{"empty", "---\n---\nHello", &FrontMatter{}, "Hello"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fm, body, err := SplitFrontMatter(tt.template)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, fm)
+			assert.Equal(t, tt.wantBody, body)
+		})
+	}
+}
+
+func TestSplitFrontMatterErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		wantErr  string
+	}{
+		{"unclosed", "---\nSubject: Hi\nHello\n", `no closing "---" line`},
+		{"mismatched fence", "+++\nsubject = \"Hi\"\n---\nHello\n", `no closing "+++" line`},
+		{"TOML syntax", "+++\nsubject = Hi\n+++\n", "TOML syntax error"},
+		{"unknown TOML key", "+++\nsubjet = \"Hi\"\n+++\n", `unknown key "subjet"`},
+		{"no colon", "---\nSubject Hi\n---\n", `line 2: expected "Name: value"`},
+		{"leading continuation", "---\n  Hi\n---\n", "line 2: continuation line without a header"},
+		{"duplicate", "---\nSubject: a\nsubject: b\n---\n", `"subject" is given more than once`},
+		{"bad format", "---\nFormat: pdf\n---\n", `format must be "text", "html" or "markdown", got "pdf"`},
+		{"whitespace subject", "+++\nsubject = \" \"\n+++\n", "subject must not be whitespace"},
+		{"structural header", "---\nFrom: x@example.com\n---\n", "headers: "},
+		{"header line break", "+++\n[headers]\nX-Tag = \"a\\nb\"\n+++\n", "value must not contain line breaks"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := SplitFrontMatter(tt.template)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), "front matter: ")
+			assert.Contains(t, err.Error(), tt.wantErr)
+		})
+	}
+}
+
+func TestPrepMailsFrontMatterPrecedence(t *testing.T) {
+	cfg := config.MailConfig{
+		Subject:     "General subject",
+		Attachments: []string{"general.pdf"},
+		Headers:     map[string]string{"X-General": "yes"},
+		Recipients: []config.Recipient{
+			{Email: "a@example.com", First: "A"},
+			{Email: "b@example.com", First: "B", Subject: "Own subject", AttachmentsExtra: []string{"b.pdf"},
+				HeadersExtra: map[string]string{"X-Tag": "b"}},
+		},
+	}
+	tmpl := "+++\nsubject = \"Hi %FN%\"\nattachments = [\"front.pdf\"]\n[headers]\nX-Tag = \"%FN%\"\n+++\nHello %FN%\n"
+	mails, _, err := PrepMails(&cfg, Templates{Text: tmpl})
+	require.NoError(t, err)
+
+	assert.Equal(t, "Hi A", mails[0].Subject)
+	assert.Equal(t, "Hello A\n", mails[0].Body)
+	assert.Empty(t, mails[0].HTMLBody)
+	assert.Equal(t, []string{"front.pdf"}, mails[0].Attachments)
+	assert.Equal(t, map[string]string{"X-Tag": "A"}, mails[0].Headers)
+
+	assert.Equal(t, "Own subject", mails[1].Subject)
+	assert.Equal(t, []string{"front.pdf", "b.pdf"}, mails[1].Attachments)
+	assert.Equal(t, map[string]string{"X-Tag": "b"}, mails[1].Headers)
+
+	assert.Equal(t, "General subject", cfg.Subject, "config must not be mutated")
+}
+
+func TestPrepMailsFrontMatterFormats(t *testing.T) {
+	cfg := config.MailConfig{
+		Recipients: []config.Recipient{{Email: "a@example.com", First: "A & B"}},
+	}
+
+	mails, _, err := PrepMails(&cfg, Templates{Text: "---\nSubject: Hi\nFormat: html\n---\n<p>Dear %FN%</p>"})
+	require.NoError(t, err)
+	assert.Equal(t, "<p>Dear A &amp; B</p>", mails[0].HTMLBody)
+	assert.Equal(t, "Dear A & B", mails[0].Body)
+
+	mails, _, err = PrepMails(&cfg, Templates{Text: "---\nSubject: Hi\nFormat: markdown\n---\n# Dear %FN%\n\n<b>raw</b>\n"})
+	require.NoError(t, err)
+	assert.Equal(t, "# Dear A & B\n\n<b>raw</b>\n", mails[0].Body)
+	assert.Equal(t, "<h1>Dear A &amp; B</h1>\n<p><!-- raw HTML omitted -->raw<!-- raw HTML omitted --></p>\n", mails[0].HTMLBody)
+
+	cfg.Recipients[0].Data = map[string]string{"NOTE": "<b>*bold*</b> [click](https://evil.example)"}
+	mails, _, err = PrepMails(&cfg, Templates{Text: "---\nSubject: Hi\nFormat: markdown\n---\nDear %FN%: *%NOTE%*\n"})
+	require.NoError(t, err)
+	assert.Equal(t, "Dear A & B: *<b>*bold*</b> [click](https://evil.example)*\n", mails[0].Body, "the text part is not escaped")
+	assert.Equal(t, "<p>Dear A &amp; B: <em>&lt;b&gt;*bold*&lt;/b&gt; [click](https://evil.example)</em></p>\n", mails[0].HTMLBody,
+		"values are Markdown-escaped")
+
+	_, _, err = PrepMails(&cfg, Templates{Text: "---\nSubject: Hi\nFormat: markdown\n---\nDear %FN%", HTML: "<p>x</p>"})
+	assert.ErrorContains(t, err, `front matter format "markdown" conflicts with an HTML template`)
+
+	_, _, err = PrepMails(&cfg, Templates{Text: "---\nSubject: Hi\n---\n  \n"})
+	assert.ErrorContains(t, err, "template has no body after the front matter")
+}
+
+func TestPrepMailsFrontMatterSubject(t *testing.T) {
+	cfg := config.MailConfig{
+		Recipients: []config.Recipient{
+			{Email: "a@example.com", First: "A", Subject: "Own"},
+			{Email: "b@example.com", First: "B"},
+		},
+	}
+	_, _, err := PrepMails(&cfg, Templates{Text: "Hello"})
+	assert.ErrorContains(t, err, "no subject for recipient 'b@example.com': set subject in [general] or in the template front matter")
+
+	_, _, err = PrepMails(&cfg, Templates{Text: "---\nSubject: Hi %ORG%\nX-Tag: %TAG%\n---\nHello"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unresolved placeholders:")
+	assert.Contains(t, err.Error(), "recipient 'b@example.com': subject: unresolved placeholder(s): %ORG%")
+	assert.Contains(t, err.Error(), "recipient 'a@example.com': header X-Tag: unresolved placeholder(s): %TAG%")
+
+	mails, _, err := PrepMails(&cfg, Templates{Text: "---\nSubject: Set\n---\nHello"})
+	require.NoError(t, err)
+	assert.Equal(t, "Own", mails[0].Subject)
+	assert.Equal(t, "Set", mails[1].Subject)
+	assert.Equal(t, "Hello", mails[1].Body)
+
+	_, _, err = PrepMails(&cfg, Templates{Text: "---\nSubject Set\n---\nHello"})
+	assert.ErrorContains(t, err, `template: front matter: line 2: expected "Name: value"`)
+}
+
+func TestTemplateFrontMatter(t *testing.T) {
+	text := &FrontMatter{Subject: "Text"}
+	html := &FrontMatter{Subject: "HTML", Format: FormatHTML}
+
+	fm, err := TemplateFrontMatter(nil, nil)
+	require.NoError(t, err)
+	assert.Nil(t, fm)
+
+	fm, err = TemplateFrontMatter(text, nil)
+	require.NoError(t, err)
+	assert.Same(t, text, fm)
+
+	fm, err = TemplateFrontMatter(nil, html)
+	require.NoError(t, err)
+	assert.Equal(t, &FrontMatter{Subject: "HTML"}, fm)
+	assert.Equal(t, FormatHTML, html.Format, "the argument is not modified")
+
+	_, err = TemplateFrontMatter(text, html)
+	assert.ErrorContains(t, err, "both the text and the HTML template have front matter")
+
+	_, err = TemplateFrontMatter(nil, &FrontMatter{Format: FormatMarkdown})
+	assert.ErrorContains(t, err, `HTML template: front matter: format must be "html" or left out, got "markdown"`)
+}
+
+func TestPrepMailsHTMLFrontMatter(t *testing.T) {
+	cfg := config.MailConfig{
+		Subject:    "General",
+		Recipients: []config.Recipient{{Email: "a@example.com", First: "A"}},
+	}
+	mails, _, err := PrepMails(&cfg, Templates{Text: "Dear %FN%", HTML: "---\nSubject: Hi %FN%\nFormat: html\n---\n<p>Dear %FN%</p>"})
+	require.NoError(t, err)
+	assert.Equal(t, "Hi A", mails[0].Subject)
+	assert.Equal(t, "Dear A", mails[0].Body)
+	assert.Equal(t, "<p>Dear A</p>", mails[0].HTMLBody, "the front matter is not part of the body")
+
+	_, _, err = PrepMails(&cfg, Templates{HTML: "---\nSubject Hi\n---\n<p>x</p>"})
+	assert.ErrorContains(t, err, `HTML template: front matter: line 2: expected "Name: value"`)
+}
@@ -0,0 +1,135 @@
+// gmt sends emails in bulk based on a template and a config file.
+// Copyright (C) 2019-2025  "Muharem Hrnjadovic" <muharem@linux.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package email
+
+import (
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// htmlBlockElements start and end a paragraph in the text rendering.
+var htmlBlockElements = map[atom.Atom]bool{
+	atom.P: true, atom.Div: true, atom.Table: true, atom.Tr: true,
+	atom.Ul: true, atom.Ol: true, atom.Blockquote: true, atom.Pre: true,
+	atom.H1: true, atom.H2: true, atom.H3: true, atom.H4: true, atom.H5: true, atom.H6: true,
+	atom.Section: true, atom.Article: true, atom.Header: true, atom.Footer: true,
+}
+
+// htmlSkippedElements have content that is not shown to the reader.
+var htmlSkippedElements = map[atom.Atom]bool{
+	atom.Head: true, atom.Title: true, atom.Script: true, atom.Style: true,
+}
+
+var (
+	reTextSpaces     = regexp.MustCompile(`[ \t\r\n\f]+`)
+	reTrailingSpaces = regexp.MustCompile(`[ \t]+\n`)
+	reBlankLines     = regexp.MustCompile(`\n{3,}`)
+)
+
+// htmlToText renders an HTML body as readable plain text for the
+// multipart/alternative fallback part: block elements become paragraphs, <br>
+// a line break, list items "- " bullets, and links keep their target as
+// "text (url)". Whitespace is collapsed as a browser would, except in <pre>.
+func htmlToText(s string) string {
+	var b strings.Builder
+	z := html.NewTokenizer(strings.NewReader(s))
+	var (
+		skip  int      // depth inside htmlSkippedElements
+		pre   int      // depth inside <pre>
+		hrefs []string // open <a> targets
+		links []int    // b.Len() at each open <a>
+	)
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			break
+		}
+		tok := z.Token()
+		switch tt {
+		case html.TextToken:
+			if skip > 0 {
+				continue
+			}
+			text := tok.Data
+			if pre == 0 {
+				text = reTextSpaces.ReplaceAllString(text, " ")
+				if cur := b.String(); cur == "" || strings.HasSuffix(cur, "\n") || strings.HasSuffix(cur, " ") {
+					text = strings.TrimLeft(text, " ")
+				}
+			}
+			b.WriteString(text)
+		case html.StartTagToken, html.SelfClosingTagToken:
+			switch {
+			case htmlSkippedElements[tok.DataAtom]:
+				if tt == html.StartTagToken {
+					skip++
+				}
+			case tok.DataAtom == atom.Br:
+				b.WriteString("\n")
+			case tok.DataAtom == atom.Hr:
+				b.WriteString("\n\n----\n\n")
+			case tok.DataAtom == atom.Li:
+				b.WriteString("\n- ")
+			case tok.DataAtom == atom.Td || tok.DataAtom == atom.Th:
+				b.WriteString(" ")
+			case tok.DataAtom == atom.A && tt == html.StartTagToken:
+				hrefs = append(hrefs, attr(tok, "href"))
+				links = append(links, b.Len())
+			case htmlBlockElements[tok.DataAtom]:
+				b.WriteString("\n\n")
+				if tok.DataAtom == atom.Pre {
+					pre++
+				}
+			}
+		case html.EndTagToken:
+			switch {
+			case htmlSkippedElements[tok.DataAtom]:
+				skip = max(skip-1, 0)
+			case tok.DataAtom == atom.A && len(hrefs) > 0:
+				href, start := hrefs[len(hrefs)-1], links[len(links)-1]
+				hrefs, links = hrefs[:len(hrefs)-1], links[:len(links)-1]
+				text := strings.TrimSpace(b.String()[start:])
+				target := strings.TrimPrefix(href, "mailto:")
+				if href != "" && text != target {
+					b.WriteString(" (" + href + ")")
+				}
+			case htmlBlockElements[tok.DataAtom]:
+				b.WriteString("\n\n")
+				if tok.DataAtom == atom.Pre {
+					pre = max(pre-1, 0)
+				}
+			}
+		}
+	}
+
+	out := reTrailingSpaces.ReplaceAllString(b.String(), "\n")
+	out = reBlankLines.ReplaceAllString(out, "\n\n")
+	return strings.TrimSpace(out)
+}
+
+// attr returns the value of the named attribute of tok, or "".
+func attr(tok html.Token, name string) string {
+	for _, a := range tok.Attr {
+		if a.Key == name {
+			return a.Val
+		}
+	}
+	return ""
+}
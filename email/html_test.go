@@ -0,0 +1,129 @@
+// gmt sends emails in bulk based on a template and a config file.
+// Copyright (C) 2019-2025  "Muharem Hrnjadovic" <muharem@linux.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package email
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/al-maisan/gmt/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTMLToText(t *testing.T) {
+	tests := []struct {
+		name string
+		html string
+		want string
+	}{
+		{
+			name: "paragraphs and breaks",
+			html: "<html><head><title>x</title><style>p{}</style></head><body><p>Dear  John,</p>\n<p>line one<br>line two</p></body></html>",
+			want: "Dear John,\n\nline one\nline two",
+		},
+		{
+			name: "list",
+			html: "<p>Items:</p><ul><li>rope</li><li> pans </li></ul>",
+			want: "Items:\n\n- rope\n- pans",
+		},
+		{
+			name: "links",
+			html: `<a href="https://example.com/x">Click here</a> or <a href="mailto:a@b.com">a@b.com</a>`,
+			want: "Click here (https://example.com/x) or a@b.com",
+		},
+		{
+			name: "entities",
+			html: "<p>Fish &amp; Chips &lt;3</p>",
+			want: "Fish & Chips <3",
+		},
+		{
+			name: "pre keeps whitespace",
+			html: "<pre>a  b\n  c</pre>",
+			want: "a  b\n  c",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, htmlToText(tt.html))
+		})
+	}
+}
+
+func TestPrepMailsHTMLOnly(t *testing.T) {
+	cfg := config.MailConfig{
+		Subject: "Hi %FN%",
+		Recipients: []config.Recipient{
+			{Email: "a@b.com", First: "Tom & Jerry", Data: map[string]string{}},
+		},
+	}
+	mails, _, err := PrepMails(&cfg, Templates{HTML: "<p>Dear <b>%FN%</b>,</p><p>Bye</p>"})
+	require.NoError(t, err)
+	require.Len(t, mails, 1)
+	assert.Equal(t, "<p>Dear <b>Tom &amp; Jerry</b>,</p><p>Bye</p>", mails[0].HTMLBody, "values must be HTML-escaped")
+	assert.Equal(t, "Dear Tom & Jerry,\n\nBye", mails[0].Body, "text part is generated from the HTML")
+}
+
+func TestPrepMailsTextAndHTML(t *testing.T) {
+	cfg := config.MailConfig{
+		Subject:    "Hi",
+		Recipients: []config.Recipient{{Email: "a@b.com", First: "Alice", Data: map[string]string{}}},
+	}
+	mails, _, err := PrepMails(&cfg, Templates{Text: "Dear %FN%", HTML: "<p>Dear %FN%</p>"})
+	require.NoError(t, err)
+	require.Len(t, mails, 1)
+	assert.Equal(t, "Dear Alice", mails[0].Body)
+	assert.Equal(t, "<p>Dear Alice</p>", mails[0].HTMLBody)
+}
+
+func TestPrepMailsHTMLUnresolved(t *testing.T) {
+	cfg := config.MailConfig{
+		Subject:    "Hi",
+		Recipients: []config.Recipient{{Email: "a@b.com", First: "Alice", Data: map[string]string{}}},
+	}
+	_, _, err := PrepMails(&cfg, Templates{Text: "Dear %FN%", HTML: "<p>%ROLE%</p>"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "recipient 'a@b.com': HTML body: unresolved placeholder(s): %ROLE%")
+}
+
+func TestPrepMailsGoEngineHTMLEscaping(t *testing.T) {
+	cfg := config.MailConfig{
+		Subject:        "Hi",
+		TemplateEngine: config.TemplateEngineGo,
+		Recipients:     []config.Recipient{{Email: "a@b.com", First: "<script>", Data: map[string]string{}}},
+	}
+	mails, _, err := PrepMails(&cfg, Templates{HTML: "<p>{{.FN}}</p>"})
+	require.NoError(t, err)
+	assert.Equal(t, "<p>&lt;script&gt;</p>", mails[0].HTMLBody)
+}
+
+func TestCreateMessageHTMLAlternative(t *testing.T) {
+	msg, err := createMessage("s@s.com", "", Message{
+		Name: "A", Address: "a@b.com", Subject: "s", Body: "plain text", HTMLBody: "<p>rich text</p>",
+	})
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	_, err = msg.WriteTo(&buf)
+	require.NoError(t, err)
+	out := buf.String()
+	assert.Contains(t, out, "multipart/alternative")
+	assert.Contains(t, out, "text/plain")
+	assert.Contains(t, out, "text/html")
+	assert.Less(t, bytes.Index(buf.Bytes(), []byte("plain text")), bytes.Index(buf.Bytes(), []byte("rich text")),
+		"the preferred HTML part must come last")
+}
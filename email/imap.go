@@ -0,0 +1,393 @@
+// gmt sends emails in bulk based on a template and a config file.
+// Copyright (C) 2019-2025  "Muharem Hrnjadovic" <muharem@linux.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package email
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf16"
+
+	mail "github.com/wneessen/go-mail"
+)
+
+// IMAP TLS modes, selected with IMAP_TLS.
+const (
+	imapTLSImplicit = "tls"      // TLS from the first byte, usually port 993
+	imapTLSStartTLS = "starttls" // STARTTLS, usually port 143
+)
+
+// defaultIMAPFolder is used when IMAP_FOLDER is not set.
+const defaultIMAPFolder = "Sent"
+
+// IMAPConfig holds the IMAP server and folder that copies of sent messages
+// are appended to, read from the environment by LoadIMAPConfig.
+type IMAPConfig struct {
+	Host     string // IMAP_HOST
+	Port     int    // IMAP_PORT; defaults to 993, or 143 with starttls
+	User     string // IMAP_USER; defaults to SENDER_EMAIL
+	Password string // IMAP_PASSWORD; defaults to SENDER_PASSWORD
+	Folder   string // IMAP_FOLDER; defaults to "Sent"
+	TLS      string // IMAP_TLS: "tls" (default) or "starttls"
+	CAFile   string // IMAP_CA_FILE: PEM bundle replacing the system roots
+}
+
+// LoadIMAPConfig reads the IMAP settings from environment variables. Returns
+// an error listing any missing variables, or describing an invalid setting.
+func LoadIMAPConfig() (IMAPConfig, error) {
+	cfg := IMAPConfig{
+		Host:     os.Getenv("IMAP_HOST"),
+		User:     os.Getenv("IMAP_USER"),
+		Password: os.Getenv("IMAP_PASSWORD"),
+		Folder:   os.Getenv("IMAP_FOLDER"),
+		TLS:      strings.ToLower(os.Getenv("IMAP_TLS")),
+		CAFile:   os.Getenv("IMAP_CA_FILE"),
+	}
+	if cfg.User == "" {
+		cfg.User = os.Getenv("SENDER_EMAIL")
+	}
+	if cfg.Password == "" {
+		cfg.Password = os.Getenv("SENDER_PASSWORD")
+	}
+	if cfg.Folder == "" {
+		cfg.Folder = defaultIMAPFolder
+	}
+
+	var missing []string
+	if cfg.Host == "" {
+		missing = append(missing, "IMAP_HOST")
+	}
+	if cfg.User == "" {
+		missing = append(missing, "IMAP_USER (or SENDER_EMAIL)")
+	}
+	if cfg.Password == "" {
+		missing = append(missing, "IMAP_PASSWORD (or SENDER_PASSWORD)")
+	}
+	if len(missing) > 0 {
+		return IMAPConfig{}, fmt.Errorf("missing required environment variable(s): %s", strings.Join(missing, ", "))
+	}
+
+	switch cfg.TLS {
+	case "":
+		cfg.TLS = imapTLSImplicit
+	case imapTLSImplicit, imapTLSStartTLS:
+	default:
+		return IMAPConfig{}, fmt.Errorf("IMAP_TLS must be %q or %q, got %q", imapTLSImplicit, imapTLSStartTLS, cfg.TLS)
+	}
+	cfg.Port = 993
+	if cfg.TLS == imapTLSStartTLS {
+		cfg.Port = 143
+	}
+	if v := os.Getenv("IMAP_PORT"); v != "" {
+		port, err := strconv.Atoi(v)
+		if err != nil {
+			return IMAPConfig{}, fmt.Errorf("IMAP_PORT must be a valid integer, got %q", v)
+		}
+		cfg.Port = port
+	}
+	if strings.ContainsAny(cfg.User+cfg.Password+cfg.Folder, "\r\n\x00") {
+		return IMAPConfig{}, fmt.Errorf("IMAP_USER, IMAP_PASSWORD and IMAP_FOLDER must not contain line breaks or NUL")
+	}
+	if _, err := cfg.tlsConfig(); err != nil {
+		return IMAPConfig{}, err
+	}
+	return cfg, nil
+}
+
+// IMAPAppender appends copies of sent messages to an IMAP folder over one
+// connection, which is re-established if it drops. It is safe for concurrent
+// use; appends are serialized.
+type IMAPAppender struct {
+	cfg     IMAPConfig
+	timeout time.Duration
+
+	mu   sync.Mutex
+	conn net.Conn
+	r    *bufio.Reader
+	tag  int
+}
+
+// NewIMAPAppender connects and logs in to the server in cfg and checks that
+// the folder exists. A positive timeout bounds connecting and each command.
+// The caller must call Close when done.
+func NewIMAPAppender(cfg IMAPConfig, timeout time.Duration) (*IMAPAppender, error) {
+	a := &IMAPAppender{cfg: cfg, timeout: timeout}
+	if err := a.dial(); err != nil {
+		return nil, err
+	}
+	if _, err := a.cmd(nil, "STATUS %s (MESSAGES)", imapQuote(imapUTF7(cfg.Folder))); err != nil {
+		a.Close() //nolint:errcheck
+		return nil, fmt.Errorf("imap: folder %q: %w", cfg.Folder, err)
+	}
+	return a, nil
+}
+
+// Folder returns the folder messages are appended to.
+func (a *IMAPAppender) Folder() string { return a.cfg.Folder }
+
+// Append stores msg, exactly as rendered for sending, in the folder and marks
+// it as read. After a connection failure it re-dials and tries once more.
+func (a *IMAPAppender) Append(msg *mail.Msg) error {
+	data, err := renderCopy(msg)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	err = a.appendData(data)
+	var ce *connError
+	if errors.As(err, &ce) {
+		if err = a.dial(); err == nil {
+			err = a.appendData(data)
+		}
+	}
+	return err
+}
+
+// Close logs out and closes the connection.
+func (a *IMAPAppender) Close() error {
+	if a.conn == nil {
+		return nil
+	}
+	_, _ = a.cmd(nil, "LOGOUT")
+	err := a.conn.Close()
+	a.conn, a.r = nil, nil
+	return err
+}
+
+// --- internal ---
+
+func (a *IMAPAppender) appendData(data []byte) error {
+	if a.conn == nil {
+		return &connError{fmt.Errorf("imap: not connected to %s", a.cfg.Host)}
+	}
+	_, err := a.cmd(data, "APPEND %s (\\Seen) {%d}", imapQuote(imapUTF7(a.cfg.Folder)), len(data))
+	if err != nil {
+		return fmt.Errorf("failed to save to IMAP folder %q: %w", a.cfg.Folder, err)
+	}
+	return nil
+}
+
+func (a *IMAPAppender) dial() error {
+	address := net.JoinHostPort(a.cfg.Host, strconv.Itoa(a.cfg.Port))
+	tlsConfig, err := a.cfg.tlsConfig()
+	if err != nil {
+		return err
+	}
+	dialer := &net.Dialer{Timeout: a.timeout}
+	var conn net.Conn
+	if a.cfg.TLS == imapTLSImplicit {
+		conn, err = tls.DialWithDialer(dialer, "tcp", address, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", address)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to connect to IMAP server %s: %w", address, err)
+	}
+	a.conn, a.r, a.tag = conn, bufio.NewReader(conn), 0
+	a.setDeadline()
+
+	if err := a.greeting(); err != nil {
+		a.conn.Close() //nolint:errcheck
+		a.conn = nil
+		return fmt.Errorf("IMAP server %s: %w", address, err)
+	}
+	if a.cfg.TLS == imapTLSStartTLS {
+		if _, err := a.cmd(nil, "STARTTLS"); err != nil {
+			a.conn.Close() //nolint:errcheck
+			a.conn = nil
+			return fmt.Errorf("IMAP server %s: STARTTLS: %w", address, err)
+		}
+		tlsConn := tls.Client(a.conn, tlsConfig)
+		if err := tlsConn.Handshake(); err != nil {
+			a.conn.Close() //nolint:errcheck
+			a.conn = nil
+			return fmt.Errorf("IMAP server %s: STARTTLS: %w", address, err)
+		}
+		a.conn, a.r = tlsConn, bufio.NewReader(tlsConn)
+	}
+	if _, err := a.cmd(nil, "LOGIN %s %s", imapQuote(a.cfg.User), imapQuote(a.cfg.Password)); err != nil {
+		a.conn.Close() //nolint:errcheck
+		a.conn = nil
+		return fmt.Errorf("IMAP server %s: login failed: %w", address, err)
+	}
+	return nil
+}
+
+func (a *IMAPAppender) setDeadline() {
+	if a.timeout > 0 {
+		_ = a.conn.SetDeadline(time.Now().Add(a.timeout))
+	}
+}
+
+// broken closes the connection after an I/O failure and returns err as a
+// *connError, so the next append re-dials.
+func (a *IMAPAppender) broken(err error) error {
+	if a.conn != nil {
+		_ = a.conn.Close()
+		a.conn, a.r = nil, nil
+	}
+	return &connError{err}
+}
+
+func (a *IMAPAppender) greeting() error {
+	line, err := a.readLine()
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(line, "* OK") && !strings.HasPrefix(line, "* PREAUTH") {
+		return fmt.Errorf("unexpected greeting %q", line)
+	}
+	return nil
+}
+
+// cmd sends a tagged command and reads responses up to its completion. If
+// literal is non-nil, the command must end in a literal announcement such as
+// "{123}", and literal is sent once the server asks for it. It returns the
+// text of the OK response; NO and BAD responses are errors.
+func (a *IMAPAppender) cmd(literal []byte, format string, args ...any) (string, error) {
+	a.setDeadline()
+	a.tag++
+	tag := fmt.Sprintf("g%d", a.tag)
+	if _, err := fmt.Fprintf(a.conn, "%s %s\r\n", tag, fmt.Sprintf(format, args...)); err != nil {
+		return "", a.broken(fmt.Errorf("imap: %w", err))
+	}
+	for {
+		line, err := a.readLine()
+		if err != nil {
+			return "", err
+		}
+		switch {
+		case strings.HasPrefix(line, "+"):
+			if literal == nil {
+				return "", a.broken(fmt.Errorf("imap: unexpected continuation request"))
+			}
+			if _, err := a.conn.Write(append(literal, '\r', '\n')); err != nil {
+				return "", a.broken(fmt.Errorf("imap: %w", err))
+			}
+			literal = nil
+		case strings.HasPrefix(line, tag+" "):
+			status, text, _ := strings.Cut(strings.TrimPrefix(line, tag+" "), " ")
+			if strings.EqualFold(status, "OK") {
+				return text, nil
+			}
+			return "", fmt.Errorf("%s %s", strings.ToUpper(status), text)
+		}
+		// Untagged responses are not needed here.
+	}
+}
+
+// readLine reads one response line, skipping over any literals it contains.
+func (a *IMAPAppender) readLine() (string, error) {
+	var b strings.Builder
+	for {
+		line, err := a.r.ReadString('\n')
+		if err != nil {
+			return "", a.broken(fmt.Errorf("imap: %w", err))
+		}
+		line = strings.TrimRight(line, "\r\n")
+		b.WriteString(line)
+		n, ok := literalSize(line)
+		if !ok {
+			return b.String(), nil
+		}
+		if _, err := io.CopyN(io.Discard, a.r, n); err != nil {
+			return "", a.broken(fmt.Errorf("imap: %w", err))
+		}
+	}
+}
+
+// literalSize reports the size of the literal announced at the end of line,
+// as in "* 1 FETCH (BODY[] {42}".
+func literalSize(line string) (int64, bool) {
+	if !strings.HasSuffix(line, "}") {
+		return 0, false
+	}
+	open := strings.LastIndexByte(line, '{')
+	if open < 0 {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(strings.TrimSuffix(line[open+1:len(line)-1], "+"), 10, 64)
+	return n, err == nil && n >= 0
+}
+
+// tlsConfig returns the TLS configuration for connecting to c.Host: the
+// system roots or IMAP_CA_FILE.
+func (c IMAPConfig) tlsConfig() (*tls.Config, error) {
+	cfg := &tls.Config{ServerName: c.Host, MinVersion: tls.VersionTLS12}
+	if c.CAFile != "" {
+		pem, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("IMAP_CA_FILE: %w", err)
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("IMAP_CA_FILE: no PEM certificates found in %q", c.CAFile)
+		}
+	}
+	return cfg, nil
+}
+
+// imapQuote returns s as an IMAP quoted string.
+func imapQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// imapUTF7 encodes a mailbox name in IMAP's modified UTF-7 (RFC 3501
+// section 5.1.3), so folders such as "Gesendete Elemente" or "Envoyés" work.
+func imapUTF7(name string) string {
+	var b strings.Builder
+	var run []rune
+	flush := func() {
+		if len(run) == 0 {
+			return
+		}
+		units := utf16.Encode(run)
+		buf := make([]byte, 2*len(units))
+		for i, u := range units {
+			binary.BigEndian.PutUint16(buf[2*i:], u)
+		}
+		enc := base64.RawStdEncoding.EncodeToString(buf)
+		b.WriteString("&" + strings.ReplaceAll(enc, "/", ",") + "-")
+		run = run[:0]
+	}
+	for _, r := range name {
+		switch {
+		case r == '&':
+			flush()
+			b.WriteString("&-")
+		case r >= 0x20 && r <= 0x7e:
+			flush()
+			b.WriteRune(r)
+		default:
+			run = append(run, r)
+		}
+	}
+	flush()
+	return b.String()
+}
@@ -0,0 +1,251 @@
+// gmt sends emails in bulk based on a template and a config file.
+// Copyright (C) 2019-2025  "Muharem Hrnjadovic" <muharem@linux.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package email
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/al-maisan/gmt/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// imapServer is a minimal IMAP server that understands just enough for
+// IMAPAppender: STARTTLS, LOGIN, STATUS, APPEND and LOGOUT.
+type imapServer struct {
+	cfg      IMAPConfig
+	tls      *tls.Config
+	mu       sync.Mutex
+	appended []string
+	logins   int
+	reject   bool // answer APPEND with NO
+	drop     bool // close the connection after the first APPEND
+}
+
+// startIMAPServer listens on localhost with TLS from pki, implicit or via
+// STARTTLS as mode says, and returns the server and a config to reach it.
+func startIMAPServer(t *testing.T, pki testPKI, mode string) *imapServer {
+	t.Helper()
+	srv := &imapServer{tls: &tls.Config{Certificates: []tls.Certificate{pki.server}, MinVersion: tls.VersionTLS12}}
+	var ln net.Listener
+	var err error
+	if mode == imapTLSImplicit {
+		ln, err = tls.Listen("tcp", "127.0.0.1:0", srv.tls)
+	} else {
+		ln, err = net.Listen("tcp", "127.0.0.1:0")
+	}
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go srv.serve(conn)
+		}
+	}()
+	port := ln.Addr().(*net.TCPAddr).Port
+	srv.cfg = IMAPConfig{Host: "127.0.0.1", Port: port, User: "u@example.com", Password: `p"w`, Folder: "Sent", TLS: mode, CAFile: pki.caFile}
+	return srv
+}
+
+func (srv *imapServer) serve(conn net.Conn) {
+	defer func() { conn.Close() }()
+	r := bufio.NewReader(conn)
+	fmt.Fprint(conn, "* OK IMAP4rev1 ready\r\n")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		tag, rest, _ := strings.Cut(strings.TrimRight(line, "\r\n"), " ")
+		verb, args, _ := strings.Cut(rest, " ")
+		switch verb {
+		case "STARTTLS":
+			fmt.Fprintf(conn, "%s OK begin TLS\r\n", tag)
+			tlsConn := tls.Server(conn, srv.tls)
+			if tlsConn.Handshake() != nil {
+				return
+			}
+			conn, r = tlsConn, bufio.NewReader(tlsConn)
+		case "LOGIN":
+			srv.mu.Lock()
+			srv.logins++
+			srv.mu.Unlock()
+			if args != `"u@example.com" "p\"w"` {
+				fmt.Fprintf(conn, "%s NO bad credentials\r\n", tag)
+				continue
+			}
+			fmt.Fprintf(conn, "%s OK logged in\r\n", tag)
+		case "STATUS":
+			if !strings.HasPrefix(args, `"Sent"`) {
+				fmt.Fprintf(conn, "%s NO [NONEXISTENT] no such mailbox\r\n", tag)
+				continue
+			}
+			fmt.Fprintf(conn, "* STATUS \"Sent\" (MESSAGES 0)\r\n%s OK done\r\n", tag)
+		case "APPEND":
+			n, _ := strconv.Atoi(args[strings.LastIndexByte(args, '{')+1 : len(args)-1])
+			fmt.Fprint(conn, "+ ready\r\n")
+			data := make([]byte, n+2)
+			if _, err := io.ReadFull(r, data); err != nil {
+				return
+			}
+			srv.mu.Lock()
+			srv.appended = append(srv.appended, string(data[:n]))
+			reject, drop := srv.reject, srv.drop
+			srv.drop = false
+			srv.mu.Unlock()
+			if reject {
+				fmt.Fprintf(conn, "%s NO [OVERQUOTA] mailbox full\r\n", tag)
+				continue
+			}
+			fmt.Fprintf(conn, "%s OK [APPENDUID 1 1] done\r\n", tag)
+			if drop {
+				return
+			}
+		case "LOGOUT":
+			fmt.Fprintf(conn, "* BYE\r\n%s OK bye\r\n", tag)
+			return
+		default:
+			fmt.Fprintf(conn, "%s BAD unknown command\r\n", tag)
+		}
+	}
+}
+
+func TestLoadIMAPConfig(t *testing.T) {
+	t.Setenv("IMAP_HOST", "imap.example.com")
+	t.Setenv("SENDER_EMAIL", "u@example.com")
+	t.Setenv("SENDER_PASSWORD", "secret")
+	cfg, err := LoadIMAPConfig()
+	require.NoError(t, err)
+	assert.Equal(t, IMAPConfig{Host: "imap.example.com", Port: 993, User: "u@example.com", Password: "secret", Folder: "Sent", TLS: "tls"}, cfg)
+
+	t.Setenv("IMAP_TLS", "starttls")
+	t.Setenv("IMAP_USER", "archive@example.com")
+	t.Setenv("IMAP_FOLDER", "Gesendet")
+	cfg, err = LoadIMAPConfig()
+	require.NoError(t, err)
+	assert.Equal(t, 143, cfg.Port)
+	assert.Equal(t, "archive@example.com", cfg.User)
+	assert.Equal(t, "Gesendet", cfg.Folder)
+
+	t.Setenv("IMAP_TLS", "none")
+	_, err = LoadIMAPConfig()
+	assert.ErrorContains(t, err, `IMAP_TLS must be "tls" or "starttls"`)
+
+	t.Setenv("IMAP_TLS", "")
+	t.Setenv("IMAP_HOST", "")
+	t.Setenv("IMAP_USER", "")
+	t.Setenv("SENDER_EMAIL", "")
+	_, err = LoadIMAPConfig()
+	assert.ErrorContains(t, err, "missing required environment variable(s): IMAP_HOST, IMAP_USER (or SENDER_EMAIL)")
+}
+
+func TestIMAPAppenderImplicitTLS(t *testing.T) {
+	srv := startIMAPServer(t, newTestPKI(t), imapTLSImplicit)
+	a, err := NewIMAPAppender(srv.cfg, 5*time.Second)
+	require.NoError(t, err)
+	defer a.Close()
+
+	msg, err := createMessage("sender@example.com", "", Message{Address: "jane@example.com", Subject: "Hi", Body: "Hello", Cc: []string{"cc@example.com"}})
+	require.NoError(t, err)
+	require.NoError(t, a.Append(msg))
+	want, err := renderMessage(msg)
+	require.NoError(t, err)
+	require.Len(t, srv.appended, 1)
+	assert.Equal(t, string(want), srv.appended[0])
+	assert.Contains(t, srv.appended[0], "Cc: <cc@example.com>")
+}
+
+func TestIMAPAppenderStartTLSAndReconnect(t *testing.T) {
+	srv := startIMAPServer(t, newTestPKI(t), imapTLSStartTLS)
+	srv.drop = true
+	a, err := NewIMAPAppender(srv.cfg, 5*time.Second)
+	require.NoError(t, err)
+	defer a.Close()
+
+	for range 2 {
+		msg, err := createMessage("sender@example.com", "", Message{Address: "jane@example.com", Subject: "Hi", Body: "Hello"})
+		require.NoError(t, err)
+		require.NoError(t, a.Append(msg))
+	}
+	assert.Len(t, srv.appended, 2)
+	assert.Equal(t, 2, srv.logins)
+}
+
+func TestNewIMAPAppenderErrors(t *testing.T) {
+	pki := newTestPKI(t)
+	srv := startIMAPServer(t, pki, imapTLSImplicit)
+
+	cfg := srv.cfg
+	cfg.Folder = "Outbox"
+	_, err := NewIMAPAppender(cfg, 5*time.Second)
+	assert.ErrorContains(t, err, `imap: folder "Outbox": NO [NONEXISTENT] no such mailbox`)
+
+	cfg = srv.cfg
+	cfg.Password = "wrong"
+	_, err = NewIMAPAppender(cfg, 5*time.Second)
+	assert.ErrorContains(t, err, "login failed: NO bad credentials")
+
+	cfg = srv.cfg
+	cfg.CAFile = ""
+	_, err = NewIMAPAppender(cfg, 5*time.Second)
+	assert.ErrorContains(t, err, "failed to connect to IMAP server")
+}
+
+func TestSendAllSaveSentFailureKeepsDelivery(t *testing.T) {
+	srv := startIMAPServer(t, newTestPKI(t), imapTLSImplicit)
+	srv.reject = true
+	a, err := NewIMAPAppender(srv.cfg, 5*time.Second)
+	require.NoError(t, err)
+	defer a.Close()
+
+	var out bytes.Buffer
+	result := NewBatchSender(&out, &renderingSender{}, config.MailConfig{From: "sender@example.com"}, SendOptions{SaveSent: a}).SendAll([]Message{
+		{Address: "jane@example.com", Subject: "Hi", Body: "Hello"},
+	})
+	assert.Equal(t, 1, result.Sent)
+	assert.Equal(t, 0, result.Failed)
+	assert.Equal(t, 1, result.NotSaved)
+	assert.Contains(t, out.String(), `warning: failed to save to IMAP folder "Sent": NO [OVERQUOTA] mailbox full`)
+}
+
+func TestIMAPUTF7(t *testing.T) {
+	assert.Equal(t, "Sent", imapUTF7("Sent"))
+	assert.Equal(t, "Envoy&AOk-s", imapUTF7("Envoyés"))
+	assert.Equal(t, "Tom &- Jerry", imapUTF7("Tom & Jerry"))
+	assert.Equal(t, "&ZeVnLIqe-", imapUTF7("日本語"))
+}
+
+func TestLiteralSize(t *testing.T) {
+	n, ok := literalSize("* 1 FETCH (BODY[] {42}")
+	assert.True(t, ok)
+	assert.Equal(t, int64(42), n)
+	_, ok = literalSize("g1 OK done")
+	assert.False(t, ok)
+}
@@ -0,0 +1,252 @@
+// gmt sends emails in bulk based on a template and a config file.
+// Copyright (C) 2019-2025  "Muharem Hrnjadovic" <muharem@linux.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package email
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// DeliveryStatus is the outcome of delivering one message.
+type DeliveryStatus string
+
+const (
+	StatusSent   DeliveryStatus = "sent"
+	StatusFailed DeliveryStatus = "failed"
+	// StatusDeliveredDespiteError means the server accepted the message but a
+	// later step (e.g. RSET) failed; it must not be sent again.
+	StatusDeliveredDespiteError DeliveryStatus = "delivered-despite-error"
+
+	// StatusSkipped and StatusPending only appear in reports: the recipient
+	// was not attempted, because the journal says it needs no send (skipped)
+	// or because a daily rate limit ran out or a canary checkpoint was not
+	// confirmed (pending).
+	StatusSkipped DeliveryStatus = "skipped"
+	StatusPending DeliveryStatus = "pending"
+)
+
+// delivered reports whether s means the recipient has the message.
+func (s DeliveryStatus) delivered() bool {
+	return s == StatusSent || s == StatusDeliveredDespiteError
+}
+
+// JournalEntry is one line of the delivery journal.
+type JournalEntry struct {
+	Time    time.Time      `json:"time"`
+	Key     string         `json:"key"`
+	Address string         `json:"address"`
+	Status  DeliveryStatus `json:"status"`
+	Error   string         `json:"error,omitempty"`
+}
+
+// Journal is an append-only JSON-lines record of delivery outcomes, written as
+// each message is sent so an interrupted run can be resumed. Entries are keyed
+// by the run key (see RunKey) and the recipient address, so a changed config
+// or template starts from a clean slate while the old entries are kept. A
+// Journal is safe for concurrent use.
+type Journal struct {
+	mu      sync.Mutex
+	path    string
+	runKey  string
+	f       *os.File
+	latest  map[string]JournalEntry // key -> most recent entry
+	order   []string                // keys in first-seen order
+	partial int64                   // offset of an undecodable last line, or -1
+	pending bool                    // file ends in a decoded but unterminated line
+}
+
+// RunKey returns a digest of the inputs that determine what a run sends (the
+// config file, the templates, the template engine, ...). Each input is length
+// prefixed, so moving bytes from one input to the next changes the key.
+func RunKey(inputs ...[]byte) string {
+	h := sha256.New()
+	for _, in := range inputs {
+		var n [8]byte
+		binary.BigEndian.PutUint64(n[:], uint64(len(in)))
+		h.Write(n[:]) //nolint:errcheck
+		h.Write(in)   //nolint:errcheck
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// OpenJournal loads the journal at path (creating it if needed) and opens it
+// for appending entries for the run identified by runKey. A partial last line,
+// as left by a crash mid-write, is dropped.
+func OpenJournal(path, runKey string) (*Journal, error) {
+	j := &Journal{path: path, runKey: runKey, latest: make(map[string]JournalEntry), partial: -1}
+	if err := j.load(); err != nil {
+		return nil, err
+	}
+	if j.partial >= 0 {
+		if err := os.Truncate(path, j.partial); err != nil {
+			return nil, fmt.Errorf("failed to repair journal %q: %w", path, err)
+		}
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open journal %q: %w", path, err)
+	}
+	j.f = f
+	return j, nil
+}
+
+// ReadJournal returns the most recent entry per key from the journal at path,
+// in the order the keys were first recorded.
+func ReadJournal(path string) ([]JournalEntry, error) {
+	j := &Journal{path: path, latest: make(map[string]JournalEntry), partial: -1}
+	if err := j.load(); err != nil {
+		return nil, err
+	}
+	return j.Entries(), nil
+}
+
+// ResetJournal deletes the journal at path. A missing journal is not an error.
+func ResetJournal(path string) error {
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to reset journal %q: %w", path, err)
+	}
+	return nil
+}
+
+// Status returns the most recent status recorded for address in this run.
+func (j *Journal) Status(address string) (DeliveryStatus, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	e, ok := j.latest[j.key(address)]
+	return e.Status, ok
+}
+
+// Record appends an entry for address and syncs it to disk before returning,
+// so the outcome survives a crash or Ctrl-C right after the send.
+func (j *Journal) Record(address string, status DeliveryStatus, sendErr error) error {
+	e := JournalEntry{Time: time.Now().UTC(), Key: j.key(address), Address: address, Status: status}
+	if sendErr != nil {
+		e.Error = sendErr.Error()
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("failed to encode journal entry: %w", err)
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.pending {
+		// Terminate the last line, which was written without its newline.
+		line = append([]byte("\n"), line...)
+	}
+	if _, err := j.f.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write journal %q: %w", j.path, err)
+	}
+	j.pending = false
+	if err := j.f.Sync(); err != nil {
+		return fmt.Errorf("failed to sync journal %q: %w", j.path, err)
+	}
+	j.add(e)
+	return nil
+}
+
+// Entries returns the most recent entry per key, in first-seen order.
+func (j *Journal) Entries() []JournalEntry {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	entries := make([]JournalEntry, 0, len(j.order))
+	for _, k := range j.order {
+		entries = append(entries, j.latest[k])
+	}
+	return entries
+}
+
+// deliveryTimes returns when each delivered entry, across all runs, was
+// recorded, so rate limits can account for earlier runs.
+func (j *Journal) deliveryTimes() []time.Time {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	var times []time.Time
+	for _, e := range j.latest {
+		if e.Status.delivered() {
+			times = append(times, e.Time)
+		}
+	}
+	return times
+}
+
+// Close closes the journal file.
+func (j *Journal) Close() error { return j.f.Close() }
+
+// --- internal ---
+
+// key returns the journal key for address in this run.
+func (j *Journal) key(address string) string {
+	return RunKey([]byte(j.runKey), []byte(address))
+}
+
+func (j *Journal) add(e JournalEntry) {
+	if _, ok := j.latest[e.Key]; !ok {
+		j.order = append(j.order, e.Key)
+	}
+	j.latest[e.Key] = e
+}
+
+// load reads the existing journal, if any. A line that fails to decode is an
+// error unless it is an unterminated last line, whose offset is then recorded
+// in j.partial.
+func (j *Journal) load() error {
+	bs, err := os.ReadFile(j.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read journal %q: %w", j.path, err)
+	}
+
+	r := bufio.NewReader(bytes.NewReader(bs))
+	var offset int64
+	for lineNo := 1; ; lineNo++ {
+		line, err := r.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			if len(bytes.TrimSpace(line)) > 0 {
+				var e JournalEntry
+				if json.Unmarshal(line, &e) == nil {
+					j.add(e)
+					j.pending = true
+				} else {
+					j.partial = offset
+				}
+			}
+			return nil
+		}
+		offset += int64(len(line))
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var e JournalEntry
+		if err := json.Unmarshal(line, &e); err != nil {
+			return fmt.Errorf("journal %q: line %d: %w", j.path, lineNo, err)
+		}
+		j.add(e)
+	}
+}
@@ -0,0 +1,190 @@
+// gmt sends emails in bulk based on a template and a config file.
+// Copyright (C) 2019-2025  "Muharem Hrnjadovic" <muharem@linux.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package email
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/al-maisan/gmt/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func openTestJournal(t *testing.T, path, runKey string) *Journal {
+	t.Helper()
+	j, err := OpenJournal(path, runKey)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = j.Close() })
+	return j
+}
+
+func TestRunKey(t *testing.T) {
+	assert.Equal(t, RunKey([]byte("a"), []byte("b")), RunKey([]byte("a"), []byte("b")))
+	assert.NotEqual(t, RunKey([]byte("ab"), []byte("")), RunKey([]byte("a"), []byte("b")),
+		"moving bytes between inputs must change the key")
+}
+
+func TestJournalRecordAndReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.jsonl")
+
+	j := openTestJournal(t, path, "run1")
+	require.NoError(t, j.Record("a@b.com", StatusFailed, fmt.Errorf("451 try later")))
+	require.NoError(t, j.Record("a@b.com", StatusSent, nil))
+	require.NoError(t, j.Record("c@d.com", StatusFailed, fmt.Errorf("550 no such user")))
+	require.NoError(t, j.Close())
+
+	j = openTestJournal(t, path, "run1")
+	status, ok := j.Status("a@b.com")
+	assert.True(t, ok)
+	assert.Equal(t, StatusSent, status, "the latest entry wins")
+	status, ok = j.Status("c@d.com")
+	assert.True(t, ok)
+	assert.Equal(t, StatusFailed, status)
+	_, ok = j.Status("x@y.com")
+	assert.False(t, ok)
+
+	entries := j.Entries()
+	require.Len(t, entries, 2)
+	assert.Equal(t, "a@b.com", entries[0].Address)
+	assert.Equal(t, "550 no such user", entries[1].Error)
+
+	// A different run key (changed config or template) sees none of it.
+	other := openTestJournal(t, path, "run2")
+	_, ok = other.Status("a@b.com")
+	assert.False(t, ok)
+}
+
+func TestJournalPartialLastLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.jsonl")
+	j := openTestJournal(t, path, "run")
+	require.NoError(t, j.Record("a@b.com", StatusSent, nil))
+	require.NoError(t, j.Close())
+
+	// Simulate a crash in the middle of writing the next entry.
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	require.NoError(t, err)
+	_, err = f.WriteString(`{"time":"2026-01-01T00:00:00Z","key":"x`)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	j = openTestJournal(t, path, "run")
+	require.NoError(t, j.Record("c@d.com", StatusSent, nil))
+	require.NoError(t, j.Close())
+
+	entries, err := ReadJournal(path)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, "c@d.com", entries[1].Address)
+}
+
+func TestJournalCorruptLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.jsonl")
+	require.NoError(t, os.WriteFile(path, []byte("not json\n{}\n"), 0o600))
+	_, err := ReadJournal(path)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "line 1")
+}
+
+func TestReadJournalMissing(t *testing.T) {
+	entries, err := ReadJournal(filepath.Join(t.TempDir(), "none.jsonl"))
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestResetJournal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.jsonl")
+	require.NoError(t, os.WriteFile(path, []byte("{}\n"), 0o600))
+	require.NoError(t, ResetJournal(path))
+	assert.NoFileExists(t, path)
+	assert.NoError(t, ResetJournal(path), "resetting a missing journal is not an error")
+}
+
+func TestSendAllJournalResume(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.jsonl")
+	cfg := config.MailConfig{From: "sender@example.com"}
+	msgs := []Message{
+		{Name: "John", Address: "jd@example.com", Subject: "Hi", Body: "Hello"},
+		{Name: "Jane", Address: "jane@example.com", Subject: "Hi", Body: "Hello"},
+		{Name: "Bob", Address: "bob@example.com", Subject: "Hi", Body: "Hello"},
+	}
+
+	// First run: Jane fails.
+	j := openTestJournal(t, path, "run")
+	var buf bytes.Buffer
+	result := NewBatchSender(&buf, &failNthSender{failOn: 2}, cfg, SendOptions{Journal: j}).SendAll(msgs)
+	assert.Equal(t, SendResult{Sent: 2, Failed: 1}, result)
+	require.NoError(t, j.Close())
+
+	// Re-run: only Jane is sent.
+	j = openTestJournal(t, path, "run")
+	sender := &mockSender{}
+	buf.Reset()
+	result = NewBatchSender(&buf, sender, cfg, SendOptions{Journal: j}).SendAll(msgs)
+	assert.Equal(t, SendResult{Sent: 1, Skipped: 2}, result)
+	assert.Equal(t, 1, sender.sent)
+	assert.Contains(t, buf.String(), "skipping 2 recipient(s) already sent")
+	assert.Contains(t, buf.String(), "[1/1] - Jane <jane@example.com>")
+	require.NoError(t, j.Close())
+
+	// Everyone is delivered now.
+	j = openTestJournal(t, path, "run")
+	result = NewBatchSender(&buf, sender, cfg, SendOptions{Journal: j}).SendAll(msgs)
+	assert.Equal(t, SendResult{Skipped: 3}, result)
+}
+
+func TestSendAllJournalRetryFailed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.jsonl")
+	j := openTestJournal(t, path, "run")
+	require.NoError(t, j.Record("jane@example.com", StatusFailed, fmt.Errorf("451")))
+	require.NoError(t, j.Record("jd@example.com", StatusSent, nil))
+
+	cfg := config.MailConfig{From: "sender@example.com"}
+	msgs := []Message{
+		{Name: "John", Address: "jd@example.com", Subject: "Hi", Body: "Hello"},
+		{Name: "Jane", Address: "jane@example.com", Subject: "Hi", Body: "Hello"},
+		{Name: "Bob", Address: "bob@example.com", Subject: "Hi", Body: "Hello"},
+	}
+	sender := &mockSender{}
+	var buf bytes.Buffer
+	result := NewBatchSender(&buf, sender, cfg, SendOptions{Journal: j, RetryFailed: true}).SendAll(msgs)
+	assert.Equal(t, SendResult{Sent: 1, Skipped: 2}, result, "Bob was never attempted, so he is not a failure")
+	assert.Contains(t, buf.String(), "not marked as failed")
+
+	status, _ := j.Status("jane@example.com")
+	assert.Equal(t, StatusSent, status)
+}
+
+func TestSendAllJournalDeliveredDespiteError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.jsonl")
+	j := openTestJournal(t, path, "run")
+	cfg := config.MailConfig{From: "sender@example.com"}
+	msgs := []Message{{Name: "John", Address: "jd@example.com", Subject: "Hi", Body: "Hello"}}
+
+	var buf bytes.Buffer
+	result := NewBatchSender(&buf, &resetErrSender{}, cfg, SendOptions{Journal: j}).SendAll(msgs)
+	assert.Equal(t, 1, result.Sent)
+	assert.Contains(t, buf.String(), "delivered, but the server then reported")
+
+	entries := j.Entries()
+	require.Len(t, entries, 1)
+	assert.Equal(t, StatusDeliveredDespiteError, entries[0].Status)
+	assert.NotEmpty(t, entries[0].Error)
+}
@@ -0,0 +1,278 @@
+// gmt sends emails in bulk based on a template and a config file.
+// Copyright (C) 2019-2025  "Muharem Hrnjadovic" <muharem@linux.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package email
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/textproto"
+	"os"
+	"strings"
+	"time"
+
+	mail "github.com/wneessen/go-mail"
+)
+
+// lmtpError is a negative LMTP reply. It carries the reply and enhanced
+// status codes so classifyError treats it like an SMTP rejection.
+type lmtpError struct {
+	code int
+	esc  string
+	msg  string
+}
+
+func (e *lmtpError) Error() string {
+	return fmt.Sprintf("lmtp: %d %s", e.code, e.msg)
+}
+func (e *lmtpError) ErrorCode() int             { return e.code }
+func (e *lmtpError) EnhancedStatusCode() string { return e.esc }
+
+// connError is an I/O failure on a transport connection; the connection is
+// unusable and must be re-dialed before the next send.
+type connError struct{ err error }
+
+func (e *connError) Error() string { return e.err.Error() }
+func (e *connError) Unwrap() error { return e.err }
+
+// partialDeliveryError reports an LMTP transaction in which the server
+// accepted the message for some recipients but not others. It must not be
+// retried: that would deliver duplicates to the accepted recipients.
+type partialDeliveryError struct {
+	failed map[string]error // recipient -> its rejection
+}
+
+func (e *partialDeliveryError) Error() string {
+	var parts []string
+	for rcpt, err := range e.failed {
+		parts = append(parts, fmt.Sprintf("%s: %v", rcpt, err))
+	}
+	return "delivered to some recipients only; rejected " + strings.Join(parts, "; ")
+}
+
+// lmtpSender delivers over LMTP (RFC 2033) to a local delivery agent. The
+// connection is kept open across messages, like an SMTP session.
+type lmtpSender struct {
+	address string
+	timeout time.Duration
+	conn    net.Conn
+	text    *textproto.Conn
+	size    int64 // SIZE limit from the LHLO reply; 0 for none
+}
+
+// newLMTPSender connects to address: a Unix socket path if it contains a
+// slash, otherwise a TCP host:port.
+func newLMTPSender(address string, timeout time.Duration) (*lmtpSender, error) {
+	s := &lmtpSender{address: address, timeout: timeout}
+	if err := s.dial(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *lmtpSender) dial() error {
+	network := "tcp"
+	if strings.Contains(s.address, "/") {
+		network = "unix"
+	}
+	conn, err := net.DialTimeout(network, s.address, s.timeout)
+	if err != nil {
+		return fmt.Errorf("failed to connect to LMTP server %s: %w", s.address, err)
+	}
+	s.conn, s.text = conn, textproto.NewConn(conn)
+	s.setDeadline()
+
+	if _, _, err := s.reply(220); err != nil {
+		s.Close() //nolint:errcheck
+		return fmt.Errorf("LMTP server %s: %w", s.address, err)
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "localhost"
+	}
+	reply, err := s.cmd(250, "LHLO %s", hostname)
+	if err != nil {
+		s.Close() //nolint:errcheck
+		return fmt.Errorf("LMTP server %s: %w", s.address, err)
+	}
+	s.size = 0
+	for _, line := range strings.Split(reply, "\n") {
+		if keyword, param, _ := strings.Cut(line, " "); strings.EqualFold(keyword, "SIZE") {
+			s.size = parseSizeParam(param)
+		}
+	}
+	return nil
+}
+
+func (s *lmtpSender) maxSize() (int64, error) { return s.size, nil }
+
+func (s *lmtpSender) Send(msg *mail.Msg) error {
+	from, err := envelopeFrom(msg)
+	if err != nil {
+		return err
+	}
+	rcpts, err := envelopeRecipients(msg)
+	if err != nil {
+		return err
+	}
+	data, err := renderMessage(msg)
+	if err != nil {
+		return err
+	}
+	s.setDeadline()
+
+	if _, err := s.cmd(250, "MAIL FROM:<%s>", from); err != nil {
+		s.reset()
+		return err
+	}
+	var accepted []string
+	failed := make(map[string]error)
+	for _, rcpt := range rcpts {
+		if _, err := s.cmd(250, "RCPT TO:<%s>", rcpt); err != nil {
+			if !isLMTPReply(err) {
+				return err
+			}
+			failed[rcpt] = err
+			continue
+		}
+		accepted = append(accepted, rcpt)
+	}
+	if len(accepted) == 0 {
+		s.reset()
+		return failed[rcpts[0]]
+	}
+
+	if _, err := s.cmd(354, "DATA"); err != nil {
+		s.reset()
+		return err
+	}
+	w := s.text.DotWriter()
+	if _, err := w.Write(data); err != nil {
+		return s.broken(fmt.Errorf("lmtp: failed to write message: %w", err))
+	}
+	if err := w.Close(); err != nil {
+		return s.broken(fmt.Errorf("lmtp: failed to write message: %w", err))
+	}
+	// Unlike SMTP, LMTP replies once per accepted recipient after DATA.
+	for _, rcpt := range accepted {
+		if _, _, err := s.reply(250); err != nil {
+			if !isLMTPReply(err) {
+				return err
+			}
+			failed[rcpt] = err
+		}
+	}
+
+	switch {
+	case len(failed) == 0:
+		return nil
+	case len(failed) == len(rcpts):
+		return failed[rcpts[0]]
+	default:
+		return &partialDeliveryError{failed: failed}
+	}
+}
+
+// Reconnect closes the connection best-effort and re-dials.
+func (s *lmtpSender) Reconnect() error {
+	_ = s.Close()
+	return s.dial()
+}
+
+// Close ends the session with QUIT and closes the connection.
+func (s *lmtpSender) Close() error {
+	if s.text == nil {
+		return nil
+	}
+	_, _ = s.cmd(221, "QUIT")
+	err := s.text.Close()
+	s.conn, s.text = nil, nil
+	return err
+}
+
+// --- internal ---
+
+func (s *lmtpSender) setDeadline() {
+	if s.timeout > 0 {
+		_ = s.conn.SetDeadline(time.Now().Add(s.timeout))
+	}
+}
+
+// broken closes the connection after an I/O failure and returns err as a
+// *connError, so the next attempt re-dials.
+func (s *lmtpSender) broken(err error) error {
+	if s.text != nil {
+		_ = s.text.Close()
+		s.conn, s.text = nil, nil
+	}
+	return &connError{err}
+}
+
+// cmd sends a command and reads its reply, which must have code expect.
+func (s *lmtpSender) cmd(expect int, format string, args ...any) (string, error) {
+	if s.text == nil {
+		return "", &connError{fmt.Errorf("lmtp: not connected to %s", s.address)}
+	}
+	if err := s.text.PrintfLine(format, args...); err != nil {
+		return "", s.broken(fmt.Errorf("lmtp: %w", err))
+	}
+	_, msg, err := s.reply(expect)
+	return msg, err
+}
+
+// reply reads a reply, which must have code expect. A negative reply is an
+// *lmtpError.
+func (s *lmtpSender) reply(expect int) (int, string, error) {
+	code, msg, err := s.text.ReadResponse(expect)
+	var tpErr *textproto.Error
+	if errors.As(err, &tpErr) {
+		esc, text := splitEnhancedCode(tpErr.Msg)
+		return code, msg, &lmtpError{code: tpErr.Code, esc: esc, msg: strings.TrimSpace(esc + " " + text)}
+	}
+	if err != nil {
+		return code, msg, s.broken(fmt.Errorf("lmtp: %w", err))
+	}
+	return code, msg, nil
+}
+
+// reset aborts the current transaction so the connection can be reused.
+func (s *lmtpSender) reset() {
+	_, _ = s.cmd(250, "RSET")
+}
+
+// isLMTPReply reports whether err is a negative reply from the server, as
+// opposed to a connection failure.
+func isLMTPReply(err error) bool {
+	var le *lmtpError
+	return errors.As(err, &le)
+}
+
+// splitEnhancedCode splits an RFC 2034 enhanced status code such as "5.1.1"
+// off the start of a reply text.
+func splitEnhancedCode(text string) (string, string) {
+	code, rest, _ := strings.Cut(text, " ")
+	parts := strings.Split(code, ".")
+	if len(parts) != 3 || (parts[0] != "2" && parts[0] != "4" && parts[0] != "5") {
+		return "", text
+	}
+	for _, p := range parts[1:] {
+		if p == "" || strings.Trim(p, "0123456789") != "" {
+			return "", text
+		}
+	}
+	return code, rest
+}
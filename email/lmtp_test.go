@@ -0,0 +1,217 @@
+// gmt sends emails in bulk based on a template and a config file.
+// Copyright (C) 2019-2025  "Muharem Hrnjadovic" <muharem@linux.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package email
+
+import (
+	"bytes"
+	"net"
+	"net/textproto"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/al-maisan/gmt/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// lmtpServer is a minimal LMTP server on a Unix socket. Recipients in
+// rcptReject are refused at RCPT; those in dataReject after DATA. A non-empty
+// size is advertised as the SIZE extension.
+type lmtpServer struct {
+	path       string
+	rcptReject map[string]string
+	dataReject map[string]string
+
+	mu       sync.Mutex
+	messages []string
+	size     string
+}
+
+func startLMTPServer(t *testing.T) *lmtpServer {
+	t.Helper()
+	srv := &lmtpServer{path: filepath.Join(t.TempDir(), "lmtp.sock")}
+	ln, err := net.Listen("unix", srv.path)
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go srv.serve(textproto.NewConn(conn))
+		}
+	}()
+	return srv
+}
+
+func (srv *lmtpServer) serve(c *textproto.Conn) {
+	defer c.Close()
+	_ = c.PrintfLine("220 test LMTP ready")
+	var rcpts []string
+	for {
+		line, err := c.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "LHLO":
+			srv.mu.Lock()
+			size := srv.size
+			srv.mu.Unlock()
+			if size != "" {
+				_ = c.PrintfLine("250-test\r\n250 SIZE %s", size)
+			} else {
+				_ = c.PrintfLine("250 OK")
+			}
+		case "MAIL":
+			_ = c.PrintfLine("250 OK")
+		case "RCPT":
+			rcpt := strings.Trim(strings.TrimPrefix(arg, "TO:"), "<>")
+			if reply, ok := srv.rcptReject[rcpt]; ok {
+				_ = c.PrintfLine("%s", reply)
+				continue
+			}
+			rcpts = append(rcpts, rcpt)
+			_ = c.PrintfLine("250 2.1.5 OK")
+		case "DATA":
+			_ = c.PrintfLine("354 go ahead")
+			data, err := c.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			srv.mu.Lock()
+			srv.messages = append(srv.messages, string(data))
+			srv.mu.Unlock()
+			for _, rcpt := range rcpts {
+				if reply, ok := srv.dataReject[rcpt]; ok {
+					_ = c.PrintfLine("%s", reply)
+				} else {
+					_ = c.PrintfLine("250 2.0.0 <%s> delivered", rcpt)
+				}
+			}
+			rcpts = nil
+		case "RSET":
+			rcpts = nil
+			_ = c.PrintfLine("250 OK")
+		case "QUIT":
+			_ = c.PrintfLine("221 bye")
+			return
+		default:
+			_ = c.PrintfLine("500 unknown command")
+		}
+	}
+}
+
+func (srv *lmtpServer) received() []string {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	return append([]string(nil), srv.messages...)
+}
+
+func TestLMTPSenderDelivers(t *testing.T) {
+	srv := startLMTPServer(t)
+	s, err := newLMTPSender(srv.path, 0)
+	require.NoError(t, err)
+	defer s.Close()
+
+	for _, addr := range []string{"a@example.com", "b@example.com"} {
+		msg, err := createMessage("sender@example.com", "", Message{Address: addr, Subject: "Hi", Body: "Hello\n.\nbye"})
+		require.NoError(t, err)
+		require.NoError(t, s.Send(msg))
+	}
+	got := srv.received()
+	require.Len(t, got, 2)
+	assert.Contains(t, got[1], "To: <b@example.com>")
+	assert.Contains(t, got[1], "Hello\n.\nbye")
+}
+
+func TestLMTPSenderRejected(t *testing.T) {
+	srv := startLMTPServer(t)
+	srv.rcptReject = map[string]string{"gone@example.com": "550 5.1.1 no such user"}
+	s, err := newLMTPSender(srv.path, 0)
+	require.NoError(t, err)
+	defer s.Close()
+
+	msg, err := createMessage("sender@example.com", "", Message{Address: "gone@example.com", Subject: "Hi", Body: "Hello"})
+	require.NoError(t, err)
+	err = s.Send(msg)
+	require.Error(t, err)
+	assert.Equal(t, ClassPermanent, classifyError(err))
+	assert.Equal(t, "permanent 550 5.1.1", errorLabel(err))
+	assert.Empty(t, srv.received())
+
+	// The session is still usable after the rejection.
+	msg, err = createMessage("sender@example.com", "", Message{Address: "ok@example.com", Subject: "Hi", Body: "Hello"})
+	require.NoError(t, err)
+	assert.NoError(t, s.Send(msg))
+}
+
+func TestLMTPSenderPartialDelivery(t *testing.T) {
+	srv := startLMTPServer(t)
+	srv.dataReject = map[string]string{"cc@example.com": "452 4.2.2 mailbox full"}
+	s, err := newLMTPSender(srv.path, 0)
+	require.NoError(t, err)
+	defer s.Close()
+
+	msg, err := createMessage("sender@example.com", "", Message{Address: "to@example.com", Cc: []string{"cc@example.com"}, Subject: "Hi", Body: "Hello"})
+	require.NoError(t, err)
+	err = s.Send(msg)
+	var partial *partialDeliveryError
+	require.ErrorAs(t, err, &partial)
+	assert.Contains(t, err.Error(), "cc@example.com")
+	assert.True(t, deliveredDespiteError(msg, err))
+	assert.Len(t, srv.received(), 1)
+}
+
+func TestLMTPSenderReconnectsAfterDrop(t *testing.T) {
+	srv := startLMTPServer(t)
+	s, err := newLMTPSender(srv.path, 0)
+	require.NoError(t, err)
+	defer s.Close()
+
+	s.conn.Close()
+	msg, err := createMessage("sender@example.com", "", Message{Address: "a@example.com", Subject: "Hi", Body: "Hello"})
+	require.NoError(t, err)
+	err = s.Send(msg)
+	require.Error(t, err)
+	assert.True(t, isConnectionError(err))
+
+	require.NoError(t, s.Reconnect())
+	assert.NoError(t, s.Send(msg))
+}
+
+func TestSendAllOverLMTP(t *testing.T) {
+	srv := startLMTPServer(t)
+	srv.rcptReject = map[string]string{"gone@example.com": "550 5.1.1 no such user"}
+	s, err := NewSender(TransportConfig{Name: TransportLMTP, LMTPAddress: srv.path}, 0)
+	require.NoError(t, err)
+	defer s.Close()
+
+	cfg := config.MailConfig{From: "sender@example.com"}
+	var out bytes.Buffer
+	result := NewBatchSender(&out, s, cfg, SendOptions{Retries: 2}).SendAll([]Message{
+		{Address: "a@example.com", Subject: "Hi", Body: "Hello"},
+		{Address: "gone@example.com", Subject: "Hi", Body: "Hello"},
+	})
+	assert.Equal(t, 1, result.Sent)
+	assert.Equal(t, 1, result.Failed)
+	assert.Contains(t, out.String(), "not retried")
+}
@@ -0,0 +1,145 @@
+// gmt sends emails in bulk based on a template and a config file.
+// Copyright (C) 2019-2025  "Muharem Hrnjadovic" <muharem@linux.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package email
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync/atomic"
+	"time"
+
+	mail "github.com/wneessen/go-mail"
+)
+
+// maildirSeq makes Maildir file names unique within this process.
+var maildirSeq atomic.Uint64
+
+// reMboxFrom matches the lines mboxrd quoting escapes: "From " preceded by
+// any number of ">".
+var reMboxFrom = regexp.MustCompile(`(?m)^(>*From )`)
+
+// reMaildirUnsafe matches the characters with special meaning in Maildir
+// file names.
+var reMaildirUnsafe = regexp.MustCompile(`[/:]`)
+
+// maildirSender writes each message as a file into a Maildir, for review
+// or for a local mail client to pick up. Nothing is delivered. Like a Sent
+// copy, a message with blind copies gets a Bcc header naming them.
+type maildirSender struct {
+	dir string
+}
+
+// newMaildirSender returns a sender for the Maildir at dir, creating its
+// tmp, new and cur subdirectories if needed.
+func newMaildirSender(dir string) (*maildirSender, error) {
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o700); err != nil {
+			return nil, fmt.Errorf("failed to create Maildir %q: %w", dir, err)
+		}
+	}
+	return &maildirSender{dir: dir}, nil
+}
+
+// Send writes msg to tmp/ and then renames it into new/, so a reader never
+// sees a partial message.
+func (s *maildirSender) Send(msg *mail.Msg) error {
+	data, err := renderCopy(msg)
+	if err != nil {
+		return err
+	}
+	name := maildirName()
+	tmp := filepath.Join(s.dir, "tmp", name)
+	if err := os.WriteFile(tmp, toLF(data), 0o600); err != nil {
+		return fmt.Errorf("failed to write Maildir message: %w", err)
+	}
+	if err := os.Rename(tmp, filepath.Join(s.dir, "new", name)); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("failed to write Maildir message: %w", err)
+	}
+	return nil
+}
+
+func (s *maildirSender) Reconnect() error { return nil }
+func (s *maildirSender) Close() error     { return nil }
+
+// maildirName returns a unique file name in the usual
+// "time.MmicrosPpidQseq.host" form.
+func maildirName() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "localhost"
+	}
+	host = reMaildirUnsafe.ReplaceAllString(host, "_")
+	now := time.Now()
+	return fmt.Sprintf("%d.M%dP%dQ%d.%s", now.Unix(), now.Nanosecond()/1000, os.Getpid(), maildirSeq.Add(1), host)
+}
+
+// mboxSender appends each message to an mbox file in mboxrd format. Messages
+// are written with a single write to a file opened for appending, so workers
+// sharing the file do not interleave; the file is not locked against other
+// programs. Blind copies are named in a Bcc header, as for the Maildir.
+type mboxSender struct {
+	f *os.File
+}
+
+func newMboxSender(path string) (*mboxSender, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open mbox %q: %w", path, err)
+	}
+	return &mboxSender{f: f}, nil
+}
+
+func (s *mboxSender) Send(msg *mail.Msg) error {
+	from, err := envelopeFrom(msg)
+	if err != nil {
+		return err
+	}
+	data, err := renderCopy(msg)
+	if err != nil {
+		return err
+	}
+	if _, err := s.f.Write(mboxEntry(from, time.Now(), data)); err != nil {
+		return fmt.Errorf("failed to write mbox: %w", err)
+	}
+	return nil
+}
+
+func (s *mboxSender) Reconnect() error { return nil }
+func (s *mboxSender) Close() error     { return s.f.Close() }
+
+// mboxEntry formats a message as an mbox entry: a "From " separator line,
+// the message with "From " lines quoted, and a blank line.
+func mboxEntry(from string, t time.Time, data []byte) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From %s %s\n", from, t.UTC().Format(time.ANSIC))
+	body := reMboxFrom.ReplaceAll(toLF(data), []byte(">$1"))
+	b.Write(body)
+	if !bytes.HasSuffix(body, []byte("\n")) {
+		b.WriteByte('\n')
+	}
+	b.WriteByte('\n')
+	return b.Bytes()
+}
+
+// toLF converts CRLF line endings to the LF used in local mail files.
+func toLF(data []byte) []byte {
+	return bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n"))
+}
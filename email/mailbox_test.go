@@ -0,0 +1,82 @@
+// gmt sends emails in bulk based on a template and a config file.
+// Copyright (C) 2019-2025  "Muharem Hrnjadovic" <muharem@linux.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package email
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMaildirSender(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "outbox")
+	s, err := newMaildirSender(dir)
+	require.NoError(t, err)
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		assert.DirExists(t, filepath.Join(dir, sub))
+	}
+
+	for _, addr := range []string{"a@example.com", "b@example.com"} {
+		msg, err := createMessage("sender@example.com", "", Message{Address: addr, Subject: "Hi", Body: "Hello"})
+		require.NoError(t, err)
+		require.NoError(t, s.Send(msg))
+	}
+
+	files, err := os.ReadDir(filepath.Join(dir, "new"))
+	require.NoError(t, err)
+	require.Len(t, files, 2)
+	assert.NotEqual(t, files[0].Name(), files[1].Name())
+	data, err := os.ReadFile(filepath.Join(dir, "new", files[0].Name()))
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "Subject: Hi\n")
+	assert.NotContains(t, string(data), "\r\n")
+
+	tmp, err := os.ReadDir(filepath.Join(dir, "tmp"))
+	require.NoError(t, err)
+	assert.Empty(t, tmp)
+}
+
+func TestMboxSender(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.mbox")
+	s, err := newMboxSender(path)
+	require.NoError(t, err)
+	for _, addr := range []string{"a@example.com", "b@example.com"} {
+		msg, err := createMessage("sender@example.com", "", Message{Address: addr, Subject: "Hi", Body: "Hello"})
+		require.NoError(t, err)
+		require.NoError(t, s.Send(msg))
+	}
+	require.NoError(t, s.Close())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Len(t, regexp.MustCompile(`(?m)^From sender@example\.com `).FindAll(data, -1), 2)
+	assert.True(t, strings.HasSuffix(string(data), "\n\n"))
+}
+
+func TestMboxEntry(t *testing.T) {
+	when := time.Date(2025, 3, 4, 5, 6, 7, 0, time.UTC)
+	got := mboxEntry("s@example.com", when, []byte("Subject: x\r\n\r\nFrom here\r\n>From there\r\nnot From"))
+	want := "From s@example.com Tue Mar  4 05:06:07 2025\n" +
+		"Subject: x\n\n>From here\n>>From there\nnot From\n\n"
+	assert.Equal(t, want, string(got))
+}
@@ -0,0 +1,156 @@
+// gmt sends emails in bulk based on a template and a config file.
+// Copyright (C) 2019-2025  "Muharem Hrnjadovic" <muharem@linux.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package email
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/textproto"
+
+	mail "github.com/wneessen/go-mail"
+)
+
+// mimeEntity is a MIME entity built outside go-mail: the result of signing
+// or encrypting a message body. Its body is already transfer-encoded.
+type mimeEntity struct {
+	contentType string
+	encoding    string // Content-Transfer-Encoding, e.g. "7bit" or "base64"
+	body        []byte
+}
+
+// bytes returns e with its header fields, as it is signed or encrypted when
+// nested inside another entity.
+func (e mimeEntity) bytes() []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "Content-Type: %s\r\n", e.contentType)
+	if e.encoding != "" {
+		fmt.Fprintf(&b, "Content-Transfer-Encoding: %s\r\n", e.encoding)
+	}
+	b.WriteString("\r\n")
+	b.Write(e.body)
+	return b.Bytes()
+}
+
+// bodyEntity returns the body of msg, with its Content-Type and
+// Content-Transfer-Encoding, as a standalone entity. go-mail encodes text
+// parts as quoted-printable and attachments as base64, so the result is
+// 7-bit clean and survives transport unchanged, as signing requires.
+func bodyEntity(msg *mail.Msg) (mimeEntity, error) {
+	data, err := renderMessage(msg)
+	if err != nil {
+		return mimeEntity{}, err
+	}
+	end := bytes.Index(data, []byte("\r\n\r\n"))
+	if end < 0 {
+		return mimeEntity{}, fmt.Errorf("rendered message has no body")
+	}
+	header, err := textproto.NewReader(bufio.NewReader(bytes.NewReader(data[:end+4]))).ReadMIMEHeader()
+	if err != nil {
+		return mimeEntity{}, fmt.Errorf("failed to parse rendered message: %w", err)
+	}
+	return mimeEntity{
+		contentType: header.Get("Content-Type"),
+		encoding:    header.Get("Content-Transfer-Encoding"),
+		body:        data[end+4:],
+	}, nil
+}
+
+// replaceBody makes e the whole body of msg, dropping its parts, attachments
+// and embeds. go-mail then writes e's body verbatim, so every later render
+// (including the one DKIM signs) produces the same bytes. A base64 entity is
+// passed decoded and re-encoded by go-mail.
+//
+// go-mail appends a charset parameter to the Content-Type; it is ignored for
+// the multipart and application types used here.
+func replaceBody(msg *mail.Msg, e mimeEntity) error {
+	body, enc := e.body, mail.NoEncoding
+	if e.encoding == "base64" {
+		decoded, err := base64.StdEncoding.DecodeString(string(bytes.ReplaceAll(body, []byte("\r\n"), nil)))
+		if err != nil {
+			return fmt.Errorf("invalid base64 entity: %w", err)
+		}
+		body, enc = decoded, mail.EncodingB64
+	}
+	msg.UnsetAllParts()
+	msg.SetBodyWriter(mail.ContentType(e.contentType), func(w io.Writer) (int64, error) {
+		n, err := w.Write(body)
+		return int64(n), err
+	}, mail.WithPartEncoding(enc))
+	return nil
+}
+
+// multipartSigned builds a multipart/signed entity (RFC 1847) from the signed
+// entity and its detached signature.
+func multipartSigned(signed []byte, protocol, micalg string, signature mimeEntity) (mimeEntity, error) {
+	return multipartEntity(fmt.Sprintf(`multipart/signed; protocol="%s"; micalg=%s`, protocol, micalg), signed, signature.bytes())
+}
+
+// multipartEncrypted builds a multipart/encrypted entity (RFC 1847) from its
+// control part and the encrypted data.
+func multipartEncrypted(protocol string, control, encrypted mimeEntity) (mimeEntity, error) {
+	return multipartEntity(fmt.Sprintf(`multipart/encrypted; protocol="%s"`, protocol), control.bytes(), encrypted.bytes())
+}
+
+// multipartEntity joins parts, each a complete entity, into a multipart
+// entity of contentType, to which the boundary parameter is added.
+func multipartEntity(contentType string, parts ...[]byte) (mimeEntity, error) {
+	boundary, err := newBoundary()
+	if err != nil {
+		return mimeEntity{}, err
+	}
+	var b bytes.Buffer
+	for i, part := range parts {
+		if i > 0 {
+			b.WriteString("\r\n")
+		}
+		fmt.Fprintf(&b, "--%s\r\n", boundary)
+		b.Write(part)
+	}
+	fmt.Fprintf(&b, "\r\n--%s--\r\n", boundary)
+	return mimeEntity{
+		contentType: fmt.Sprintf(`%s; boundary="%s"`, contentType, boundary),
+		encoding:    "7bit",
+		body:        b.Bytes(),
+	}, nil
+}
+
+// base64Body encodes data as base64 in 76-character lines.
+func base64Body(data []byte) []byte {
+	enc := base64.StdEncoding.EncodeToString(data)
+	var b bytes.Buffer
+	for len(enc) > 76 {
+		b.WriteString(enc[:76])
+		b.WriteString("\r\n")
+		enc = enc[76:]
+	}
+	b.WriteString(enc)
+	b.WriteString("\r\n")
+	return b.Bytes()
+}
+
+func newBoundary() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate MIME boundary: %w", err)
+	}
+	return "gmt-" + hex.EncodeToString(buf), nil
+}
@@ -0,0 +1,420 @@
+// gmt sends emails in bulk based on a template and a config file.
+// Copyright (C) 2019-2025  "Muharem Hrnjadovic" <muharem@linux.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package email
+
+import (
+	"bytes"
+	"crypto"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/al-maisan/gmt/config"
+	mail "github.com/wneessen/go-mail"
+)
+
+// pgpConfig is used for all signing and encryption: SHA-256 signatures and
+// AES-256, unless a recipient's key preferences rule them out.
+var pgpConfig = &packet.Config{DefaultHash: crypto.SHA256, DefaultCipher: packet.CipherAES256}
+
+// pgpMicalg maps signature hashes to their RFC 3156 micalg names.
+var pgpMicalg = map[crypto.Hash]string{
+	crypto.SHA224: "pgp-sha224", crypto.SHA256: "pgp-sha256", crypto.SHA384: "pgp-sha384",
+	crypto.SHA512: "pgp-sha512", crypto.SHA3_256: "pgp-sha3-256", crypto.SHA3_512: "pgp-sha3-512",
+}
+
+// PGP signs and encrypts messages as OpenPGP/MIME (RFC 3156). It is safe for
+// concurrent use.
+type PGP struct {
+	signer  *openpgp.Entity    // nil if not signing
+	keyring openpgp.EntityList // searched by address for recipients without a pgp_key
+	encrypt bool
+	missing string // config.PGPMissingKey*
+}
+
+// NewPGP loads the signing key and keyring named in cfg. A passphrase-protected
+// secret key is unlocked with PGP_PASSPHRASE. The signing key must be
+// currently valid and, if its user IDs carry email addresses, one of them
+// must be the address of from. recipients decides whether messages are
+// encrypted (see config.PGPConfig.Encrypts).
+func NewPGP(cfg config.PGPConfig, from string, recipients []config.Recipient) (*PGP, error) {
+	p := &PGP{encrypt: cfg.Encrypts(recipients), missing: cfg.MissingKey}
+	if cfg.SecretKey != "" {
+		signer, err := loadPGPSigner(cfg.SecretKey, from, time.Now())
+		if err != nil {
+			return nil, fmt.Errorf("pgp: %w", err)
+		}
+		p.signer = signer
+	}
+	if cfg.Keyring != "" {
+		keyring, err := readPGPKeys(cfg.Keyring)
+		if err != nil {
+			return nil, fmt.Errorf("pgp: %w", err)
+		}
+		p.keyring = keyring
+	}
+	return p, nil
+}
+
+// CheckFrom returns an error if messages are signed and the signing key is
+// not for from.
+func (p *PGP) CheckFrom(from string) error {
+	if p.signer == nil {
+		return nil
+	}
+	if err := checkPGPSignerAddress(p.signer, from, time.Now()); err != nil {
+		return fmt.Errorf("pgp: signing key %s %w", describePGPKey(p.signer), err)
+	}
+	return nil
+}
+
+// Signer describes the signing key as "fingerprint (user ID)", or returns ""
+// if messages are not signed.
+func (p *PGP) Signer() string {
+	if p.signer == nil {
+		return ""
+	}
+	return describePGPKey(p.signer)
+}
+
+// RecipientKey describes the keys m's message will be encrypted to, besides
+// the signing key, or returns "" if it will not be encrypted.
+func (p *PGP) RecipientKey(m Message) (string, error) {
+	keys, _, err := p.keysFor(m, time.Now())
+	if err != nil || keys == nil {
+		return "", err
+	}
+	described := make([]string, len(keys))
+	for i, key := range keys {
+		described[i] = describePGPKey(key)
+	}
+	return strings.Join(described, ", "), nil
+}
+
+// CheckKeys verifies that every pgp_key exists, parses and holds a currently
+// valid encryption key and, with missing_key = "fail", that every recipient,
+// Cc and Bcc address has a key, so problems are reported up front (e.g.
+// during -validate) instead of mid-send.
+func (p *PGP) CheckKeys(msgs []Message) error {
+	now := time.Now()
+	var missing []string
+	for _, m := range msgs {
+		_, without, err := p.keysFor(m, now)
+		if err != nil {
+			return fmt.Errorf("pgp_key for recipient %s: %w", m.Address, err)
+		}
+		if p.missing == config.PGPMissingKeyFail {
+			missing = append(missing, without...)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("no OpenPGP key for %d recipient(s) (missing_key = %q): %s", len(missing), p.missing, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// --- internal ---
+
+// withoutKeys splits off the messages to skip because missing_key is "skip"
+// and their recipient, or one of their Cc or Bcc addresses, has no key.
+func (p *PGP) withoutKeys(msgs []Message) (todo, skipped []Message) {
+	if !p.encrypt || p.missing != config.PGPMissingKeySkip {
+		return msgs, nil
+	}
+	now := time.Now()
+	for _, m := range msgs {
+		if _, missing, err := p.keysFor(m, now); err == nil && len(missing) > 0 {
+			skipped = append(skipped, m)
+			continue
+		}
+		todo = append(todo, m)
+	}
+	return todo, skipped
+}
+
+// keyFor returns the key to encrypt m's message to: its pgp_key, or the
+// keyring's valid key for its address. It returns nil if m is not to be
+// encrypted or has no key.
+func (p *PGP) keyFor(m Message, now time.Time) (*openpgp.Entity, error) {
+	if !p.encrypt {
+		return nil, nil
+	}
+	if m.PGPKey != "" {
+		keys, err := readPGPKeys(m.PGPKey)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := keys[0].EncryptionKey(now); !ok {
+			return nil, fmt.Errorf("key %s in %q %w", describePGPKey(keys[0]), m.PGPKey, pgpKeyProblem(keys[0], now, "encryption"))
+		}
+		return keys[0], nil
+	}
+	return p.keyringKey(m.Address, now), nil
+}
+
+// keysFor returns the keys to encrypt m's message to: the recipient's (see
+// keyFor) and the keyring's for each of its Cc and Bcc addresses, all of whom
+// must be able to read it. If any of them has no key, keys is nil and missing
+// lists them. Both are nil if m is not to be encrypted.
+func (p *PGP) keysFor(m Message, now time.Time) (keys []*openpgp.Entity, missing []string, err error) {
+	if !p.encrypt {
+		return nil, nil, nil
+	}
+	key, err := p.keyFor(m, now)
+	if err != nil {
+		return nil, nil, err
+	}
+	if key != nil {
+		keys = append(keys, key)
+	} else {
+		missing = append(missing, m.Address)
+	}
+	for _, addrs := range [][]string{m.Cc, m.Bcc} {
+		for _, addr := range addrs {
+			if key := p.keyringKey(addr, now); key != nil {
+				keys = append(keys, key)
+			} else {
+				missing = append(missing, fmt.Sprintf("%s (copy to %s)", addr, m.Address))
+			}
+		}
+	}
+	if len(missing) > 0 {
+		return nil, missing, nil
+	}
+	return keys, nil, nil
+}
+
+// keyringKey returns the keyring's first valid encryption key for address, or
+// nil if it has none.
+func (p *PGP) keyringKey(address string, now time.Time) *openpgp.Entity {
+	for _, e := range p.keyring {
+		if _, ok := e.EncryptionKey(now); ok && pgpKeyHasAddress(e, address, now) {
+			return e
+		}
+	}
+	return nil
+}
+
+// protect replaces the body of msg with an OpenPGP/MIME entity: encrypted
+// (and signed inside, if signing) if m's recipient and copies all have keys,
+// otherwise signed with a detached signature if signing. A message that is
+// not encrypted for lack of a key is an error with missing_key = "fail";
+// otherwise it is sent unencrypted.
+func (p *PGP) protect(msg *mail.Msg, m Message) error {
+	keys, missing, err := p.keysFor(m, time.Now())
+	if err != nil {
+		return fmt.Errorf("pgp: %w", err)
+	}
+	if len(missing) > 0 && p.missing == config.PGPMissingKeyFail {
+		return fmt.Errorf("pgp: no key for %s", strings.Join(missing, ", "))
+	}
+	if keys == nil && p.signer == nil {
+		return nil
+	}
+	e, err := bodyEntity(msg)
+	if err != nil {
+		return err
+	}
+	if keys != nil {
+		e, err = p.encryptEntity(e, keys)
+	} else {
+		e, err = p.signEntity(e)
+	}
+	if err != nil {
+		return err
+	}
+	return replaceBody(msg, e)
+}
+
+// signEntity wraps e in a multipart/signed entity with a detached signature.
+func (p *PGP) signEntity(e mimeEntity) (mimeEntity, error) {
+	content := e.bytes()
+	var sig bytes.Buffer
+	if err := openpgp.DetachSign(&sig, p.signer, bytes.NewReader(content), pgpConfig); err != nil {
+		return mimeEntity{}, fmt.Errorf("pgp: failed to sign: %w", err)
+	}
+	pkt, err := packet.Read(bytes.NewReader(sig.Bytes()))
+	if err != nil {
+		return mimeEntity{}, fmt.Errorf("pgp: failed to sign: %w", err)
+	}
+	s, ok := pkt.(*packet.Signature)
+	if !ok || pgpMicalg[s.Hash] == "" {
+		return mimeEntity{}, fmt.Errorf("pgp: failed to sign: unsupported signature")
+	}
+	armored, err := armorPGP("PGP SIGNATURE", sig.Bytes())
+	if err != nil {
+		return mimeEntity{}, err
+	}
+	return multipartSigned(content, "application/pgp-signature", pgpMicalg[s.Hash], mimeEntity{
+		contentType: `application/pgp-signature; name="signature.asc"`,
+		body:        armored,
+	})
+}
+
+// encryptEntity encrypts e to keys, signing it first if signing, and wraps
+// the result in a multipart/encrypted entity. It is also encrypted to the
+// signing key if that has an encryption subkey, so the sender can read the
+// copies kept with -save-sent or -archive.
+func (p *PGP) encryptEntity(e mimeEntity, keys []*openpgp.Entity) (mimeEntity, error) {
+	if p.signer != nil {
+		if _, ok := p.signer.EncryptionKey(time.Now()); ok {
+			keys = append(keys, p.signer)
+		}
+	}
+	var ciphertext bytes.Buffer
+	w, err := openpgp.Encrypt(&ciphertext, keys, p.signer, &openpgp.FileHints{IsBinary: true}, pgpConfig)
+	if err != nil {
+		return mimeEntity{}, fmt.Errorf("pgp: failed to encrypt: %w", err)
+	}
+	if _, err := w.Write(e.bytes()); err != nil {
+		return mimeEntity{}, fmt.Errorf("pgp: failed to encrypt: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return mimeEntity{}, fmt.Errorf("pgp: failed to encrypt: %w", err)
+	}
+	armored, err := armorPGP("PGP MESSAGE", ciphertext.Bytes())
+	if err != nil {
+		return mimeEntity{}, err
+	}
+	return multipartEncrypted("application/pgp-encrypted",
+		mimeEntity{contentType: "application/pgp-encrypted", body: []byte("Version: 1\r\n")},
+		mimeEntity{contentType: `application/octet-stream; name="encrypted.asc"`, body: armored})
+}
+
+// loadPGPSigner reads the secret key in path, unlocking it with
+// PGP_PASSPHRASE if needed, and checks it can sign as from at now.
+func loadPGPSigner(path, from string, now time.Time) (*openpgp.Entity, error) {
+	keys, err := readPGPKeys(path)
+	if err != nil {
+		return nil, err
+	}
+	e := keys[0]
+	if e.PrivateKey == nil {
+		return nil, fmt.Errorf("%q holds no secret key", path)
+	}
+	if e.PrivateKey.Encrypted {
+		passphrase := os.Getenv("PGP_PASSPHRASE")
+		if passphrase == "" {
+			return nil, fmt.Errorf("secret key %q is passphrase-protected: set PGP_PASSPHRASE", path)
+		}
+		if err := e.DecryptPrivateKeys([]byte(passphrase)); err != nil {
+			return nil, fmt.Errorf("failed to unlock secret key %q: %w", path, err)
+		}
+	}
+	if _, ok := e.SigningKey(now); !ok {
+		return nil, fmt.Errorf("key %s in %q %w", describePGPKey(e), path, pgpKeyProblem(e, now, "signing"))
+	}
+	if err := checkPGPSignerAddress(e, from, now); err != nil {
+		return nil, fmt.Errorf("key %s in %q %w", describePGPKey(e), path, err)
+	}
+	return e, nil
+}
+
+// checkPGPSignerAddress returns an error if the user IDs of signing key e
+// carry email addresses and from is not one of them.
+func checkPGPSignerAddress(e *openpgp.Entity, from string, now time.Time) error {
+	addr := plainAddress(from)
+	if emails := pgpKeyEmails(e, now); len(emails) > 0 && !containsFold(emails, addr) {
+		return fmt.Errorf("is for %s, not %s", strings.Join(emails, ", "), addr)
+	}
+	return nil
+}
+
+// readPGPKeys reads the keys in path, ASCII-armored or binary.
+func readPGPKeys(path string) (openpgp.EntityList, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+	var keys openpgp.EntityList
+	if bytes.Contains(data, []byte("-----BEGIN PGP")) {
+		keys, err = openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+	} else {
+		keys, err = openpgp.ReadKeyRing(bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid key file %q: %w", path, err)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no OpenPGP key in %q", path)
+	}
+	return keys, nil
+}
+
+// pgpKeyProblem explains why e has no usable key for use ("signing" or
+// "encryption") at now.
+func pgpKeyProblem(e *openpgp.Entity, now time.Time, use string) error {
+	sig, _ := e.PrimarySelfSignature()
+	switch {
+	case sig == nil:
+		return fmt.Errorf("has no valid self-signature")
+	case e.Revoked(now):
+		return fmt.Errorf("is revoked")
+	case e.PrimaryKey.CreationTime.After(now):
+		// e.g. generated on a machine whose clock is ahead
+		return fmt.Errorf("is not valid before %s", e.PrimaryKey.CreationTime.Format(time.RFC3339))
+	case e.PrimaryKey.KeyExpired(sig, now) && sig.KeyLifetimeSecs != nil:
+		expiry := e.PrimaryKey.CreationTime.Add(time.Duration(*sig.KeyLifetimeSecs) * time.Second)
+		return fmt.Errorf("expired on %s", expiry.Format(time.DateOnly))
+	default:
+		return fmt.Errorf("has no valid %s key", use)
+	}
+}
+
+// pgpKeyEmails returns the addresses in e's unrevoked user IDs.
+func pgpKeyEmails(e *openpgp.Entity, now time.Time) []string {
+	var emails []string
+	for _, id := range e.Identities {
+		if id.UserId != nil && id.UserId.Email != "" && !id.Revoked(now) {
+			emails = append(emails, id.UserId.Email)
+		}
+	}
+	return emails
+}
+
+func pgpKeyHasAddress(e *openpgp.Entity, address string, now time.Time) bool {
+	return containsFold(pgpKeyEmails(e, now), address)
+}
+
+func describePGPKey(e *openpgp.Entity) string {
+	fpr := fmt.Sprintf("%X", e.PrimaryKey.Fingerprint)
+	if id := e.PrimaryIdentity(); id != nil {
+		return fmt.Sprintf("%s (%s)", fpr, id.Name)
+	}
+	return fpr
+}
+
+// armorPGP ASCII-armors data as blockType, with CRLF line endings.
+func armorPGP(blockType string, data []byte) ([]byte, error) {
+	var b bytes.Buffer
+	w, err := armor.Encode(&b, blockType, nil)
+	if err != nil {
+		return nil, fmt.Errorf("pgp: %w", err)
+	}
+	if _, err := io.Copy(w, bytes.NewReader(data)); err != nil {
+		return nil, fmt.Errorf("pgp: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("pgp: %w", err)
+	}
+	return append(bytes.ReplaceAll(b.Bytes(), []byte("\n"), []byte("\r\n")), '\r', '\n'), nil
+}
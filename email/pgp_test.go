@@ -0,0 +1,303 @@
+// gmt sends emails in bulk based on a template and a config file.
+// Copyright (C) 2019-2025  "Muharem Hrnjadovic" <muharem@linux.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package email
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/al-maisan/gmt/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newPGPKey generates an Ed25519/X25519 key for email. A non-zero created
+// backdates it, and lifetime limits how long it is valid.
+func newPGPKey(t *testing.T, email string, created time.Time, lifetime time.Duration) *openpgp.Entity {
+	t.Helper()
+	cfg := &packet.Config{Algorithm: packet.PubKeyAlgoEdDSA, KeyLifetimeSecs: uint32(lifetime.Seconds())}
+	if !created.IsZero() {
+		cfg.Time = func() time.Time { return created }
+	}
+	e, err := openpgp.NewEntity("Test", "", email, cfg)
+	require.NoError(t, err)
+	return e
+}
+
+// writePGPKey writes the public half of e, or the secret key if private,
+// optionally locked with passphrase, and returns the path.
+func writePGPKey(t *testing.T, e *openpgp.Entity, private bool, passphrase string) string {
+	t.Helper()
+	var b bytes.Buffer
+	blockType := openpgp.PublicKeyType
+	if private {
+		blockType = openpgp.PrivateKeyType
+	}
+	w, err := armor.Encode(&b, blockType, nil)
+	require.NoError(t, err)
+	switch {
+	case !private:
+		require.NoError(t, e.Serialize(w))
+	case passphrase != "":
+		require.NoError(t, e.EncryptPrivateKeys([]byte(passphrase), nil))
+		require.NoError(t, e.SerializePrivateWithoutSigning(w, nil))
+		require.NoError(t, e.DecryptPrivateKeys([]byte(passphrase)))
+	default:
+		require.NoError(t, e.SerializePrivate(w, nil))
+	}
+	require.NoError(t, w.Close())
+	path := filepath.Join(t.TempDir(), "key.asc")
+	require.NoError(t, os.WriteFile(path, b.Bytes(), 0o600))
+	return path
+}
+
+// splitMultipart returns the parts of a two-part multipart body as raw bytes.
+func splitMultipart(t *testing.T, body []byte, boundary string) (string, string) {
+	t.Helper()
+	delim := "--" + boundary
+	parts := strings.Split(string(body), "\r\n"+delim)
+	require.Len(t, parts, 3)
+	require.True(t, strings.HasPrefix(parts[0], delim+"\r\n"))
+	return strings.TrimPrefix(parts[0], delim+"\r\n"), strings.TrimPrefix(parts[1], "\r\n")
+}
+
+func TestPGPSign(t *testing.T) {
+	signer := newPGPKey(t, "sender@example.com", time.Time{}, 0)
+	p, err := NewPGP(config.PGPConfig{SecretKey: writePGPKey(t, signer, true, ""), MissingKey: config.PGPMissingKeyFail}, `"Sender" <sender@example.com>`, nil)
+	require.NoError(t, err)
+	assert.Contains(t, p.Signer(), "sender@example.com")
+
+	msg, err := createMessage("sender@example.com", "", Message{Address: "jane@example.com", Subject: "Hi", Body: "Hello"})
+	require.NoError(t, err)
+	require.NoError(t, p.protect(msg, Message{Address: "jane@example.com"}))
+
+	data, err := renderMessage(msg)
+	require.NoError(t, err)
+	mediaType, params, body := readEntity(t, data)
+	require.Equal(t, "multipart/signed", mediaType)
+	assert.Equal(t, "application/pgp-signature", params["protocol"])
+	assert.Equal(t, "pgp-sha256", params["micalg"])
+
+	signed, sigPart := splitMultipart(t, body, params["boundary"])
+	sigType, _, sig := readEntity(t, []byte(sigPart))
+	require.Equal(t, "application/pgp-signature", sigType)
+	_, err = openpgp.CheckArmoredDetachedSignature(openpgp.EntityList{signer}, strings.NewReader(signed), bytes.NewReader(sig), nil)
+	require.NoError(t, err)
+	assert.Contains(t, signed, "Hello")
+}
+
+func TestPGPSignAndEncrypt(t *testing.T) {
+	signer := newPGPKey(t, "sender@example.com", time.Time{}, 0)
+	rcpt := newPGPKey(t, "jane@example.com", time.Time{}, 0)
+	cfg := config.PGPConfig{
+		SecretKey: writePGPKey(t, signer, true, "s3cret"), Keyring: writePGPKey(t, rcpt, false, ""), MissingKey: config.PGPMissingKeyFail,
+	}
+	_, err := NewPGP(cfg, "sender@example.com", nil)
+	require.ErrorContains(t, err, "set PGP_PASSPHRASE")
+	t.Setenv("PGP_PASSPHRASE", "s3cret")
+	p, err := NewPGP(cfg, "sender@example.com", nil)
+	require.NoError(t, err)
+
+	m := Message{Address: "jane@example.com", Subject: "Secret", Body: "Hello"}
+	msg, err := createMessage("sender@example.com", "", m)
+	require.NoError(t, err)
+	require.NoError(t, p.protect(msg, m))
+
+	data, err := renderMessage(msg)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "Hello")
+	mediaType, params, body := readEntity(t, data)
+	require.Equal(t, "multipart/encrypted", mediaType)
+	assert.Equal(t, "application/pgp-encrypted", params["protocol"])
+
+	control, encrypted := splitMultipart(t, body, params["boundary"])
+	_, _, version := readEntity(t, []byte(control))
+	assert.Equal(t, "Version: 1\r\n", string(version))
+	_, _, armored := readEntity(t, []byte(encrypted))
+	block, err := armor.Decode(bytes.NewReader(armored))
+	require.NoError(t, err)
+	md, err := openpgp.ReadMessage(block.Body, openpgp.EntityList{rcpt, signer}, nil, nil)
+	require.NoError(t, err)
+	inner, err := io.ReadAll(md.UnverifiedBody)
+	require.NoError(t, err)
+	require.NoError(t, md.SignatureError)
+	require.NotNil(t, md.SignedBy)
+	assert.Equal(t, signer.PrimaryKey.KeyId, md.SignedBy.PublicKey.KeyId)
+	innerType, _, text := readEntity(t, inner)
+	assert.Equal(t, "text/plain", innerType)
+	assert.Contains(t, string(text), "Hello")
+}
+
+// decryptPGP decrypts the multipart/encrypted message data with keys and
+// returns the plaintext.
+func decryptPGP(t *testing.T, data []byte, keys openpgp.EntityList) ([]byte, error) {
+	t.Helper()
+	mediaType, params, body := readEntity(t, data)
+	require.Equal(t, "multipart/encrypted", mediaType)
+	_, encrypted := splitMultipart(t, body, params["boundary"])
+	_, _, armored := readEntity(t, []byte(encrypted))
+	block, err := armor.Decode(bytes.NewReader(armored))
+	require.NoError(t, err)
+	md, err := openpgp.ReadMessage(block.Body, keys, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(md.UnverifiedBody)
+}
+
+func TestPGPEncryptToCopiesAndSigner(t *testing.T) {
+	signer := newPGPKey(t, "sender@example.com", time.Time{}, 0)
+	rcpt := newPGPKey(t, "jane@example.com", time.Time{}, 0)
+	boss := newPGPKey(t, "boss@example.com", time.Time{}, 0)
+	other := newPGPKey(t, "other@example.com", time.Time{}, 0)
+	var keyring bytes.Buffer
+	require.NoError(t, rcpt.Serialize(&keyring))
+	require.NoError(t, boss.Serialize(&keyring))
+	keyringPath := filepath.Join(t.TempDir(), "keyring.gpg")
+	require.NoError(t, os.WriteFile(keyringPath, keyring.Bytes(), 0o600))
+	p, err := NewPGP(config.PGPConfig{SecretKey: writePGPKey(t, signer, true, ""), Keyring: keyringPath, MissingKey: config.PGPMissingKeyFail}, "sender@example.com", nil)
+	require.NoError(t, err)
+
+	m := Message{Address: "jane@example.com", Subject: "Secret", Body: "Hello", Cc: []string{"boss@example.com"}}
+	require.NoError(t, p.CheckKeys([]Message{m}))
+	described, err := p.RecipientKey(m)
+	require.NoError(t, err)
+	assert.Contains(t, described, "jane@example.com")
+	assert.Contains(t, described, "boss@example.com")
+
+	msg, err := createMessage("sender@example.com", "", m)
+	require.NoError(t, err)
+	require.NoError(t, p.protect(msg, m))
+	data, err := renderMessage(msg)
+	require.NoError(t, err)
+	for _, key := range []*openpgp.Entity{rcpt, boss, signer} {
+		inner, err := decryptPGP(t, data, openpgp.EntityList{key})
+		require.NoError(t, err)
+		assert.Contains(t, string(inner), "Hello")
+	}
+	_, err = decryptPGP(t, data, openpgp.EntityList{other})
+	assert.Error(t, err)
+
+	m.Bcc = []string{"audit@example.com"}
+	err = p.CheckKeys([]Message{m})
+	assert.ErrorContains(t, err, "no OpenPGP key for 1 recipient(s) (missing_key = \"fail\"): audit@example.com (copy to jane@example.com)")
+	msg, err = createMessage("sender@example.com", "", m)
+	require.NoError(t, err)
+	assert.ErrorContains(t, p.protect(msg, m), "pgp: no key for audit@example.com (copy to jane@example.com)")
+
+	p.missing = config.PGPMissingKeySkip
+	todo, skipped := p.withoutKeys([]Message{m})
+	assert.Empty(t, todo)
+	assert.Len(t, skipped, 1)
+}
+
+func TestPGPMissingKeyPolicy(t *testing.T) {
+	rcpt := newPGPKey(t, "jane@example.com", time.Time{}, 0)
+	keyring := writePGPKey(t, rcpt, false, "")
+	msgs := []Message{
+		{Address: "jane@example.com", Subject: "Hi", Body: "Hello Jane"},
+		{Address: "john@example.com", Subject: "Hi", Body: "Hello John"},
+	}
+
+	p, err := NewPGP(config.PGPConfig{Keyring: keyring, MissingKey: config.PGPMissingKeyFail}, "sender@example.com", nil)
+	require.NoError(t, err)
+	err = p.CheckKeys(msgs)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "no OpenPGP key for 1 recipient(s)")
+	assert.Contains(t, err.Error(), "john@example.com")
+
+	p, err = NewPGP(config.PGPConfig{Keyring: keyring, MissingKey: config.PGPMissingKeyPlaintext}, "sender@example.com", nil)
+	require.NoError(t, err)
+	require.NoError(t, p.CheckKeys(msgs))
+	sender := &renderingSender{}
+	result := NewBatchSender(io.Discard, sender, config.MailConfig{From: "sender@example.com"}, SendOptions{PGP: p}).SendAll(msgs)
+	require.Equal(t, 2, result.Sent)
+	assert.Contains(t, string(sender.sent[0]), "multipart/encrypted")
+	assert.Contains(t, string(sender.sent[1]), "Hello John")
+
+	p, err = NewPGP(config.PGPConfig{Keyring: keyring, MissingKey: config.PGPMissingKeySkip}, "sender@example.com", nil)
+	require.NoError(t, err)
+	sender = &renderingSender{}
+	var out bytes.Buffer
+	result = NewBatchSender(&out, sender, config.MailConfig{From: "sender@example.com"}, SendOptions{PGP: p}).SendAll(msgs)
+	assert.Equal(t, 1, result.Sent)
+	assert.Equal(t, 1, result.NoKey)
+	require.Len(t, sender.sent, 1)
+	assert.Contains(t, string(sender.sent[0]), "multipart/encrypted")
+	assert.Contains(t, out.String(), "OpenPGP: skipping 1 recipient(s) without a key")
+}
+
+func TestPGPRecipientKeyFile(t *testing.T) {
+	rcpt := newPGPKey(t, "jane@example.com", time.Time{}, 0)
+	expired := newPGPKey(t, "jane@example.com", time.Now().Add(-48*time.Hour), 24*time.Hour)
+	recipients := []config.Recipient{{Email: "jane@example.com", PGPKey: "key.asc"}}
+	p, err := NewPGP(config.PGPConfig{MissingKey: config.PGPMissingKeyFail}, "sender@example.com", recipients)
+	require.NoError(t, err)
+
+	m := Message{Address: "jane@example.com", PGPKey: writePGPKey(t, rcpt, false, "")}
+	require.NoError(t, p.CheckKeys([]Message{m}))
+	key, err := p.RecipientKey(m)
+	require.NoError(t, err)
+	assert.Contains(t, key, "jane@example.com")
+
+	err = p.CheckKeys([]Message{{Address: "jane@example.com", PGPKey: writePGPKey(t, expired, false, "")}})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "expired on")
+
+	future := newPGPKey(t, "jane@example.com", time.Now().Add(2*time.Hour), 0)
+	err = p.CheckKeys([]Message{{Address: "jane@example.com", PGPKey: writePGPKey(t, future, false, "")}})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "is not valid before")
+
+	err = p.CheckKeys([]Message{{Address: "jane@example.com", PGPKey: "/nonexistent.asc"}})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "pgp_key for recipient jane@example.com: failed to read key file")
+}
+
+func TestNewPGPSignerErrors(t *testing.T) {
+	signer := newPGPKey(t, "sender@example.com", time.Time{}, 0)
+	expired := newPGPKey(t, "sender@example.com", time.Now().Add(-48*time.Hour), 24*time.Hour)
+	future := newPGPKey(t, "sender@example.com", time.Now().Add(2*time.Hour), 0)
+
+	tests := []struct {
+		name    string
+		path    string
+		from    string
+		wantErr string
+	}{
+		{"public key only", writePGPKey(t, signer, false, ""), "sender@example.com", "holds no secret key"},
+		{"expired", writePGPKey(t, expired, true, ""), "sender@example.com", "expired on"},
+		{"created in the future", writePGPKey(t, future, true, ""), "sender@example.com", "is not valid before " + future.PrimaryKey.CreationTime.Format(time.RFC3339)},
+		{"other address", writePGPKey(t, signer, true, ""), "news@example.com", "is for sender@example.com, not news@example.com"},
+		{"missing", "/nonexistent.asc", "sender@example.com", "failed to read key file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewPGP(config.PGPConfig{SecretKey: tt.path, MissingKey: config.PGPMissingKeyFail}, tt.from, nil)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+		})
+	}
+}
@@ -19,7 +19,11 @@
 package email
 
 import (
+	"cmp"
+	"errors"
 	"fmt"
+	"maps"
+	netmail "net/mail"
 	"regexp"
 	"slices"
 	"strings"
@@ -41,22 +45,67 @@ const (
 type Message struct {
 	Name        string
 	Address     string
-	Body        string
+	From        string // replaces the [general] from; empty for none
+	ReplyTo     string // replaces the [general] reply_to; empty for none
+	Body        string // text/plain body
+	HTMLBody    string // optional text/html alternative
 	Subject     string
 	Cc          []string
+	Bcc         []string // blind copies: added to the envelope, never to the headers
 	Attachments []string
+	SMIMECert   string            // certificate to S/MIME-encrypt to; empty for none
+	PGPKey      string            // OpenPGP public key file to encrypt to; empty to use the keyring
+	Unsubscribe []string          // List-Unsubscribe URIs (mailto: and https:); empty for none
+	Headers     map[string]string // extra header fields, by name
+}
+
+// SuppressedAddress is a recipient, Cc or Bcc address that matched the
+// suppression list.
+type SuppressedAddress struct {
+	Recipient string             // the recipient whose message it concerns
+	Address   string             // the suppressed address: Recipient, or one of its Cc or Bcc
+	Copy      string             // "Cc" or "Bcc" if Address is a copy; empty for Recipient
+	Entry     config.Suppression // the list entry it matched
+}
+
+// Templates holds the raw body templates for a run. At least one must be set;
+// with only HTML, the text part is generated from the rendered HTML.
+//
+// Text or HTML may begin with front matter (see SplitFrontMatter and
+// TemplateFrontMatter), which PrepMails splits off. Front matter takes
+// precedence over [general]: its subject, attachments and headers each replace
+// the [general] setting as a whole, and per-recipient settings then apply on
+// top as usual. Its format says what the body is: with FormatHTML it is the HTML
+// template, and with FormatMarkdown it is the text template whose rendered
+// output is also converted to the HTML part. Either conflicts with HTML.
+type Templates struct {
+	Text string
+	HTML string
 }
 
 // substituteVariables replaces placeholder tokens (%FN%, %LN%, %EA%, and
-// any custom keys from recipient.Data) in text with their values.
+// any custom keys from recipient.Data) in text with their values. List-valued
+// data (recipient.Lists) is substituted as a comma-separated list.
 func substituteVariables(recipient config.Recipient, text string) string {
+	return substituteEscaped(recipient, text, nil)
+}
+
+// substituteEscaped is substituteVariables with every value passed through
+// escape (if non-nil), e.g. to HTML-escape values substituted into markup.
+func substituteEscaped(recipient config.Recipient, text string, escape func(string) string) string {
+	if escape == nil {
+		escape = func(s string) string { return s }
+	}
 	pairs := []string{
-		placeholderEmail, recipient.Email,
-		placeholderFirstName, recipient.First,
-		placeholderLastName, recipient.Last,
+		placeholderEmail, escape(recipient.Email),
+		placeholderFirstName, escape(recipient.First),
+		placeholderLastName, escape(recipient.Last),
 	}
 	for k, v := range recipient.Data {
-		pairs = append(pairs, fmt.Sprintf("%%%s%%", k), v)
+		pairs = append(pairs, fmt.Sprintf("%%%s%%", k), escape(v))
+	}
+	for k, v := range recipient.Lists {
+		pairs = append(pairs, fmt.Sprintf("%%%s%%", k), escape(strings.Join(v, ", ")))
 	}
 	return strings.NewReplacer(pairs...).Replace(text)
 }
@@ -67,13 +116,16 @@ func placeholderKey(p string) string { return p[1 : len(p)-1] }
 // availableKeys returns the set of placeholder keys resolvable for a recipient:
 // the reserved keys (EA/FN/LN) plus the recipient's upper-cased data keys.
 func availableKeys(r config.Recipient) map[string]struct{} {
-	keys := make(map[string]struct{}, len(r.Data)+3)
+	keys := make(map[string]struct{}, len(r.Data)+len(r.Lists)+3)
 	keys[placeholderKey(placeholderEmail)] = struct{}{}
 	keys[placeholderKey(placeholderFirstName)] = struct{}{}
 	keys[placeholderKey(placeholderLastName)] = struct{}{}
 	for k := range r.Data {
 		keys[k] = struct{}{}
 	}
+	for k := range r.Lists {
+		keys[k] = struct{}{}
+	}
 	return keys
 }
 
@@ -97,44 +149,236 @@ func unresolvedPlaceholders(text string, keys map[string]struct{}) []string {
 	return missing
 }
 
-// PrepMails generates a Message for each recipient by substituting template
-// variables and resolving per-recipient Cc and attachment overrides.
-// Returns an error if any placeholders remain unresolved.
-func PrepMails(cfg *config.MailConfig, template string) ([]Message, error) {
-	var errs []string
+// PrepMails generates a Message for each recipient by rendering the subject and
+// templates with cfg.TemplateEngine and resolving per-recipient Cc, Bcc and
+// attachment overrides. Every recipient is rendered before any mail is sent,
+// and an error lists each one whose subject or body could not be fully
+// resolved (an unresolved %KEY% placeholder, or a missing key or failed
+// execution in the go engine), or whose sender addresses, headers or
+// unsubscribe targets are malformed, e.g. a header value that a substituted
+// line break would split. Each kind of problem is listed under its own heading.
+//
+// Recipient, Cc and Bcc addresses are checked against the suppression list,
+// and every match is returned. If cfg.SuppressionMode is
+// config.SuppressionSkip, suppressed recipients get no message and suppressed
+// Cc and Bcc addresses are dropped from the message they were to be copied on.
+func PrepMails(cfg *config.MailConfig, tmpl Templates) ([]Message, []SuppressedAddress, error) {
+	if tmpl.Text == "" && tmpl.HTML == "" {
+		return nil, nil, fmt.Errorf("no text or HTML template given")
+	}
+	textFM, text, err := SplitFrontMatter(tmpl.Text)
+	if err != nil {
+		return nil, nil, fmt.Errorf("template: %w", err)
+	}
+	htmlFM, html, err := SplitFrontMatter(tmpl.HTML)
+	if err != nil {
+		return nil, nil, fmt.Errorf("HTML template: %w", err)
+	}
+	fm, err := TemplateFrontMatter(textFM, htmlFM)
+	if err != nil {
+		return nil, nil, err
+	}
+	if fm == nil {
+		fm = &FrontMatter{}
+	}
+	htmlText := html
+	switch fm.Format {
+	case FormatHTML, FormatMarkdown:
+		if html != "" {
+			return nil, nil, fmt.Errorf("front matter format %q conflicts with an HTML template", fm.Format)
+		}
+		if fm.Format == FormatHTML {
+			text, htmlText = "", text
+		}
+	}
+	if strings.TrimSpace(text) == "" && strings.TrimSpace(htmlText) == "" {
+		return nil, nil, fmt.Errorf("template has no body after the front matter")
+	}
+
+	subject := cmp.Or(fm.Subject, cfg.Subject)
+	globalAttachments, globalHeaders := cfg.Attachments, cfg.Headers
+	if fm.Attachments != nil {
+		globalAttachments = fm.Attachments
+	}
+	if fm.Headers != nil {
+		globalHeaders = fm.Headers
+	}
+	if subject == "" {
+		for _, r := range cfg.Recipients {
+			if r.Subject == "" {
+				return nil, nil, fmt.Errorf("no subject for recipient '%s': set subject in [general] or in the template front matter", r.Email)
+			}
+		}
+	}
+
+	var subjectTmpl, bodyTmpl, htmlTmpl, markdownTmpl textTemplate
+	if subject != "" {
+		if subjectTmpl, err = parseTemplate(cfg.TemplateEngine, "subject", subject); err != nil {
+			return nil, nil, err
+		}
+	}
+	if text != "" {
+		if bodyTmpl, err = parseTemplate(cfg.TemplateEngine, "body", text); err != nil {
+			return nil, nil, err
+		}
+	}
+	if fm.Format == FormatMarkdown {
+		// The text part shows values as they are; the source rendered to
+		// HTML has them Markdown-escaped.
+		if markdownTmpl, err = parseMarkdownTemplate(cfg.TemplateEngine, "body", text); err != nil {
+			return nil, nil, err
+		}
+	}
+	if htmlText != "" {
+		if htmlTmpl, err = parseHTMLTemplate(cfg.TemplateEngine, "HTML body", htmlText); err != nil {
+			return nil, nil, err
+		}
+	}
+	unsubscribeTmpl, err := parseUnsubscribe(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	skip := cfg.SuppressionMode == config.SuppressionSkip
+	// Rendered values that are malformed, by kind; each gets its own heading.
+	var errs, badHeaders, badSenders, badUnsubscribe []string
+	var suppressed []SuppressedAddress
 	mails := make([]Message, 0, len(cfg.Recipients))
 	for _, recipient := range cfg.Recipients {
-		cc := resolveOverride(cfg.Cc, recipient.Cc, recipient.CcExtra)
-		attachments := resolveOverride(cfg.Attachments, recipient.Attachments, recipient.AttachmentsExtra)
-
-		keys := availableKeys(recipient)
-		if unresolved := unresolvedPlaceholders(cfg.Subject, keys); len(unresolved) > 0 {
-			errs = append(errs, fmt.Sprintf("recipient '%s': unresolved placeholder(s) in subject: %s", recipient.Email, strings.Join(unresolved, ", ")))
+		if e, ok := cfg.Suppression(recipient.Email); ok {
+			suppressed = append(suppressed, SuppressedAddress{Recipient: recipient.Email, Address: recipient.Email, Entry: e})
+			if skip {
+				continue
+			}
 		}
-		if unresolved := unresolvedPlaceholders(template, keys); len(unresolved) > 0 {
-			errs = append(errs, fmt.Sprintf("recipient '%s': unresolved placeholder(s) in body: %s", recipient.Email, strings.Join(unresolved, ", ")))
+		copies := func(field string, addrs []string) []string {
+			var kept []string
+			for _, addr := range addrs {
+				if e, ok := cfg.Suppression(addr); ok {
+					suppressed = append(suppressed, SuppressedAddress{Recipient: recipient.Email, Address: addr, Copy: field, Entry: e})
+					if skip {
+						continue
+					}
+				}
+				kept = append(kept, addr)
+			}
+			return kept
 		}
+		cc := copies("Cc", resolveOverride(cfg.Cc, recipient.Cc, recipient.CcExtra))
+		bcc := copies("Bcc", resolveOverride(cfg.Bcc, recipient.Bcc, recipient.BccExtra))
+		attachments := resolveOverride(globalAttachments, recipient.Attachments, recipient.AttachmentsExtra)
 
-		subject := substituteVariables(recipient, cfg.Subject)
-		body := substituteVariables(recipient, template)
+		render := func(t textTemplate, field string) string {
+			if t == nil {
+				return ""
+			}
+			out, err := t.execute(recipient)
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("recipient '%s': %s: %v", recipient.Email, field, err))
+			}
+			return out
+		}
+		// override renders a per-recipient replacement for a [general] field.
+		override := func(field, text string) string {
+			if text == "" {
+				return ""
+			}
+			t, err := parseTemplate(cfg.TemplateEngine, field, text)
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("recipient '%s': %v", recipient.Email, err))
+				return ""
+			}
+			return render(t, field)
+		}
+		subject := override("subject", recipient.Subject)
+		if recipient.Subject == "" {
+			subject = render(subjectTmpl, "subject")
+		}
+		from := override("from", recipient.From)
+		replyTo := override("reply_to", recipient.ReplyTo)
+		for _, field := range []struct{ name, value string }{{"from", from}, {"reply_to", replyTo}} {
+			if field.value == "" {
+				continue
+			}
+			if _, err := netmail.ParseAddress(field.value); err != nil {
+				badSenders = append(badSenders, fmt.Sprintf("recipient '%s': %s: invalid address %q: %v", recipient.Email, field.name, field.value, err))
+			}
+		}
+		body := render(bodyTmpl, "body")
+		htmlBody := render(htmlTmpl, "HTML body")
+		if bodyTmpl == nil {
+			body = htmlToText(htmlBody)
+		}
+		if markdownTmpl != nil {
+			// Substitution errors are the body's, reported above.
+			if source, err := markdownTmpl.execute(recipient); err == nil {
+				if htmlBody, err = markdownToHTML(source); err != nil {
+					errs = append(errs, fmt.Sprintf("recipient '%s': body: %v", recipient.Email, err))
+				}
+			}
+		}
+		headers, err := renderHeaders(cfg.TemplateEngine, resolveHeaders(globalHeaders, recipient.Headers, recipient.HeadersExtra), render)
+		if err != nil {
+			return nil, nil, err
+		}
+		for name, value := range headers {
+			if err := config.CheckHeaderValue(name, value); err != nil {
+				badHeaders = append(badHeaders, fmt.Sprintf("recipient '%s': %v", recipient.Email, err))
+				delete(headers, name)
+			}
+		}
+		unsubscribe, err := unsubscribeURIs(render(unsubscribeTmpl.mailto, "unsubscribe_mailto"), render(unsubscribeTmpl.url, "unsubscribe_url"))
+		if err != nil {
+			badUnsubscribe = append(badUnsubscribe, fmt.Sprintf("recipient '%s': %v", recipient.Email, err))
+		}
 
 		name := strings.TrimSpace(recipient.First + " " + recipient.Last)
 		mails = append(mails, Message{
 			Name:        name,
 			Address:     recipient.Email,
+			From:        from,
+			ReplyTo:     replyTo,
 			Subject:     subject,
 			Body:        body,
+			HTMLBody:    htmlBody,
 			Cc:          cc,
+			Bcc:         bcc,
 			Attachments: attachments,
+			SMIMECert:   recipient.SMIMECert,
+			PGPKey:      recipient.PGPKey,
+			Unsubscribe: unsubscribe,
+			Headers:     headers,
 		})
 	}
 	if len(errs) > 0 {
-		return nil, fmt.Errorf("unresolved placeholders:\n  %s", strings.Join(errs, "\n  "))
+		heading := "unresolved placeholders"
+		if cfg.TemplateEngine == config.TemplateEngineGo {
+			heading = "template errors"
+		}
+		return nil, nil, fmt.Errorf("%s:\n  %s", heading, strings.Join(errs, "\n  "))
+	}
+	var sections []string
+	for _, bad := range []struct {
+		heading string
+		lines   []string
+	}{
+		{"invalid sender addresses", badSenders},
+		{"invalid headers", badHeaders},
+		{"invalid unsubscribe links", badUnsubscribe},
+	} {
+		if len(bad.lines) > 0 {
+			slices.Sort(bad.lines)
+			sections = append(sections, fmt.Sprintf("%s:\n  %s", bad.heading, strings.Join(bad.lines, "\n  ")))
+		}
+	}
+	if len(sections) > 0 {
+		return nil, nil, errors.New(strings.Join(sections, "\n"))
 	}
-	return mails, nil
+	return mails, suppressed, nil
 }
 
-// resolveOverride returns the effective list for a field (Cc or attachments).
+// resolveOverride returns the effective list for a field (Cc, Bcc or
+// attachments).
 // If replace is set, it replaces global. If extra is set, it appends to global.
 // Otherwise, global is returned as-is.
 func resolveOverride(global, replace, extra []string) []string {
@@ -147,3 +391,41 @@ func resolveOverride(global, replace, extra []string) []string {
 	return slices.Clone(global)
 }
 
+// resolveHeaders returns the effective extra headers for a recipient, with
+// the semantics of resolveOverride: replace replaces global, and extra is
+// added to it, overriding a global header of the same name.
+func resolveHeaders(global, replace, extra map[string]string) map[string]string {
+	if len(replace) > 0 {
+		return maps.Clone(replace)
+	}
+	headers := maps.Clone(global)
+	for name, value := range extra {
+		for g := range headers {
+			if strings.EqualFold(g, name) {
+				delete(headers, g)
+			}
+		}
+		if headers == nil {
+			headers = make(map[string]string, len(extra))
+		}
+		headers[name] = value
+	}
+	return headers
+}
+
+// renderHeaders renders the header values in headers, which are templates
+// for engine, with render. It returns an error if a value does not parse.
+func renderHeaders(engine string, headers map[string]string, render func(textTemplate, string) string) (map[string]string, error) {
+	if len(headers) == 0 {
+		return nil, nil
+	}
+	out := make(map[string]string, len(headers))
+	for _, name := range slices.Sorted(maps.Keys(headers)) {
+		t, err := parseTemplate(engine, "header "+name, headers[name])
+		if err != nil {
+			return nil, err
+		}
+		out[name] = render(t, "header "+name)
+	}
+	return out, nil
+}
@@ -17,6 +17,7 @@
 package email
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/al-maisan/gmt/config"
@@ -64,7 +65,7 @@ func TestPrepMailsBasic(t *testing.T) {
 			{Email: "jd@example.com", First: "John", Last: "Doe", Data: map[string]string{}},
 		},
 	}
-	mails, err := PrepMails(&cfg, "Hello %FN% %LN%")
+	mails, _, err := PrepMails(&cfg, Templates{Text: "Hello %FN% %LN%"})
 	require.NoError(t, err)
 	assert.Len(t, mails, 1)
 	assert.Equal(t, "John Doe", mails[0].Name)
@@ -80,7 +81,7 @@ func TestPrepMailsSingleName(t *testing.T) {
 			{Email: "m@example.com", First: "Madonna", Last: "", Data: map[string]string{}},
 		},
 	}
-	mails, err := PrepMails(&cfg, "Hello %FN%")
+	mails, _, err := PrepMails(&cfg, Templates{Text: "Hello %FN%"})
 	require.NoError(t, err)
 	assert.Len(t, mails, 1)
 	assert.Equal(t, "Madonna", mails[0].Name)
@@ -134,7 +135,7 @@ func TestPrepMailsPerRecipientOverrides(t *testing.T) {
 				Attachments: tt.globalAttach,
 				Recipients:  []config.Recipient{tt.recipient},
 			}
-			mails, err := PrepMails(&cfg, "body")
+			mails, _, err := PrepMails(&cfg, Templates{Text: "body"})
 			require.NoError(t, err)
 			require.Len(t, mails, 1)
 			assert.Equal(t, tt.wantCc, mails[0].Cc)
@@ -151,7 +152,7 @@ func TestPrepMailsDoesNotMutateConfig(t *testing.T) {
 			{Email: "a@b.com", First: "A", Last: "B", Cc: []string{"override@cc.com"}, Data: map[string]string{"ORG": "EFF"}},
 		},
 	}
-	_, err := PrepMails(&cfg, "body")
+	_, _, err := PrepMails(&cfg, Templates{Text: "body"})
 	require.NoError(t, err)
 	assert.Equal(t, []string{"override@cc.com"}, cfg.Recipients[0].Cc)
 	assert.Equal(t, "EFF", cfg.Recipients[0].Data["ORG"])
@@ -164,7 +165,7 @@ func TestPrepMailsUnresolvedPlaceholders(t *testing.T) {
 			{Email: "a@b.com", First: "Alice", Last: "Bob", Data: map[string]string{}},
 		},
 	}
-	_, err := PrepMails(&cfg, "Hello %FN%, your role is %ROLE%")
+	_, _, err := PrepMails(&cfg, Templates{Text: "Hello %FN%, your role is %ROLE%"})
 	require.Error(t, err)
 	assert.Contains(t, err.Error(), "%DEPT%")
 	assert.Contains(t, err.Error(), "%ROLE%")
@@ -177,7 +178,7 @@ func TestPrepMailsNoErrorWhenAllResolved(t *testing.T) {
 			{Email: "a@b.com", First: "Alice", Last: "Bob", Data: map[string]string{"ORG": "EFF"}},
 		},
 	}
-	_, err := PrepMails(&cfg, "Hello %FN% from %ORG%")
+	_, _, err := PrepMails(&cfg, Templates{Text: "Hello %FN% from %ORG%"})
 	require.NoError(t, err)
 }
 
@@ -190,7 +191,7 @@ func TestPrepMailsPlaceholderLikeValueNotFlagged(t *testing.T) {
 			{Email: "a@b.com", First: "Alice", Last: "Bob", Data: map[string]string{"PROMO": "50%OFF%deal"}},
 		},
 	}
-	mails, err := PrepMails(&cfg, "Hello %FN%, code: %PROMO%")
+	mails, _, err := PrepMails(&cfg, Templates{Text: "Hello %FN%, code: %PROMO%"})
 	require.NoError(t, err)
 	require.Len(t, mails, 1)
 	assert.Equal(t, "Hello Alice, code: 50%OFF%deal", mails[0].Body)
@@ -221,7 +222,7 @@ func TestSampleConfigAndTemplateIntegration(t *testing.T) {
 	cfg, err := config.Parse([]byte(config.SampleConfig("0.0.0")))
 	require.NoError(t, err)
 
-	mails, err := PrepMails(&cfg, config.SampleTemplate())
+	mails, _, err := PrepMails(&cfg, Templates{Text: config.SampleTemplate()})
 	require.NoError(t, err, "sample config+template should produce no errors")
 	require.NotEmpty(t, mails, "should produce at least one mail")
 	for _, m := range mails {
@@ -231,3 +232,154 @@ func TestSampleConfigAndTemplateIntegration(t *testing.T) {
 		assert.NotEmpty(t, m.Body)
 	}
 }
+
+func TestPrepMailsSuppression(t *testing.T) {
+	list, err := config.NewSuppressions([]config.Suppression{
+		{Address: "gone@example.com", Status: "5.1.1"},
+		{Address: "*@optout.example", Diagnostic: "unsubscribed"},
+	})
+	require.NoError(t, err)
+	cfg := config.MailConfig{
+		Subject: "Hi %FN%!",
+		Cc:      []string{"boss@example.com", "Team <team@optout.example>"},
+		Recipients: []config.Recipient{
+			{Email: "ann@example.com", First: "Ann"},
+			{Email: "Gone@Example.com", First: "Bob"},
+		},
+		SuppressionMode: config.SuppressionSkip,
+		Suppressed:      list,
+	}
+	mails, suppressed, err := PrepMails(&cfg, Templates{Text: "Hello %FN%"})
+	require.NoError(t, err)
+	require.Len(t, mails, 1)
+	assert.Equal(t, "ann@example.com", mails[0].Address)
+	assert.Equal(t, []string{"boss@example.com"}, mails[0].Cc, "suppressed Cc dropped")
+	assert.Equal(t, []SuppressedAddress{
+		{Recipient: "ann@example.com", Address: "Team <team@optout.example>", Copy: "Cc", Entry: config.Suppression{Address: "*@optout.example", Diagnostic: "unsubscribed"}},
+		{Recipient: "Gone@Example.com", Address: "Gone@Example.com", Entry: config.Suppression{Address: "gone@example.com", Status: "5.1.1"}},
+	}, suppressed)
+
+	cfg.SuppressionMode = config.SuppressionWarn
+	mails, suppressed, err = PrepMails(&cfg, Templates{Text: "Hello %FN%"})
+	require.NoError(t, err)
+	require.Len(t, mails, 2)
+	assert.Len(t, mails[0].Cc, 2, "warn mode keeps Cc")
+	assert.Len(t, suppressed, 3)
+}
+
+func TestPrepMailsHeaders(t *testing.T) {
+	cfg := config.MailConfig{
+		Subject: "Hi",
+		Headers: map[string]string{"X-Campaign-ID": "autumn", "Precedence": "bulk"},
+		Recipients: []config.Recipient{
+			{Email: "a@example.com", First: "A"},
+			{Email: "b@example.com", First: "B", Data: map[string]string{"ORG": "EFF"},
+				HeadersExtra: map[string]string{"precedence": "list", "Organization": "%ORG%"}},
+			{Email: "c@example.com", First: "C", Headers: map[string]string{"X-Tag": "%FN%"}},
+		},
+	}
+	mails, _, err := PrepMails(&cfg, Templates{Text: "Hello"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"X-Campaign-ID": "autumn", "Precedence": "bulk"}, mails[0].Headers)
+	assert.Equal(t, map[string]string{"X-Campaign-ID": "autumn", "precedence": "list", "Organization": "EFF"}, mails[1].Headers)
+	assert.Equal(t, map[string]string{"X-Tag": "C"}, mails[2].Headers)
+	assert.Equal(t, map[string]string{"X-Campaign-ID": "autumn", "Precedence": "bulk"}, cfg.Headers, "config must not be mutated")
+
+	msg, err := createMessage("s@example.com", "", mails[1])
+	require.NoError(t, err)
+	data, err := renderMessage(msg)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "\r\nOrganization: EFF\r\n")
+	assert.Contains(t, string(data), "\r\nX-Campaign-ID: autumn\r\n")
+	assert.Contains(t, string(data), "\r\nprecedence: list\r\n")
+	assert.NotContains(t, string(data), "bulk")
+}
+
+func TestPrepMailsHeaderInjection(t *testing.T) {
+	cfg := config.MailConfig{
+		Subject: "Hi",
+		Headers: map[string]string{"Organization": "%ORG%"},
+		Recipients: []config.Recipient{
+			{Email: "a@example.com", First: "A", Data: map[string]string{"ORG": "EFF\r\nBcc: evil@example.com"}},
+			{Email: "b@example.com", First: "B", Data: map[string]string{"ORG": "EFF"}},
+		},
+	}
+	_, _, err := PrepMails(&cfg, Templates{Text: "Hello"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid headers:\n  recipient 'a@example.com': header \"Organization\": value must not contain line breaks")
+	assert.NotContains(t, err.Error(), "b@example.com")
+
+	cfg.Headers = map[string]string{"Organization": "%MISSING%"}
+	_, _, err = PrepMails(&cfg, Templates{Text: "Hello"})
+	assert.ErrorContains(t, err, "recipient 'a@example.com': header Organization: unresolved placeholder(s): %MISSING%")
+}
+
+func TestResolveHeaders(t *testing.T) {
+	global := map[string]string{"X-A": "1", "X-B": "2"}
+	assert.Equal(t, global, resolveHeaders(global, nil, nil))
+	assert.Equal(t, map[string]string{"X-C": "3"}, resolveHeaders(global, map[string]string{"X-C": "3"}, map[string]string{"X-D": "4"}))
+	assert.Equal(t, map[string]string{"X-A": "1", "x-b": "3"}, resolveHeaders(global, nil, map[string]string{"x-b": "3"}))
+	assert.Equal(t, map[string]string{"X-D": "4"}, resolveHeaders(nil, nil, map[string]string{"X-D": "4"}))
+	assert.Nil(t, resolveHeaders(nil, nil, nil))
+}
+
+func TestPrepMailsBcc(t *testing.T) {
+	list, err := config.NewSuppressions([]config.Suppression{{Address: "gone@example.com"}})
+	require.NoError(t, err)
+	cfg := config.MailConfig{
+		Subject: "Hi",
+		Bcc:     []string{"archive@example.com", "gone@example.com"},
+		Recipients: []config.Recipient{
+			{Email: "a@example.com", First: "A"},
+			{Email: "b@example.com", First: "B", Bcc: []string{"boss@example.com"}},
+			{Email: "c@example.com", First: "C", BccExtra: []string{"audit@example.com"}},
+		},
+		SuppressionMode: config.SuppressionSkip,
+		Suppressed:      list,
+	}
+	mails, suppressed, err := PrepMails(&cfg, Templates{Text: "Hello"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"archive@example.com"}, mails[0].Bcc)
+	assert.Equal(t, []string{"boss@example.com"}, mails[1].Bcc)
+	assert.Equal(t, []string{"archive@example.com", "audit@example.com"}, mails[2].Bcc)
+	require.Len(t, suppressed, 2)
+	assert.Equal(t, SuppressedAddress{Recipient: "a@example.com", Address: "gone@example.com", Copy: "Bcc", Entry: config.Suppression{Address: "gone@example.com"}}, suppressed[0])
+}
+
+func TestPrepMailsSenderOverrides(t *testing.T) {
+	cfg := config.MailConfig{
+		From:    "news@example.com",
+		Subject: "Hi %FN%!",
+		Recipients: []config.Recipient{
+			{Email: "a@example.com", First: "A"},
+			{Email: "b@example.com", First: "B", Data: map[string]string{"AM": "Ann Manager", "AM_EMAIL": "ann@example.com"},
+				From: "%AM% <%AM_EMAIL%>", ReplyTo: "%AM_EMAIL%", Subject: "Hallo %FN%!"},
+		},
+	}
+	mails, _, err := PrepMails(&cfg, Templates{Text: "Hello"})
+	require.NoError(t, err)
+	assert.Empty(t, mails[0].From)
+	assert.Empty(t, mails[0].ReplyTo)
+	assert.Equal(t, "Hi A!", mails[0].Subject)
+	assert.Equal(t, "Ann Manager <ann@example.com>", mails[1].From)
+	assert.Equal(t, "ann@example.com", mails[1].ReplyTo)
+	assert.Equal(t, "Hallo B!", mails[1].Subject)
+
+	cfg.Recipients[1].Data["AM_EMAIL"] = "not an address"
+	_, _, err = PrepMails(&cfg, Templates{Text: "Hello"})
+	require.Error(t, err)
+	assert.True(t, strings.HasPrefix(err.Error(), "invalid sender addresses:\n"), err.Error())
+	assert.NotContains(t, err.Error(), "invalid headers")
+	assert.ErrorContains(t, err, `recipient 'b@example.com': from: invalid address "Ann Manager <not an address>"`)
+	assert.ErrorContains(t, err, `recipient 'b@example.com': reply_to: invalid address "not an address"`)
+
+	cfg.Recipients[1].Subject = "Hallo %NICK%!"
+	_, _, err = PrepMails(&cfg, Templates{Text: "Hello"})
+	assert.ErrorContains(t, err, "recipient 'b@example.com': subject: unresolved placeholder(s): %NICK%")
+
+	cfg.TemplateEngine = config.TemplateEngineGo
+	cfg.Subject = "Hi"
+	cfg.Recipients[1].Subject = "Hallo {{.FN"
+	_, _, err = PrepMails(&cfg, Templates{Text: "Hello"})
+	assert.ErrorContains(t, err, "recipient 'b@example.com': invalid subject template")
+}
@@ -0,0 +1,208 @@
+// gmt sends emails in bulk based on a template and a config file.
+// Copyright (C) 2019-2025  "Muharem Hrnjadovic" <muharem@linux.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package email
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// stopWindow is the shortest rate-limit window that ends the run when it is
+// exhausted, instead of waiting for it to refill.
+const stopWindow = 24 * time.Hour
+
+// RateLimit allows at most N sends per window Per. Windows shorter than
+// stopWindow are enforced as a token bucket: up to N sends may go out back to
+// back, after which sends are spaced Per/N apart. Longer windows (a provider's
+// daily quota) are enforced as a sliding window: at most N sends in any
+// trailing Per, so a quota is never exceeded across a burst and its refill.
+type RateLimit struct {
+	N   int
+	Per time.Duration
+}
+
+// rateUnits maps the unit suffixes accepted by ParseRateLimits to windows.
+var rateUnits = map[string]time.Duration{
+	"s": time.Second, "sec": time.Second, "second": time.Second,
+	"m": time.Minute, "min": time.Minute, "minute": time.Minute,
+	"h": time.Hour, "hour": time.Hour,
+	"d": 24 * time.Hour, "day": 24 * time.Hour,
+}
+
+// String formats r as ParseRateLimits accepts it, e.g. "100/m".
+func (r RateLimit) String() string {
+	for _, unit := range []string{"d", "h", "m", "s"} {
+		if r.Per == rateUnits[unit] {
+			return fmt.Sprintf("%d/%s", r.N, unit)
+		}
+	}
+	return fmt.Sprintf("%d/%s", r.N, r.Per)
+}
+
+// ParseRateLimits parses a comma-separated list of limits such as
+// "10/s,100/m,2000/d". The window is s, m, h or d (or second, minute, hour,
+// day); each window may appear once.
+func ParseRateLimits(s string) ([]RateLimit, error) {
+	var limits []RateLimit
+	for part := range strings.SplitSeq(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		count, unit, ok := strings.Cut(part, "/")
+		if !ok {
+			return nil, fmt.Errorf("invalid rate limit %q: want COUNT/WINDOW, e.g. 100/m", part)
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(count))
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid rate limit %q: count must be a positive integer", part)
+		}
+		per, ok := rateUnits[strings.ToLower(strings.TrimSpace(unit))]
+		if !ok {
+			return nil, fmt.Errorf("invalid rate limit %q: window must be s, m, h or d", part)
+		}
+		if slices.ContainsFunc(limits, func(l RateLimit) bool { return l.Per == per }) {
+			return nil, fmt.Errorf("invalid rate limit %q: more than one limit for the same window", part)
+		}
+		limits = append(limits, RateLimit{N: n, Per: per})
+	}
+	return limits, nil
+}
+
+// bucket is the token bucket for one RateLimit. tokens may go negative: a
+// reservation takes its token at once and the caller sleeps until the bucket
+// would have refilled it. For a sliding-window limit, sends holds the send
+// times within the trailing window instead, oldest first.
+type bucket struct {
+	limit  RateLimit
+	quiet  bool // do not announce waits (the -delay pacing bucket)
+	tokens float64
+	last   time.Time
+	sends  []time.Time
+}
+
+// sliding reports whether b is enforced as a sliding window.
+func (b *bucket) sliding() bool { return b.limit.Per >= stopWindow }
+
+// take records a send at now.
+func (b *bucket) take(now time.Time) {
+	if b.sliding() {
+		b.sends = append(b.sends, now)
+		return
+	}
+	b.tokens--
+}
+
+// advance refills b for the time elapsed up to now, or for a sliding window,
+// drops the sends that have left it.
+func (b *bucket) advance(now time.Time) {
+	if b.sliding() {
+		cutoff := now.Add(-b.limit.Per)
+		i := 0
+		for i < len(b.sends) && !b.sends[i].After(cutoff) {
+			i++
+		}
+		b.sends = b.sends[i:]
+		return
+	}
+	if !b.last.IsZero() && now.After(b.last) {
+		rate := float64(b.limit.N) / b.limit.Per.Seconds()
+		b.tokens = min(float64(b.limit.N), b.tokens+now.Sub(b.last).Seconds()*rate)
+	}
+	b.last = now
+}
+
+// refillTime returns how long until b holds one token.
+func (b *bucket) refillTime() time.Duration {
+	if b.tokens >= 1 {
+		return 0
+	}
+	rate := float64(b.limit.N) / b.limit.Per.Seconds()
+	return time.Duration((1 - b.tokens) / rate * float64(time.Second))
+}
+
+// limiter enforces several rate limits at once across all workers.
+type limiter struct {
+	mu      sync.Mutex
+	buckets []*bucket
+	now     func() time.Time
+}
+
+// newLimiter returns a limiter for limits plus, if delay is positive, a
+// one-token bucket that spaces sends delay apart. All buckets start full.
+// Limits with a window of at least stopWindow are sliding windows; see
+// RateLimit.
+func newLimiter(limits []RateLimit, delay time.Duration) *limiter {
+	l := &limiter{now: time.Now}
+	for _, r := range limits {
+		l.buckets = append(l.buckets, &bucket{limit: r, tokens: float64(r.N)})
+	}
+	if delay > 0 {
+		l.buckets = append(l.buckets, &bucket{limit: RateLimit{N: 1, Per: delay}, quiet: true, tokens: 1})
+	}
+	return l
+}
+
+// seed charges the buckets for sends made before this run (e.g. taken from
+// the journal), so a daily quota spans runs.
+func (l *limiter) seed(sent []time.Time) {
+	sent = slices.Clone(sent)
+	slices.SortFunc(sent, func(a, b time.Time) int { return a.Compare(b) })
+	for _, t := range sent {
+		for _, b := range l.buckets {
+			b.advance(t)
+			b.take(t)
+		}
+	}
+}
+
+// reserve takes a token from every bucket and returns how long the caller must
+// wait before sending, and the limit that caused the longest wait (nil if
+// none worth announcing). If a sliding-window limit already has N sends in its
+// window, nothing is taken and exhausted is that limit, with the time it will
+// next allow a send: when the oldest of those sends leaves the window.
+func (l *limiter) reserve() (wait time.Duration, waitFor *RateLimit, exhausted *RateLimit, resume time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	for _, b := range l.buckets {
+		b.advance(now)
+		if b.sliding() && len(b.sends) >= b.limit.N {
+			return 0, nil, &b.limit, b.sends[len(b.sends)-b.limit.N].Add(b.limit.Per)
+		}
+	}
+	for _, b := range l.buckets {
+		if b.sliding() {
+			b.take(now)
+			continue
+		}
+		d := b.refillTime()
+		b.take(now)
+		if d > wait {
+			wait = d
+			waitFor = nil
+			if !b.quiet {
+				waitFor = &b.limit
+			}
+		}
+	}
+	return wait, waitFor, nil, time.Time{}
+}
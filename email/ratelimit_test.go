@@ -0,0 +1,190 @@
+// gmt sends emails in bulk based on a template and a config file.
+// Copyright (C) 2019-2025  "Muharem Hrnjadovic" <muharem@linux.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package email
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/al-maisan/gmt/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRateLimits(t *testing.T) {
+	limits, err := ParseRateLimits("10/s, 100/min,2000/Day")
+	require.NoError(t, err)
+	assert.Equal(t, []RateLimit{{10, time.Second}, {100, time.Minute}, {2000, 24 * time.Hour}}, limits)
+	assert.Equal(t, "100/m", limits[1].String())
+
+	limits, err = ParseRateLimits("")
+	require.NoError(t, err)
+	assert.Empty(t, limits)
+
+	for _, bad := range []string{"100", "0/m", "x/m", "10/week", "10/m,20/minute"} {
+		_, err := ParseRateLimits(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+// fakeClock is a manually advanced clock for limiter tests.
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) now() time.Time { return c.t }
+
+func TestLimiterBurstThenSpacing(t *testing.T) {
+	clock := &fakeClock{t: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+	l := newLimiter([]RateLimit{{N: 3, Per: time.Minute}}, 0)
+	l.now = clock.now
+
+	for range 3 {
+		wait, _, exhausted, _ := l.reserve()
+		assert.Zero(t, wait, "the first N sends go out at once")
+		assert.Nil(t, exhausted)
+	}
+	wait, waitFor, _, _ := l.reserve()
+	assert.Equal(t, 20*time.Second, wait)
+	require.NotNil(t, waitFor)
+	assert.Equal(t, "3/m", waitFor.String())
+
+	// The next reservation queues behind the previous one.
+	wait, _, _, _ = l.reserve()
+	assert.Equal(t, 40*time.Second, wait)
+}
+
+func TestLimiterDelayIsQuiet(t *testing.T) {
+	clock := &fakeClock{t: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+	l := newLimiter(nil, 5*time.Second)
+	l.now = clock.now
+
+	wait, _, _, _ := l.reserve()
+	assert.Zero(t, wait)
+	wait, waitFor, _, _ := l.reserve()
+	assert.Equal(t, 5*time.Second, wait)
+	assert.Nil(t, waitFor, "-delay pacing is not announced")
+}
+
+func TestLimiterDailyExhausted(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := &fakeClock{t: start}
+	l := newLimiter([]RateLimit{{N: 2, Per: 24 * time.Hour}}, 0)
+	l.now = clock.now
+
+	for range 2 {
+		_, _, exhausted, _ := l.reserve()
+		require.Nil(t, exhausted)
+	}
+	_, _, exhausted, resume := l.reserve()
+	require.NotNil(t, exhausted)
+	assert.Equal(t, "2/d", exhausted.String())
+	assert.Equal(t, start.Add(24*time.Hour), resume, "resume when the oldest send leaves the window")
+
+	clock.t = resume.Add(-time.Second)
+	_, _, exhausted, _ = l.reserve()
+	assert.NotNil(t, exhausted, "no refill before the window has passed")
+
+	clock.t = resume
+	_, _, exhausted, _ = l.reserve()
+	assert.Nil(t, exhausted, "the quota is available again a day later")
+}
+
+func TestLimiterDailyIsSlidingWindow(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := &fakeClock{t: start}
+	const n = 5
+	l := newLimiter([]RateLimit{{N: n, Per: 24 * time.Hour}}, 0)
+	l.now = clock.now
+
+	// Try to send 2N messages spread over one day: only N may go out.
+	sent := 0
+	for i := range 2 * n {
+		clock.t = start.Add(time.Duration(i) * 2 * time.Hour)
+		if _, _, exhausted, _ := l.reserve(); exhausted == nil {
+			sent++
+		}
+	}
+	assert.Equal(t, n, sent)
+
+	// A send leaves the window a day after it was made, one at a time.
+	clock.t = start.Add(24*time.Hour + time.Hour)
+	_, _, exhausted, _ := l.reserve()
+	assert.Nil(t, exhausted)
+	_, _, exhausted, resume := l.reserve()
+	require.NotNil(t, exhausted)
+	assert.Equal(t, start.Add(26*time.Hour), resume)
+}
+
+func TestLimiterSeed(t *testing.T) {
+	now := time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC)
+	l := newLimiter([]RateLimit{{N: 3, Per: 24 * time.Hour}}, 0)
+	l.now = func() time.Time { return now }
+	l.seed([]time.Time{now.Add(-time.Minute), now.Add(-2 * time.Minute), now.Add(-3 * time.Minute)})
+
+	_, _, exhausted, resume := l.reserve()
+	assert.NotNil(t, exhausted, "sends from an earlier run count against the daily quota")
+	assert.Equal(t, now.Add(24*time.Hour-3*time.Minute), resume)
+}
+
+func TestSendAllStopsAtDailyLimit(t *testing.T) {
+	sender := &mockSender{}
+	cfg := config.MailConfig{From: "sender@example.com"}
+	msgs := make([]Message, 5)
+	for i := range msgs {
+		msgs[i] = Message{Name: fmt.Sprintf("R%d", i+1), Address: fmt.Sprintf("r%d@example.com", i+1), Subject: "Hi", Body: "Hello"}
+	}
+
+	var buf bytes.Buffer
+	opts := SendOptions{RateLimits: []RateLimit{{N: 3, Per: 24 * time.Hour}}}
+	result := NewBatchSender(&buf, sender, cfg, opts).SendAll(msgs)
+	assert.Equal(t, 3, result.Sent)
+	assert.Equal(t, 0, result.Failed)
+	assert.Equal(t, 3, sender.sent)
+	require.Len(t, result.Pending, 2)
+	assert.Equal(t, "r4@example.com", result.Pending[0].Address)
+	assert.Equal(t, "r5@example.com", result.Pending[1].Address)
+	assert.Equal(t, RateLimit{N: 3, Per: 24 * time.Hour}, result.Limit)
+	assert.False(t, result.ResumeAt.IsZero())
+	assert.Contains(t, buf.String(), "Rate limit 3/d exhausted")
+}
+
+func TestSendAllDailyLimitSpansRunsWithJournal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.jsonl")
+	cfg := config.MailConfig{From: "sender@example.com"}
+	msgs := make([]Message, 4)
+	for i := range msgs {
+		msgs[i] = Message{Name: "R", Address: fmt.Sprintf("r%d@example.com", i+1), Subject: "Hi", Body: "Hello"}
+	}
+	opts := SendOptions{RateLimits: []RateLimit{{N: 2, Per: 24 * time.Hour}}}
+
+	var buf bytes.Buffer
+	opts.Journal = openTestJournal(t, path, "run")
+	result := NewBatchSender(&buf, &mockSender{}, cfg, opts).SendAll(msgs)
+	assert.Equal(t, 2, result.Sent)
+	assert.Len(t, result.Pending, 2)
+	require.NoError(t, opts.Journal.Close())
+
+	// Same day: the quota is still used up, and nobody is mailed twice.
+	opts.Journal = openTestJournal(t, path, "run")
+	sender := &mockSender{}
+	result = NewBatchSender(&buf, sender, cfg, opts).SendAll(msgs)
+	assert.Equal(t, 0, sender.sent)
+	assert.Equal(t, 2, result.Skipped)
+	assert.Len(t, result.Pending, 2)
+}
@@ -0,0 +1,84 @@
+// gmt sends emails in bulk based on a template and a config file.
+// Copyright (C) 2019-2025  "Muharem Hrnjadovic" <muharem@linux.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package email
+
+import (
+	"fmt"
+	netmail "net/mail"
+	"strings"
+
+	mail "github.com/wneessen/go-mail"
+)
+
+// Headers that preserve the real recipients of a redirected message.
+const (
+	HeaderOriginalTo  mail.Header = "X-Original-To"
+	HeaderOriginalCc  mail.Header = "X-Original-Cc"
+	HeaderOriginalBcc mail.Header = "X-Original-Bcc"
+)
+
+// ParseRedirect parses a comma-separated list of test addresses for
+// SendOptions.Redirect.
+func ParseRedirect(s string) ([]string, error) {
+	var addrs []string
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		addr, err := netmail.ParseAddress(field)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address %q: %w", field, err)
+		}
+		addrs = append(addrs, addr.Address)
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("no addresses in %q", s)
+	}
+	return addrs, nil
+}
+
+// redirectSubject returns the subject of a message to address, redirected.
+func redirectSubject(subject, address string) string {
+	return fmt.Sprintf("[TEST to %s] %s", address, subject)
+}
+
+// redirectMessage readdresses msg, built for m, to the test addresses in to.
+// The real To, Cc and Bcc are dropped from the envelope and kept in the
+// X-Original-To, X-Original-Cc and X-Original-Bcc headers (so a test copy
+// shows its blind copies), and the subject is prefixed with
+// the real address, so each test copy shows who would have received it.
+func redirectMessage(msg *mail.Msg, m Message, to []string) error {
+	if err := msg.To(to...); err != nil {
+		return fmt.Errorf("invalid redirect address(es) %v: %w", to, err)
+	}
+	msg.SetGenHeader(HeaderOriginalTo, (&netmail.Address{Name: m.Name, Address: m.Address}).String())
+	if len(m.Cc) > 0 {
+		if err := msg.Cc(); err != nil {
+			return err
+		}
+		msg.SetGenHeader(HeaderOriginalCc, strings.Join(m.Cc, ", "))
+	}
+	if len(m.Bcc) > 0 {
+		if err := msg.Bcc(); err != nil {
+			return err
+		}
+		msg.SetGenHeader(HeaderOriginalBcc, strings.Join(m.Bcc, ", "))
+	}
+	msg.Subject(redirectSubject(m.Subject, m.Address))
+	return nil
+}
@@ -0,0 +1,109 @@
+// gmt sends emails in bulk based on a template and a config file.
+// Copyright (C) 2019-2025  "Muharem Hrnjadovic" <muharem@linux.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package email
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/al-maisan/gmt/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	mail "github.com/wneessen/go-mail"
+)
+
+// envelopeSender records the envelope recipients and the rendered data of
+// each message.
+type envelopeSender struct {
+	rcpts [][]string
+	sent  []string
+}
+
+func (e *envelopeSender) Send(msg *mail.Msg) error {
+	rcpts, err := msg.GetRecipients()
+	if err != nil {
+		return err
+	}
+	data, err := renderMessage(msg)
+	if err != nil {
+		return err
+	}
+	for i, rcpt := range rcpts {
+		rcpts[i] = bareAddress(rcpt)
+	}
+	e.rcpts = append(e.rcpts, rcpts)
+	e.sent = append(e.sent, string(data))
+	return nil
+}
+func (e *envelopeSender) Reconnect() error { return nil }
+func (e *envelopeSender) Close() error     { return nil }
+
+func TestParseRedirect(t *testing.T) {
+	addrs, err := ParseRedirect("qa@example.com, Tester <test@example.com>,")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"qa@example.com", "test@example.com"}, addrs)
+
+	_, err = ParseRedirect("qa@example.com,not-an-address")
+	assert.ErrorContains(t, err, `invalid address "not-an-address"`)
+	_, err = ParseRedirect(" , ")
+	assert.ErrorContains(t, err, "no addresses")
+}
+
+func TestSendAllRedirects(t *testing.T) {
+	attachment := filepath.Join(t.TempDir(), "notes.txt")
+	require.NoError(t, os.WriteFile(attachment, []byte("attached\n"), 0o600))
+
+	sender := &envelopeSender{}
+	var out bytes.Buffer
+	opts := SendOptions{Redirect: []string{"qa@example.com", "test@example.com"}}
+	result := NewBatchSender(&out, sender, config.MailConfig{From: "sender@example.com"}, opts).SendAll([]Message{
+		{Name: "Jane Doe", Address: "jane@example.com", Subject: "Hi", Body: "Hello", HTMLBody: "<p>Hello</p>",
+			Cc: []string{"boss@example.com", "team@example.com"}, Attachments: []string{attachment}},
+		{Address: "joe@example.com", Subject: "Hi", Body: "Hello"},
+	})
+	require.Equal(t, 2, result.Sent)
+
+	assert.Equal(t, []string{"qa@example.com", "test@example.com"}, sender.rcpts[0], "real recipients are not in the envelope")
+	data := sender.sent[0]
+	assert.Contains(t, data, "To: <qa@example.com>, <test@example.com>\r\n")
+	assert.NotContains(t, data, "\r\nCc:")
+	assert.Contains(t, data, "X-Original-To: \"Jane Doe\" <jane@example.com>\r\n")
+	assert.Contains(t, data, "X-Original-Cc: boss@example.com, team@example.com\r\n")
+	assert.Contains(t, data, "Subject: [TEST to jane@example.com] Hi\r\n")
+	assert.Contains(t, data, "multipart/alternative")
+	assert.Contains(t, data, `filename="notes.txt"`)
+
+	assert.Equal(t, []string{"qa@example.com", "test@example.com"}, sender.rcpts[1])
+	assert.NotContains(t, sender.sent[1], "X-Original-Cc")
+	assert.Contains(t, sender.sent[1], "Subject: [TEST to joe@example.com] Hi\r\n")
+	assert.Contains(t, out.String(), "Redirected to: qa@example.com, test@example.com")
+}
+
+func TestSendAllRedirectsDropsBcc(t *testing.T) {
+	sender := &envelopeSender{}
+	var out bytes.Buffer
+	opts := SendOptions{Redirect: []string{"qa@example.com"}}
+	result := NewBatchSender(&out, sender, config.MailConfig{From: "sender@example.com"}, opts).SendAll([]Message{
+		{Address: "jane@example.com", Subject: "Hi", Body: "Hello", Bcc: []string{"audit@example.com"}},
+	})
+	require.Equal(t, 1, result.Sent)
+	assert.Equal(t, [][]string{{"qa@example.com"}}, sender.rcpts)
+	assert.Contains(t, sender.sent[0], "X-Original-Bcc: audit@example.com\r\n")
+	assert.NotContains(t, out.String(), "Bcc: audit")
+}
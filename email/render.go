@@ -0,0 +1,152 @@
+// gmt sends emails in bulk based on a template and a config file.
+// Copyright (C) 2019-2025  "Muharem Hrnjadovic" <muharem@linux.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package email
+
+import (
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"strings"
+	"text/template"
+
+	"github.com/al-maisan/gmt/config"
+)
+
+// textTemplate is a parsed subject or body template, rendered once per
+// recipient.
+type textTemplate interface {
+	// execute renders the template for r. It returns an error if the template
+	// refers to a key r cannot resolve, so nothing is sent half-substituted.
+	execute(r config.Recipient) (string, error)
+}
+
+// parseTemplate parses text with the given engine (see config.TemplateEngine*);
+// an empty engine selects the placeholder engine. name identifies the template
+// in error messages.
+func parseTemplate(engine, name, text string) (textTemplate, error) {
+	switch engine {
+	case "", config.TemplateEnginePlaceholder:
+		return placeholderTemplate{text: text}, nil
+	case config.TemplateEngineGo:
+		t, err := template.New(name).Option("missingkey=error").Funcs(templateFuncs).Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s template: %w", name, err)
+		}
+		return goTemplate{t}, nil
+	default:
+		return nil, fmt.Errorf("unknown template engine %q", engine)
+	}
+}
+
+// parseHTMLTemplate is parseTemplate for HTML bodies: substituted values are
+// HTML-escaped, and the go engine uses html/template's contextual escaping.
+func parseHTMLTemplate(engine, name, text string) (textTemplate, error) {
+	switch engine {
+	case "", config.TemplateEnginePlaceholder:
+		return placeholderTemplate{text: text, escape: htmltemplate.HTMLEscapeString}, nil
+	case config.TemplateEngineGo:
+		t, err := htmltemplate.New(name).Option("missingkey=error").Funcs(htmltemplate.FuncMap(templateFuncs)).Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s template: %w", name, err)
+		}
+		return goTemplate{t}, nil
+	default:
+		return nil, fmt.Errorf("unknown template engine %q", engine)
+	}
+}
+
+// parseMarkdownTemplate is parseTemplate for Markdown sources that are
+// rendered to HTML: with the placeholder engine, substituted values are
+// Markdown-escaped so they show as written instead of adding markup or links.
+// The go engine inserts values as they are.
+func parseMarkdownTemplate(engine, name, text string) (textTemplate, error) {
+	switch engine {
+	case "", config.TemplateEnginePlaceholder:
+		return placeholderTemplate{text: text, escape: escapeMarkdown}, nil
+	default:
+		return parseTemplate(engine, name, text)
+	}
+}
+
+// placeholderTemplate is the default engine: flat %KEY% substitution, with
+// each value passed through escape if set.
+type placeholderTemplate struct {
+	text   string
+	escape func(string) string
+}
+
+func (p placeholderTemplate) execute(r config.Recipient) (string, error) {
+	if unresolved := unresolvedPlaceholders(p.text, availableKeys(r)); len(unresolved) > 0 {
+		return "", fmt.Errorf("unresolved placeholder(s): %s", strings.Join(unresolved, ", "))
+	}
+	return substituteEscaped(r, p.text, p.escape), nil
+}
+
+// goTemplate renders with text/template or html/template. It is parsed with
+// missingkey=error, so a reference to an undefined key fails the recipient
+// instead of rendering "<no value>".
+type goTemplate struct {
+	t interface {
+		Execute(w io.Writer, data any) error
+	}
+}
+
+func (g goTemplate) execute(r config.Recipient) (string, error) {
+	var b strings.Builder
+	if err := g.t.Execute(&b, templateData(r)); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// templateData returns the dot value for the go engine: the reserved keys
+// (EA/FN/LN) plus the recipient's data, with list-valued data as []string so
+// it can be used with {{range}}.
+func templateData(r config.Recipient) map[string]any {
+	data := make(map[string]any, len(r.Data)+len(r.Lists)+3)
+	data[placeholderKey(placeholderEmail)] = r.Email
+	data[placeholderKey(placeholderFirstName)] = r.First
+	data[placeholderKey(placeholderLastName)] = r.Last
+	for k, v := range r.Data {
+		data[k] = v
+	}
+	for k, v := range r.Lists {
+		data[k] = v
+	}
+	return data
+}
+
+// templateFuncs is the curated function set available to the go engine. The
+// subject string comes last so the functions chain in pipelines, e.g.
+// {{.ITEMS | join ", "}} or {{.ROLE | default "Member"}}.
+var templateFuncs = template.FuncMap{
+	"upper":     strings.ToUpper,
+	"lower":     strings.ToLower,
+	"trim":      strings.TrimSpace,
+	"join":      func(sep string, items []string) string { return strings.Join(items, sep) },
+	"split":     func(sep, s string) []string { return strings.Split(s, sep) },
+	"replace":   func(old, repl, s string) string { return strings.ReplaceAll(s, old, repl) },
+	"contains":  func(substr, s string) bool { return strings.Contains(s, substr) },
+	"hasPrefix": func(prefix, s string) bool { return strings.HasPrefix(s, prefix) },
+	"hasSuffix": func(suffix, s string) bool { return strings.HasSuffix(s, suffix) },
+	"default": func(def, s string) string {
+		if s == "" {
+			return def
+		}
+		return s
+	},
+}
@@ -0,0 +1,112 @@
+// gmt sends emails in bulk based on a template and a config file.
+// Copyright (C) 2019-2025  "Muharem Hrnjadovic" <muharem@linux.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package email
+
+import (
+	"testing"
+
+	"github.com/al-maisan/gmt/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGoTemplateExecute(t *testing.T) {
+	r := config.Recipient{
+		Email: "jd@example.com", First: "John", Last: "Doe",
+		Data:  map[string]string{"ROLE": "Manager", "TEAM": ""},
+		Lists: map[string][]string{"ITEMS": {"laptop", "badge"}},
+	}
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"fields", "{{.FN}} {{.LN}} <{{.EA}}>", "John Doe <jd@example.com>"},
+		{"if", `{{if eq .ROLE "Manager"}}Please approve.{{else}}FYI.{{end}}`, "Please approve."},
+		{"range", "{{range .ITEMS}}- {{.}}\n{{end}}", "- laptop\n- badge\n"},
+		{"join", `{{.ITEMS | join ", "}}`, "laptop, badge"},
+		{"default", `{{.TEAM | default "none"}}`, "none"},
+		{"upper", "{{.FN | upper}}", "JOHN"},
+		{"split", `{{range split "," "a,b"}}[{{.}}]{{end}}`, "[a][b]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl, err := parseTemplate(config.TemplateEngineGo, "body", tt.text)
+			require.NoError(t, err)
+			got, err := tmpl.execute(r)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestGoTemplateMissingKey(t *testing.T) {
+	tmpl, err := parseTemplate(config.TemplateEngineGo, "body", "Hello {{.FN}}, {{.ROLE}}")
+	require.NoError(t, err)
+	_, err = tmpl.execute(config.Recipient{Email: "a@b.com", First: "Alice"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `map has no entry for key "ROLE"`)
+}
+
+func TestGoTemplateParseError(t *testing.T) {
+	_, err := parseTemplate(config.TemplateEngineGo, "subject", "Hello {{if .FN}")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid subject template")
+}
+
+func TestPlaceholderTemplateListValue(t *testing.T) {
+	tmpl, err := parseTemplate(config.TemplateEnginePlaceholder, "body", "Items: %ITEMS%")
+	require.NoError(t, err)
+	got, err := tmpl.execute(config.Recipient{Lists: map[string][]string{"ITEMS": {"a", "b"}}})
+	require.NoError(t, err)
+	assert.Equal(t, "Items: a, b", got)
+}
+
+func TestPrepMailsGoEngine(t *testing.T) {
+	cfg := config.MailConfig{
+		Subject:        "{{if eq .ROLE \"Manager\"}}[Action] {{end}}Hi {{.FN}}",
+		TemplateEngine: config.TemplateEngineGo,
+		Recipients: []config.Recipient{
+			{Email: "a@b.com", First: "Alice", Data: map[string]string{"ROLE": "Manager"}},
+			{Email: "c@d.com", First: "Carl", Data: map[string]string{"ROLE": "Engineer"}},
+		},
+	}
+	mails, _, err := PrepMails(&cfg, Templates{Text: "Dear {{.FN}}"})
+	require.NoError(t, err)
+	require.Len(t, mails, 2)
+	assert.Equal(t, "[Action] Hi Alice", mails[0].Subject)
+	assert.Equal(t, "Hi Carl", mails[1].Subject)
+	assert.Equal(t, "Dear Carl", mails[1].Body)
+}
+
+func TestPrepMailsGoEngineMissingKeyIsPreflight(t *testing.T) {
+	// The second recipient lacks ROLE: the whole batch must be rejected up
+	// front, naming the recipient, rather than failing mid-send.
+	cfg := config.MailConfig{
+		Subject:        "Hi {{.FN}}",
+		TemplateEngine: config.TemplateEngineGo,
+		Recipients: []config.Recipient{
+			{Email: "a@b.com", First: "Alice", Data: map[string]string{"ROLE": "Manager"}},
+			{Email: "c@d.com", First: "Carl", Data: map[string]string{}},
+		},
+	}
+	_, _, err := PrepMails(&cfg, Templates{Text: "Role: {{.ROLE}}"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "template errors")
+	assert.Contains(t, err.Error(), "recipient 'c@d.com': body")
+	assert.NotContains(t, err.Error(), "a@b.com")
+}
@@ -0,0 +1,175 @@
+// gmt sends emails in bulk based on a template and a config file.
+// Copyright (C) 2019-2025  "Muharem Hrnjadovic" <muharem@linux.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package email
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Report formats.
+const (
+	ReportJSONL = "jsonl"
+	ReportCSV   = "csv"
+)
+
+// reportListSeparator joins list-valued CSV cells, as in recipients_csv.
+const reportListSeparator = ";"
+
+// ReportRow is the final status of one recipient.
+type ReportRow struct {
+	Recipient   string         `json:"recipient"`
+	Name        string         `json:"name"`
+	Cc          []string       `json:"cc"`
+	Attachments []string       `json:"attachments"`
+	Attempts    int            `json:"attempts"`
+	Status      DeliveryStatus `json:"status"`
+	Error       string         `json:"error,omitempty"`
+	ErrorClass  ErrorClass     `json:"error_class,omitempty"`
+	Started     time.Time      `json:"started,omitzero"`
+	Finished    time.Time      `json:"finished,omitzero"`
+	MessageID   string         `json:"message_id,omitempty"`
+}
+
+// reportColumns is the CSV header; csvRecord must match it.
+var reportColumns = []string{
+	"recipient", "name", "cc", "attachments", "attempts", "status",
+	"error", "error_class", "started", "finished", "message_id",
+}
+
+// Report writes one row per recipient as JSON lines or CSV. Each row is
+// written through to the underlying writer at once, so a report file is
+// complete up to the last finished recipient even if the run crashes. A
+// Report is safe for concurrent use.
+type Report struct {
+	mu  sync.Mutex
+	w   io.Writer
+	csv *csv.Writer // nil for JSON lines
+}
+
+// ReportFormatFor returns the report format implied by path's extension:
+// CSV for ".csv", JSON lines otherwise.
+func ReportFormatFor(path string) string {
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		return ReportCSV
+	}
+	return ReportJSONL
+}
+
+// NewReport returns a Report writing rows in format to w. For CSV, the header
+// row is written immediately.
+func NewReport(w io.Writer, format string) (*Report, error) {
+	r := &Report{w: w}
+	switch format {
+	case ReportJSONL:
+	case ReportCSV:
+		r.csv = csv.NewWriter(w)
+		if err := r.writeCSV(reportColumns); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown report format %q (want %q or %q)", format, ReportJSONL, ReportCSV)
+	}
+	return r, nil
+}
+
+// Write appends row to the report.
+func (r *Report) Write(row ReportRow) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.csv != nil {
+		return r.writeCSV(row.csvRecord())
+	}
+	line, err := json.Marshal(row)
+	if err != nil {
+		return fmt.Errorf("failed to encode report row: %w", err)
+	}
+	if _, err := r.w.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+	return nil
+}
+
+// --- internal ---
+
+func (r *Report) writeCSV(record []string) error {
+	if err := r.csv.Write(record); err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+	r.csv.Flush()
+	if err := r.csv.Error(); err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+	return nil
+}
+
+func (row ReportRow) csvRecord() []string {
+	return []string{
+		row.Recipient,
+		row.Name,
+		strings.Join(row.Cc, reportListSeparator),
+		strings.Join(row.Attachments, reportListSeparator),
+		strconv.Itoa(row.Attempts),
+		string(row.Status),
+		row.Error,
+		string(row.ErrorClass),
+		formatReportTime(row.Started),
+		formatReportTime(row.Finished),
+		row.MessageID,
+	}
+}
+
+func formatReportTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// newReportRow builds the report row for m from its delivery outcome.
+func newReportRow(m Message, d delivery) ReportRow {
+	row := ReportRow{
+		Recipient:   m.Address,
+		Name:        m.Name,
+		Cc:          m.Cc,
+		Attachments: m.Attachments,
+		Attempts:    d.attempts,
+		Status:      d.status,
+		Started:     d.started.UTC(),
+		Finished:    d.finished.UTC(),
+		MessageID:   d.messageID,
+	}
+	// Always lists, never null, for consumers that expect an array.
+	if row.Cc == nil {
+		row.Cc = []string{}
+	}
+	if row.Attachments == nil {
+		row.Attachments = []string{}
+	}
+	if d.err != nil {
+		row.Error = d.err.Error()
+		row.ErrorClass = classifyError(d.err)
+	}
+	return row
+}
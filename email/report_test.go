@@ -0,0 +1,145 @@
+// gmt sends emails in bulk based on a template and a config file.
+// Copyright (C) 2019-2025  "Muharem Hrnjadovic" <muharem@linux.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package email
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/al-maisan/gmt/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReportFormatFor(t *testing.T) {
+	assert.Equal(t, ReportCSV, ReportFormatFor("out/report.CSV"))
+	assert.Equal(t, ReportJSONL, ReportFormatFor("report.jsonl"))
+	assert.Equal(t, ReportJSONL, ReportFormatFor("report"))
+}
+
+func TestNewReportUnknownFormat(t *testing.T) {
+	_, err := NewReport(&bytes.Buffer{}, "xml")
+	assert.ErrorContains(t, err, `unknown report format "xml"`)
+}
+
+// decodeJSONL decodes one ReportRow per line of out.
+func decodeJSONL(t *testing.T, out string) []ReportRow {
+	t.Helper()
+	var rows []ReportRow
+	for line := range strings.SplitSeq(strings.TrimSpace(out), "\n") {
+		var row ReportRow
+		require.NoError(t, json.Unmarshal([]byte(line), &row), line)
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+func TestSendAllReportJSONL(t *testing.T) {
+	var out bytes.Buffer
+	report, err := NewReport(&out, ReportJSONL)
+	require.NoError(t, err)
+
+	cfg := config.MailConfig{From: "sender@example.com"}
+	msgs := []Message{
+		{Name: "John", Address: "jd@example.com", Subject: "Hi", Body: "Hello", Cc: []string{"cc@example.com"}},
+		{Name: "Jane", Address: "jane@example.com", Subject: "Hi", Body: "Hello"},
+	}
+	var buf bytes.Buffer
+	NewBatchSender(&buf, &failNthSender{failOn: 2}, cfg, SendOptions{Report: report}).SendAll(msgs)
+
+	rows := decodeJSONL(t, out.String())
+	require.Len(t, rows, 2)
+
+	john := rows[0]
+	assert.Equal(t, "jd@example.com", john.Recipient)
+	assert.Equal(t, []string{"cc@example.com"}, john.Cc)
+	assert.Equal(t, []string{}, john.Attachments)
+	assert.Equal(t, StatusSent, john.Status)
+	assert.Equal(t, 1, john.Attempts)
+	assert.Empty(t, john.Error)
+	assert.Regexp(t, `^<.+@.+>$`, john.MessageID)
+	assert.False(t, john.Started.IsZero())
+	assert.False(t, john.Finished.Before(john.Started))
+
+	jane := rows[1]
+	assert.Equal(t, StatusFailed, jane.Status)
+	assert.Contains(t, jane.Error, "simulated failure")
+	assert.Empty(t, jane.ErrorClass, "not an SMTP error")
+	assert.NotEqual(t, john.MessageID, jane.MessageID)
+}
+
+func TestSendAllReportErrorClass(t *testing.T) {
+	var out bytes.Buffer
+	report, err := NewReport(&out, ReportJSONL)
+	require.NoError(t, err)
+
+	cfg := config.MailConfig{From: "sender@example.com"}
+	msgs := []Message{{Name: "John", Address: "jd@example.com", Subject: "Hi", Body: "Hello"}}
+	sender := &mockSender{sendErr: &smtpReplyError{code: 550, esc: "5.1.1", text: "mailbox does not exist"}}
+	var buf bytes.Buffer
+	NewBatchSender(&buf, sender, cfg, SendOptions{Report: report, Retries: 2}).SendAll(msgs)
+
+	rows := decodeJSONL(t, out.String())
+	require.Len(t, rows, 1)
+	assert.Equal(t, StatusFailed, rows[0].Status)
+	assert.Equal(t, 1, rows[0].Attempts)
+	assert.Equal(t, ClassPermanent, rows[0].ErrorClass)
+	assert.Contains(t, rows[0].Error, "mailbox does not exist")
+}
+
+func TestSendAllReportCSVWithSkippedAndPending(t *testing.T) {
+	var out bytes.Buffer
+	report, err := NewReport(&out, ReportCSV)
+	require.NoError(t, err)
+
+	j := openTestJournal(t, filepath.Join(t.TempDir(), "journal.jsonl"), "run")
+	require.NoError(t, j.Record("r1@example.com", StatusSent, nil))
+
+	cfg := config.MailConfig{From: "sender@example.com"}
+	msgs := make([]Message, 4)
+	for i := range msgs {
+		msgs[i] = Message{Name: fmt.Sprintf("R%d", i+1), Address: fmt.Sprintf("r%d@example.com", i+1), Subject: "Hi", Body: "Hello"}
+	}
+	msgs[1].Attachments = []string{"/nonexistent/a.pdf", "/nonexistent/b.pdf"}
+	opts := SendOptions{Report: report, Journal: j, RateLimits: []RateLimit{{N: 3, Per: 24 * time.Hour}}}
+	var buf bytes.Buffer
+	NewBatchSender(&buf, &mockSender{}, cfg, opts).SendAll(msgs)
+
+	records, err := csv.NewReader(&out).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 5)
+	assert.Equal(t, reportColumns, records[0])
+
+	byRecipient := make(map[string][]string)
+	for _, rec := range records[1:] {
+		byRecipient[rec[0]] = rec
+	}
+	assert.Equal(t, "skipped", byRecipient["r1@example.com"][5])
+	assert.Equal(t, "failed", byRecipient["r2@example.com"][5])
+	assert.Equal(t, "/nonexistent/a.pdf;/nonexistent/b.pdf", byRecipient["r2@example.com"][3])
+	assert.Contains(t, byRecipient["r2@example.com"][6], "attachment")
+	assert.Equal(t, "sent", byRecipient["r3@example.com"][5])
+	assert.NotEmpty(t, byRecipient["r3@example.com"][8], "started")
+	assert.Equal(t, "pending", byRecipient["r4@example.com"][5], "the daily limit of 3 was used by r1 (journal), r2 and r3")
+	assert.Empty(t, byRecipient["r4@example.com"][8])
+}
@@ -17,13 +17,17 @@
 package email
 
 import (
+	"bytes"
+	"cmp"
 	"context"
 	"errors"
 	"fmt"
 	"io"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/al-maisan/gmt/config"
@@ -44,29 +48,85 @@ type SMTPCredentials struct {
 	Port     int
 	User     string
 	Password string
+
+	Auth          string // SMTP_AUTH; empty means plain
+	TLS           string // SMTP_TLS; empty means starttls
+	AllowInsecure bool   // SMTP_ALLOW_INSECURE: permit the plaintext TLS modes
+	CAFile        string // SMTP_CA_FILE: PEM bundle replacing the system roots
+	ClientCert    string // SMTP_CLIENT_CERT: PEM client certificate
+	ClientKey     string // SMTP_CLIENT_KEY: PEM key for ClientCert
+	HELO          string // SMTP_HELO: name sent in EHLO/HELO
 }
 
-// SendOptions controls rate limiting and retry behavior.
+// SendOptions controls rate limiting, retry and resume behavior.
 type SendOptions struct {
 	Delay      time.Duration // delay between messages
+	RateLimits []RateLimit   // token-bucket limits, enforced together with Delay
 	Retries    int           // max retry attempts per message
-	RetryDelay time.Duration // backoff between retries
+	RetryDelay time.Duration // backoff before the first retry, doubled for each further one
+	// RetryMaxDelay caps the retry backoff; zero means no cap.
+	RetryMaxDelay time.Duration
+	// Journal, if set, records each outcome; recipients it already marks as
+	// delivered are skipped.
+	Journal *Journal
+	// RetryFailed limits the run to recipients the journal marks as failed.
+	RetryFailed bool
+	// Report, if set, receives a row per recipient as its outcome is known.
+	Report *Report
+	// SMIME, if set, S/MIME-signs each message body. Bodies of recipients
+	// with an smime_cert are also encrypted to that certificate.
+	SMIME *SMIMESigner
+	// PGP, if set, OpenPGP-signs and/or encrypts each message body.
+	PGP *PGP
+	// DKIM, if set, signs each message once it is complete.
+	DKIM *DKIMSigner
+	// SaveSent, if set, receives a copy of each delivered message. A failure
+	// to save is reported but does not fail the delivery.
+	SaveSent *IMAPAppender
+	// Archive, if set, keeps a local copy of each delivered message. A
+	// failure to archive is reported but does not fail the delivery.
+	Archive *Archive
+	// Canary, if set, sends the canary messages first and the rest only once
+	// its Confirm allows it.
+	Canary *Canary
+	// Redirect, if set, sends every message to these test addresses instead
+	// of its real recipients; see redirectMessage.
+	Redirect []string
 }
 
 // SendResult holds the outcome of a bulk send operation.
 type SendResult struct {
-	Sent   int
-	Failed int
+	Sent        int
+	Failed      int
+	Skipped     int // already delivered (or, with RetryFailed, not failed) per the journal
+	NoKey       int // skipped for lack of an OpenPGP key (missing_key = "skip")
+	NotSaved    int // delivered, but not saved to the IMAP Sent folder
+	NotArchived int // delivered, but not written to the archive
+	// Pending holds the messages not attempted because Limit, a daily (or
+	// longer) rate limit, was exhausted; it allows sending again at ResumeAt.
+	Pending  []Message
+	Limit    RateLimit
+	ResumeAt time.Time
+	// Held holds the messages not attempted because the Canary checkpoint
+	// was not confirmed.
+	Held []Message
 }
 
-// LoadSMTPCredentials reads SMTP credentials from environment variables.
-// Returns an error listing any missing variables.
+// LoadSMTPCredentials reads SMTP credentials and the auth and TLS settings
+// from environment variables. Returns an error listing any missing variables,
+// or describing an invalid combination of settings.
 func LoadSMTPCredentials() (SMTPCredentials, error) {
 	host := os.Getenv("SMTP_HOST")
 	portStr := os.Getenv("SMTP_PORT")
 	user := os.Getenv("SENDER_EMAIL")
 	password := os.Getenv("SENDER_PASSWORD")
 
+	creds := SMTPCredentials{Host: host, User: user, Password: password}
+	if err := loadSMTPSecurity(&creds); err != nil {
+		return SMTPCredentials{}, err
+	}
+	needAuth := creds.authMechanism() != smtpAuthNone
+
 	var missing []string
 	if host == "" {
 		missing = append(missing, "SMTP_HOST")
@@ -74,10 +134,10 @@ func LoadSMTPCredentials() (SMTPCredentials, error) {
 	if portStr == "" {
 		missing = append(missing, "SMTP_PORT")
 	}
-	if user == "" {
+	if needAuth && user == "" {
 		missing = append(missing, "SENDER_EMAIL")
 	}
-	if password == "" {
+	if needAuth && password == "" {
 		missing = append(missing, "SENDER_PASSWORD")
 	}
 	if len(missing) > 0 {
@@ -88,8 +148,14 @@ func LoadSMTPCredentials() (SMTPCredentials, error) {
 	if err != nil {
 		return SMTPCredentials{}, fmt.Errorf("SMTP_PORT must be a valid integer, got %q", portStr)
 	}
+	creds.Port = port
 
-	return SMTPCredentials{Host: host, Port: port, User: user, Password: password}, nil
+	// Builds the TLS configuration too, so unreadable CA or certificate
+	// files are reported before any connection is attempted.
+	if _, err := creds.clientOptions(); err != nil {
+		return SMTPCredentials{}, err
+	}
+	return creds, nil
 }
 
 // NewSMTPSender creates a connected SMTP sender using the given credentials.
@@ -97,12 +163,9 @@ func LoadSMTPCredentials() (SMTPCredentials, error) {
 // full DATA/attachment stream); zero uses the go-mail default. The caller must
 // call Close when done.
 func NewSMTPSender(creds SMTPCredentials, timeout time.Duration) (Sender, error) {
-	opts := []mail.Option{
-		mail.WithPort(creds.Port),
-		mail.WithUsername(creds.User),
-		mail.WithPassword(creds.Password),
-		mail.WithSMTPAuth(mail.SMTPAuthPlain),
-		mail.WithTLSPolicy(mail.TLSMandatory),
+	opts, err := creds.clientOptions()
+	if err != nil {
+		return nil, err
 	}
 	if timeout > 0 {
 		opts = append(opts, mail.WithTimeout(timeout))
@@ -134,44 +197,254 @@ func CheckAttachments(msgs []Message) error {
 	return nil
 }
 
+// CheckSenders verifies that the signers, where set, can sign for every
+// message's own From address (see config.Recipient.From): DKIM alignment,
+// and the address on the S/MIME certificate or OpenPGP key. The [general]
+// from is checked when the signers are created.
+func CheckSenders(msgs []Message, dkim *DKIMSigner, smime *SMIMESigner, pgp *PGP) error {
+	var signers []interface{ CheckFrom(from string) error }
+	if dkim != nil {
+		signers = append(signers, dkim)
+	}
+	if smime != nil {
+		signers = append(signers, smime)
+	}
+	if pgp != nil {
+		signers = append(signers, pgp)
+	}
+	for _, m := range msgs {
+		if m.From == "" {
+			continue
+		}
+		for _, s := range signers {
+			if err := s.CheckFrom(m.From); err != nil {
+				return fmt.Errorf("from for recipient %s: %w", m.Address, err)
+			}
+		}
+	}
+	return nil
+}
+
 // BatchSender holds the per-batch state for delivering a set of messages.
 type BatchSender struct {
 	w       io.Writer
-	sender  Sender
-	from    string
-	replyTo string
+	senders []Sender
+	from    string // the [general] from; a message's own From replaces it
+	replyTo string // the [general] reply_to; a message's own ReplyTo replaces it
 	opts    SendOptions
 }
 
 // NewBatchSender creates a BatchSender for delivering a batch of messages.
 func NewBatchSender(w io.Writer, sender Sender, cfg config.MailConfig, opts SendOptions) *BatchSender {
-	return &BatchSender{w: w, sender: sender, from: cfg.From, replyTo: cfg.ReplyTo, opts: opts}
+	return NewPooledBatchSender(w, []Sender{sender}, cfg, opts)
+}
+
+// NewPooledBatchSender creates a BatchSender that delivers over several
+// connections at once, one worker per sender. opts.Delay is then a global
+// rate: the gap between any two sends, whichever connection they use.
+func NewPooledBatchSender(w io.Writer, senders []Sender, cfg config.MailConfig, opts SendOptions) *BatchSender {
+	return &BatchSender{w: w, senders: senders, from: cfg.From, replyTo: cfg.ReplyTo, opts: opts}
 }
 
 // SendAll delivers all messages, logging progress to w.
-// Per-message errors do not stop the batch.
+// Per-message errors do not stop the batch. With several senders, each
+// message's output is written as one block once it completes; the [i/N]
+// prefix is the message's position in msgs.
 func (sc *BatchSender) SendAll(msgs []Message) SendResult {
+	var result SendResult
+	msgs, skipped := sc.pending(msgs)
+	if result.Skipped = len(skipped); result.Skipped > 0 {
+		logf(sc.w, "Journal: skipping %d recipient(s) %s\n", result.Skipped, sc.skipReason())
+		sc.report(skipped, StatusSkipped)
+	}
+	if sc.opts.PGP != nil {
+		var noKey []Message
+		msgs, noKey = sc.opts.PGP.withoutKeys(msgs)
+		if result.NoKey = len(noKey); result.NoKey > 0 {
+			logf(sc.w, "OpenPGP: skipping %d recipient(s) without a key\n", result.NoKey)
+			sc.report(noKey, StatusSkipped)
+		}
+	}
+
+	stage := len(msgs) // messages in the first stage
+	if sc.opts.Canary != nil {
+		msgs, stage = sc.opts.Canary.split(msgs)
+	}
 	total := len(msgs)
 	width := len(fmt.Sprintf("%d", total))
-	var result SendResult
-	for i, m := range msgs {
-		prefix := fmt.Sprintf("[%*d/%*d]", width, i+1, width, total)
+	limit := newLimiter(sc.opts.RateLimits, sc.opts.Delay)
+	if sc.opts.Journal != nil {
+		limit.seed(sc.opts.Journal.deliveryTimes())
+	}
+	buffered := len(sc.senders) > 1
+
+	var mu sync.Mutex // guards result and sc.w
+	var pending []int
+	jobs := make(chan int)
+	var inFlight sync.WaitGroup // jobs of the current stage not yet done
+	var wg sync.WaitGroup
+	for _, sender := range sc.senders[:min(len(sc.senders), total)] {
+		wg.Go(func() {
+			wk := &worker{BatchSender: sc, sender: sender, w: sc.w}
+			var buf bytes.Buffer
+			for i := range jobs {
+				m := msgs[i]
+				prefix := fmt.Sprintf("[%*d/%*d]", width, i+1, width, total)
+				if buffered {
+					buf.Reset()
+					wk.w = &buf
+				}
 
-		if err := sc.sendOne(m, prefix); err != nil {
-			result.Failed++
-		} else {
-			result.Sent++
+				wait, waitFor, exhausted, resume := limit.reserve()
+				if exhausted != nil {
+					mu.Lock()
+					if len(pending) == 0 {
+						logf(sc.w, "Rate limit %s exhausted: stopping, sending can resume at %s\n", exhausted, resume.Local().Format(time.DateTime))
+						result.Limit, result.ResumeAt = *exhausted, resume
+					}
+					pending = append(pending, i)
+					mu.Unlock()
+					inFlight.Done()
+					continue
+				}
+				if waitFor != nil && wait >= time.Second {
+					mu.Lock()
+					logf(sc.w, "%s   rate limit %s: waiting %s\n", prefix, waitFor, wait.Round(time.Second))
+					mu.Unlock()
+				}
+				time.Sleep(wait)
+
+				d := wk.sendOne(m, prefix)
+				wk.record(m, d, prefix)
+
+				mu.Lock()
+				if d.status.delivered() {
+					result.Sent++
+					if d.saveErr != nil {
+						result.NotSaved++
+					}
+					if d.archiveErr != nil {
+						result.NotArchived++
+					}
+				} else {
+					result.Failed++
+				}
+				if buffered {
+					sc.w.Write(buf.Bytes()) //nolint:errcheck
+				}
+				mu.Unlock()
+				inFlight.Done()
+			}
+		})
+	}
+	send := func(from, to int) {
+		inFlight.Add(to - from)
+		for i := from; i < to; i++ {
+			jobs <- i
 		}
+		inFlight.Wait()
+	}
+	send(0, stage)
+	if stage < total && !sc.checkpoint(result, total-stage, len(pending) > 0) {
+		result.Held = msgs[stage:]
+		total = stage
+	}
+	send(stage, total)
+	close(jobs)
+	wg.Wait()
 
-		if sc.opts.Delay > 0 && i < total-1 {
-			time.Sleep(sc.opts.Delay)
-		}
+	slices.Sort(pending)
+	for _, i := range pending {
+		result.Pending = append(result.Pending, msgs[i])
 	}
+	sc.report(result.Pending, StatusPending)
+	sc.report(result.Held, StatusPending)
 	return result
 }
 
 // --- internal ---
 
+// pending splits msgs into those still to send according to the journal and
+// those skipped.
+func (sc *BatchSender) pending(msgs []Message) (todo, skipped []Message) {
+	j := sc.opts.Journal
+	if j == nil {
+		return msgs, nil
+	}
+	for _, m := range msgs {
+		status, ok := j.Status(m.Address)
+		switch {
+		case sc.opts.RetryFailed && (!ok || status != StatusFailed):
+			skipped = append(skipped, m)
+		case !sc.opts.RetryFailed && ok && status.delivered():
+			skipped = append(skipped, m)
+		default:
+			todo = append(todo, m)
+		}
+	}
+	return todo, skipped
+}
+
+// report writes a row with the given status for each of msgs, which were not
+// attempted in this run.
+func (sc *BatchSender) report(msgs []Message, status DeliveryStatus) {
+	if sc.opts.Report == nil {
+		return
+	}
+	for _, m := range msgs {
+		if err := sc.opts.Report.Write(newReportRow(m, delivery{status: status})); err != nil {
+			logf(sc.w, "warning: %v\n", err)
+			return
+		}
+	}
+}
+
+// checkpoint is reached once the canary messages are done; it reports whether
+// to go on with the remaining messages. A rate limit that ran out during the
+// canary leaves nothing to confirm: the rest will be pending anyway.
+func (sc *BatchSender) checkpoint(result SendResult, remaining int, exhausted bool) bool {
+	if exhausted {
+		return true
+	}
+	logf(sc.w, "Canary: %d sent, %d failed; %d recipient(s) remaining\n", result.Sent, result.Failed, remaining)
+	if sc.opts.Canary.Confirm != nil && sc.opts.Canary.Confirm(result, remaining) {
+		return true
+	}
+	logf(sc.w, "Canary: holding back %d recipient(s)\n", remaining)
+	return false
+}
+
+func (sc *BatchSender) skipReason() string {
+	if sc.opts.RetryFailed {
+		return "not marked as failed"
+	}
+	return "already sent"
+}
+
+// record journals and reports the outcome for m. A write failure is reported
+// but does not stop the batch: the message itself went out (or failed) either
+// way.
+func (wk *worker) record(m Message, d delivery, prefix string) {
+	if wk.opts.Journal != nil {
+		if err := wk.opts.Journal.Record(m.Address, d.status, d.err); err != nil {
+			logf(wk.w, "%s   warning: %v\n", prefix, err)
+		}
+	}
+	if wk.opts.Report != nil {
+		if err := wk.opts.Report.Write(newReportRow(m, d)); err != nil {
+			logf(wk.w, "%s   warning: %v\n", prefix, err)
+		}
+	}
+}
+
+// worker delivers messages over one connection of a BatchSender, logging to w
+// (the batch output, or a per-message buffer when sending concurrently).
+type worker struct {
+	*BatchSender
+	sender Sender
+	w      io.Writer
+}
+
 // logf writes a formatted message to w, ignoring write errors
 // (output is best-effort and must not interrupt the send loop).
 func logf(w io.Writer, format string, args ...any) {
@@ -193,74 +466,171 @@ func (s *smtpSender) Reconnect() error {
 	return s.client.DialWithContext(context.Background())
 }
 
-// sendOne prepares and sends a single message, with retries.
-func (sc *BatchSender) sendOne(m Message, prefix string) error {
+// maxSize returns the server's SIZE limit. go-mail does not expose the EHLO
+// reply of its session, so this opens a second, short one.
+func (s *smtpSender) maxSize() (int64, error) {
+	c, err := s.client.DialToSMTPClientWithContext(context.Background())
+	if err != nil {
+		return 0, fmt.Errorf("failed to query the SMTP SIZE limit: %w", err)
+	}
+	defer s.client.CloseWithSMTPClient(c) //nolint:errcheck
+	ok, param := c.Extension("SIZE")
+	if !ok {
+		return 0, nil
+	}
+	return parseSizeParam(param), nil
+}
+
+// delivery is the outcome of sending one message.
+type delivery struct {
+	status     DeliveryStatus
+	err        error // see sendOne
+	attempts   int
+	messageID  string
+	started    time.Time
+	finished   time.Time
+	saveErr    error // failure to save a copy with SendOptions.SaveSent
+	archiveErr error // failure to archive with SendOptions.Archive
+}
+
+// sendOne prepares and sends a single message, with retries. The delivery's
+// err is non-nil for StatusFailed and, with StatusDeliveredDespiteError, is the
+// error the server reported after accepting the message.
+func (wk *worker) sendOne(m Message, prefix string) (d delivery) {
+	d = delivery{status: StatusFailed, started: time.Now()}
+	defer func() { d.finished = time.Now() }()
 	recipient := fmt.Sprintf("%s <%s>", m.Name, m.Address)
 
-	msg, err := createMessage(sc.from, m.Name, m.Address, m.Cc, sc.replyTo, m.Subject, m.Body)
+	msg, err := createMessage(cmp.Or(m.From, wk.from), cmp.Or(m.ReplyTo, wk.replyTo), m)
 	if err != nil {
-		logf(sc.w, "%s ! %s (failed to create: %v)\n", prefix, recipient, err)
-		return err
+		logf(wk.w, "%s ! %s (failed to create: %v)\n", prefix, recipient, err)
+		d.err = err
+		return d
+	}
+	d.messageID = msg.GetMessageID()
+	if len(wk.opts.Redirect) > 0 {
+		if err := redirectMessage(msg, m, wk.opts.Redirect); err != nil {
+			logf(wk.w, "%s ! %s (failed to redirect: %v)\n", prefix, recipient, err)
+			d.err = err
+			return d
+		}
 	}
 
 	if err := attachFiles(msg, m.Attachments); err != nil {
-		logf(sc.w, "%s ! %s (failed to attach: %v)\n", prefix, recipient, err)
-		return err
+		logf(wk.w, "%s ! %s (failed to attach: %v)\n", prefix, recipient, err)
+		d.err = err
+		return d
+	}
+	if wk.opts.SMIME != nil || m.SMIMECert != "" {
+		if err := protectSMIME(msg, wk.opts.SMIME, m.SMIMECert, m.Address); err != nil {
+			logf(wk.w, "%s ! %s (failed to apply S/MIME: %v)\n", prefix, recipient, err)
+			d.err = err
+			return d
+		}
+	}
+	if wk.opts.PGP != nil {
+		if err := wk.opts.PGP.protect(msg, m); err != nil {
+			logf(wk.w, "%s ! %s (failed to apply OpenPGP: %v)\n", prefix, recipient, err)
+			d.err = err
+			return d
+		}
+	}
+	if wk.opts.DKIM != nil {
+		if err := wk.opts.DKIM.Sign(msg); err != nil {
+			logf(wk.w, "%s ! %s (failed to sign: %v)\n", prefix, recipient, err)
+			d.err = err
+			return d
+		}
 	}
 
-	if err := sc.sendWithRetry(msg, prefix, recipient); err != nil {
-		return err
+	d.status, d.attempts, d.err = wk.sendWithRetry(msg, prefix, recipient)
+	if d.status == StatusFailed {
+		return d
 	}
 
-	logf(sc.w, "%s - %s\n", prefix, recipient)
+	logf(wk.w, "%s - %s\n", prefix, recipient)
+	if d.status == StatusDeliveredDespiteError {
+		logf(wk.w, "  delivered, but the server then reported: %v\n", d.err)
+	}
 	if len(m.Cc) > 0 {
-		logf(sc.w, "  Cc: %s\n", strings.Join(m.Cc, ", "))
+		logf(wk.w, "  Cc: %s\n", strings.Join(m.Cc, ", "))
+	}
+	if len(m.Bcc) > 0 && len(wk.opts.Redirect) == 0 {
+		logf(wk.w, "  Bcc: %s\n", strings.Join(m.Bcc, ", "))
 	}
 	if len(m.Attachments) > 0 {
-		logf(sc.w, "  Attachments: %s\n", strings.Join(m.Attachments, ", "))
+		logf(wk.w, "  Attachments: %s\n", strings.Join(m.Attachments, ", "))
 	}
-	return nil
+	if len(wk.opts.Redirect) > 0 {
+		logf(wk.w, "  Redirected to: %s\n", strings.Join(wk.opts.Redirect, ", "))
+	}
+	wk.keepCopies(msg, m, &d)
+	return d
 }
 
-// sendWithRetry attempts to send msg, retrying up to opts.Retries times with
-// opts.RetryDelay between attempts. When a failure looks like a dropped
-// connection it re-dials before the next attempt, so a server that closes the
-// connection mid-batch (idle timeout, per-connection message cap) does not doom
-// every remaining recipient.
-func (sc *BatchSender) sendWithRetry(msg *mail.Msg, prefix, recipient string) error {
+// keepCopies saves delivered msg to the IMAP Sent folder and the archive, as
+// configured, recording and logging failures in d.
+func (wk *worker) keepCopies(msg *mail.Msg, m Message, d *delivery) {
+	if wk.opts.SaveSent != nil {
+		if d.saveErr = wk.opts.SaveSent.Append(msg); d.saveErr != nil {
+			logf(wk.w, "  warning: %v\n", d.saveErr)
+		}
+	}
+	if wk.opts.Archive != nil {
+		if d.archiveErr = wk.opts.Archive.Add(msg, m.Address); d.archiveErr != nil {
+			logf(wk.w, "  warning: %v\n", d.archiveErr)
+		}
+	}
+}
+
+// sendWithRetry attempts to send msg, retrying up to opts.Retries times, and
+// returns the outcome and the number of attempts made. A permanent (5xx)
+// rejection is not retried; other failures are retried after an exponential
+// backoff from opts.RetryDelay, capped at opts.RetryMaxDelay. When a failure
+// looks like a dropped connection it re-dials before the next attempt, so a
+// server that closes the connection mid-batch (idle timeout, per-connection
+// message cap) does not doom every remaining recipient.
+func (wk *worker) sendWithRetry(msg *mail.Msg, prefix, recipient string) (DeliveryStatus, int, error) {
 	var err error
 	// Always attempt at least once; a negative Retries must never silently
 	// skip the send and report success.
-	attempts := max(sc.opts.Retries+1, 1)
+	attempts := max(wk.opts.Retries+1, 1)
 	for attempt := range attempts {
 		if attempt > 0 {
-			logf(sc.w, "%s   retrying %s...\n", prefix, recipient)
+			wait := backoff(wk.opts.RetryDelay, wk.opts.RetryMaxDelay, attempt)
+			logf(wk.w, "%s   retrying %s in %s after [%s] error...\n", prefix, recipient, wait.Round(time.Millisecond), errorLabel(err))
 			if isConnectionError(err) {
-				if rcErr := sc.sender.Reconnect(); rcErr != nil {
-					logf(sc.w, "%s   reconnect failed: %v\n", prefix, rcErr)
+				if rcErr := wk.sender.Reconnect(); rcErr != nil {
+					logf(wk.w, "%s   reconnect failed: %v\n", prefix, rcErr)
 				}
 			}
-			if sc.opts.RetryDelay > 0 {
-				time.Sleep(sc.opts.RetryDelay)
-			}
+			time.Sleep(wait)
 		}
-		err = sc.sender.Send(msg)
+		err = wk.sender.Send(msg)
 		if err == nil {
-			return nil
+			return StatusSent, attempt + 1, nil
 		}
 		// The server accepted the message but a later step (e.g. RSET) failed:
 		// it was delivered, so do not count it as failed or re-send it.
 		if deliveredDespiteError(msg, err) {
-			return nil
+			return StatusDeliveredDespiteError, attempt + 1, err
+		}
+		if classifyError(err) == ClassPermanent {
+			logf(wk.w, "%s ! %s (failed to send [%s], not retried: %v)\n", prefix, recipient, errorLabel(err), err)
+			return StatusFailed, attempt + 1, err
 		}
 	}
-	logf(sc.w, "%s ! %s (failed to send: %v)\n", prefix, recipient, err)
-	return err
+	logf(wk.w, "%s ! %s (failed to send [%s]: %v)\n", prefix, recipient, errorLabel(err), err)
+	return StatusFailed, attempts, err
 }
 
-// isConnectionError reports whether err indicates the SMTP connection is dead
-// or temporarily unusable, so a reconnect should precede the next attempt.
+// isConnectionError reports whether err indicates the connection is dead or
+// temporarily unusable, so a reconnect should precede the next attempt.
 func isConnectionError(err error) bool {
+	var ce *connError
+	if errors.As(err, &ce) {
+		return true
+	}
 	var se *mail.SendError
 	if errors.As(err, &se) {
 		return se.Reason == mail.ErrConnCheck || se.IsTemp()
@@ -269,37 +639,59 @@ func isConnectionError(err error) bool {
 }
 
 // deliveredDespiteError reports whether the server actually accepted msg even
-// though Send returned an error (e.g. a post-delivery RSET failure).
+// though Send returned an error (e.g. a post-delivery RSET failure, or an
+// LMTP delivery that only some recipients rejected).
 func deliveredDespiteError(msg *mail.Msg, err error) bool {
 	if msg.IsDelivered() {
 		return true
 	}
+	var pe *partialDeliveryError
+	if errors.As(err, &pe) {
+		return true
+	}
 	var se *mail.SendError
 	return errors.As(err, &se) && se.Reason == mail.ErrSMTPReset
 }
 
-// createMessage builds a single email message with the given headers and body.
-func createMessage(from, toName, toAddr string, cc []string, replyTo, subject, body string) (*mail.Msg, error) {
-	m := mail.NewMsg()
-	if err := m.From(from); err != nil {
+// createMessage builds a single email message for m with the given From and
+// Reply-To headers. A message with an HTML body is sent as
+// multipart/alternative with m.Body as the plain-text part.
+func createMessage(from, replyTo string, m Message) (*mail.Msg, error) {
+	msg := mail.NewMsg()
+	if err := msg.From(from); err != nil {
 		return nil, fmt.Errorf("invalid From address %q: %w", from, err)
 	}
-	if err := m.AddToFormat(toName, toAddr); err != nil {
-		return nil, fmt.Errorf("invalid To address %q: %w", toAddr, err)
+	if err := msg.AddToFormat(m.Name, m.Address); err != nil {
+		return nil, fmt.Errorf("invalid To address %q: %w", m.Address, err)
+	}
+	if len(m.Cc) > 0 {
+		if err := msg.Cc(m.Cc...); err != nil {
+			return nil, fmt.Errorf("invalid Cc address(es) %v: %w", m.Cc, err)
+		}
 	}
-	if len(cc) > 0 {
-		if err := m.Cc(cc...); err != nil {
-			return nil, fmt.Errorf("invalid Cc address(es) %v: %w", cc, err)
+	if len(m.Bcc) > 0 {
+		if err := msg.Bcc(m.Bcc...); err != nil {
+			return nil, fmt.Errorf("invalid Bcc address(es) %v: %w", m.Bcc, err)
 		}
 	}
 	if replyTo != "" {
-		if err := m.ReplyTo(replyTo); err != nil {
+		if err := msg.ReplyTo(replyTo); err != nil {
 			return nil, fmt.Errorf("invalid Reply-To address %q: %w", replyTo, err)
 		}
 	}
-	m.Subject(subject)
-	m.SetBodyString(mail.TypeTextPlain, body)
-	return m, nil
+	msg.Subject(m.Subject)
+	// Set up front (rather than when the message is written) so the report can
+	// name the Message-ID even if sending fails.
+	msg.SetMessageID()
+	setUnsubscribeHeaders(msg, m)
+	for name, value := range m.Headers {
+		msg.SetGenHeader(mail.Header(name), value)
+	}
+	msg.SetBodyString(mail.TypeTextPlain, m.Body)
+	if m.HTMLBody != "" {
+		msg.AddAlternativeString(mail.TypeTextHTML, m.HTMLBody)
+	}
+	return msg, nil
 }
 
 // attachFiles adds the given file paths as attachments to msg, verifying
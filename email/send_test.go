@@ -21,7 +21,9 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/al-maisan/gmt/config"
 	"github.com/stretchr/testify/assert"
@@ -31,9 +33,8 @@ import (
 
 func TestCreateMessage(t *testing.T) {
 	msg, err := createMessage(
-		"sender@example.com",
-		"John Doe", "jd@example.com",
-		nil, "", "Hello!", "Body text",
+		"sender@example.com", "",
+		Message{Name: "John Doe", Address: "jd@example.com", Subject: "Hello!", Body: "Body text"},
 	)
 	require.NoError(t, err)
 	assert.NotNil(t, msg)
@@ -41,11 +42,8 @@ func TestCreateMessage(t *testing.T) {
 
 func TestCreateMessageWithCcAndReplyTo(t *testing.T) {
 	msg, err := createMessage(
-		"sender@example.com",
-		"John Doe", "jd@example.com",
-		[]string{"cc1@example.com", "cc2@example.com"},
-		"reply@example.com",
-		"Test", "Body",
+		"sender@example.com", "reply@example.com",
+		Message{Name: "John Doe", Address: "jd@example.com", Cc: []string{"cc1@example.com", "cc2@example.com"}, Subject: "Test", Body: "Body"},
 	)
 	require.NoError(t, err)
 	assert.NotNil(t, msg)
@@ -53,9 +51,8 @@ func TestCreateMessageWithCcAndReplyTo(t *testing.T) {
 
 func TestCreateMessageUTF8Name(t *testing.T) {
 	msg, err := createMessage(
-		"sender@example.com",
-		"abc ähm", "abc@example.com",
-		nil, "", "Hello!", "Body",
+		"sender@example.com", "",
+		Message{Name: "abc ähm", Address: "abc@example.com", Subject: "Hello!", Body: "Body"},
 	)
 	require.NoError(t, err)
 	assert.NotNil(t, msg)
@@ -63,9 +60,8 @@ func TestCreateMessageUTF8Name(t *testing.T) {
 
 func TestCreateMessageInvalidFrom(t *testing.T) {
 	_, err := createMessage(
-		"not-an-email",
-		"A", "a@b.com",
-		nil, "", "s", "b",
+		"not-an-email", "",
+		Message{Name: "A", Address: "a@b.com", Subject: "s", Body: "b"},
 	)
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "invalid From")
@@ -73,16 +69,15 @@ func TestCreateMessageInvalidFrom(t *testing.T) {
 
 func TestCreateMessageInvalidTo(t *testing.T) {
 	_, err := createMessage(
-		"sender@example.com",
-		"A", "not-an-email",
-		nil, "", "s", "b",
+		"sender@example.com", "",
+		Message{Name: "A", Address: "not-an-email", Subject: "s", Body: "b"},
 	)
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "invalid To")
 }
 
 func TestAttachFilesNonexistent(t *testing.T) {
-	msg, err := createMessage("s@s.com", "A", "a@b.com", nil, "", "s", "b")
+	msg, err := createMessage("s@s.com", "", Message{Name: "A", Address: "a@b.com", Subject: "s", Body: "b"})
 	require.NoError(t, err)
 	err = attachFiles(msg, []string{"/nonexistent/file.txt"})
 	assert.Error(t, err)
@@ -90,7 +85,7 @@ func TestAttachFilesNonexistent(t *testing.T) {
 }
 
 func TestAttachFilesEmpty(t *testing.T) {
-	msg, err := createMessage("s@s.com", "A", "a@b.com", nil, "", "s", "b")
+	msg, err := createMessage("s@s.com", "", Message{Name: "A", Address: "a@b.com", Subject: "s", Body: "b"})
 	require.NoError(t, err)
 	assert.NoError(t, attachFiles(msg, nil))
 }
@@ -131,11 +126,8 @@ func TestLoadSMTPCredentials(t *testing.T) {
 
 func TestCreateMessageWithReplyTo(t *testing.T) {
 	msg, err := createMessage(
-		"sender@example.com",
-		"John Doe", "jd@example.com",
-		nil,
-		`"Support" <support@example.com>`,
-		"Test", "Body",
+		"sender@example.com", `"Support" <support@example.com>`,
+		Message{Name: "John Doe", Address: "jd@example.com", Subject: "Test", Body: "Body"},
 	)
 	require.NoError(t, err)
 	assert.NotNil(t, msg)
@@ -143,10 +135,8 @@ func TestCreateMessageWithReplyTo(t *testing.T) {
 
 func TestCreateMessageInvalidCc(t *testing.T) {
 	_, err := createMessage(
-		"sender@example.com",
-		"A", "a@b.com",
-		[]string{"not-an-email"}, "",
-		"s", "b",
+		"sender@example.com", "",
+		Message{Name: "A", Address: "a@b.com", Cc: []string{"not-an-email"}, Subject: "s", Body: "b"},
 	)
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "invalid Cc")
@@ -154,17 +144,15 @@ func TestCreateMessageInvalidCc(t *testing.T) {
 
 func TestCreateMessageInvalidReplyTo(t *testing.T) {
 	_, err := createMessage(
-		"sender@example.com",
-		"A", "a@b.com",
-		nil, "not-an-email",
-		"s", "b",
+		"sender@example.com", "not-an-email",
+		Message{Name: "A", Address: "a@b.com", Subject: "s", Body: "b"},
 	)
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "invalid Reply-To")
 }
 
 func TestAttachFilesValid(t *testing.T) {
-	msg, err := createMessage("s@s.com", "A", "a@b.com", nil, "", "s", "b")
+	msg, err := createMessage("s@s.com", "", Message{Name: "A", Address: "a@b.com", Subject: "s", Body: "b"})
 	require.NoError(t, err)
 
 	tmpFile := t.TempDir() + "/test.txt"
@@ -494,3 +482,118 @@ func TestLoadSMTPCredentialsValid(t *testing.T) {
 	assert.Equal(t, "user@example.com", creds.User)
 	assert.Equal(t, "secret", creds.Password)
 }
+
+// poolSender is a goroutine-safe Sender that counts sends and fails those to
+// addresses in failFor.
+type poolSender struct {
+	mu      sync.Mutex
+	sent    int
+	failFor map[string]bool
+	delay   time.Duration
+}
+
+func (p *poolSender) Send(msg *mail.Msg) error {
+	time.Sleep(p.delay)
+	to := msg.GetTo()[0].Address
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.failFor[to] {
+		return fmt.Errorf("rejected %s", to)
+	}
+	p.sent++
+	return nil
+}
+func (p *poolSender) Reconnect() error { return nil }
+func (p *poolSender) Close() error     { return nil }
+
+func TestSendAllPool(t *testing.T) {
+	fail := map[string]bool{"r3@example.com": true, "r7@example.com": true}
+	senders := []*poolSender{{failFor: fail, delay: 5 * time.Millisecond}, {failFor: fail, delay: 5 * time.Millisecond}, {failFor: fail, delay: 5 * time.Millisecond}}
+	cfg := config.MailConfig{From: "sender@example.com"}
+	msgs := make([]Message, 10)
+	for i := range msgs {
+		msgs[i] = Message{Name: fmt.Sprintf("R%d", i+1), Address: fmt.Sprintf("r%d@example.com", i+1), Subject: "Hi", Body: "Hello", Cc: []string{"cc@example.com"}}
+	}
+
+	var buf bytes.Buffer
+	result := NewPooledBatchSender(&buf, []Sender{senders[0], senders[1], senders[2]}, cfg, SendOptions{}).SendAll(msgs)
+	assert.Equal(t, SendResult{Sent: 8, Failed: 2}, result)
+
+	total := 0
+	for _, s := range senders {
+		assert.Positive(t, s.sent, "every connection should carry some of the load")
+		total += s.sent
+	}
+	assert.Equal(t, 8, total)
+
+	// Each message's lines form one block with its own [i/N] prefix.
+	out := buf.String()
+	for i := 1; i <= 10; i++ {
+		assert.Equal(t, 1, strings.Count(out, fmt.Sprintf("[%2d/10]", i)))
+	}
+	lines := strings.Split(out, "\n")
+	for i, line := range lines {
+		if strings.Contains(line, "] - ") {
+			assert.Equal(t, "  Cc: cc@example.com", lines[i+1], "Cc line must follow its own recipient")
+		}
+	}
+}
+
+func TestSendAllPoolGlobalDelay(t *testing.T) {
+	senders := []Sender{&poolSender{}, &poolSender{}, &poolSender{}}
+	cfg := config.MailConfig{From: "sender@example.com"}
+	msgs := make([]Message, 4)
+	for i := range msgs {
+		msgs[i] = Message{Name: "R", Address: fmt.Sprintf("r%d@example.com", i+1), Subject: "Hi", Body: "Hello"}
+	}
+
+	start := time.Now()
+	var buf bytes.Buffer
+	result := NewPooledBatchSender(&buf, senders, cfg, SendOptions{Delay: 20 * time.Millisecond}).SendAll(msgs)
+	assert.Equal(t, 4, result.Sent)
+	assert.GreaterOrEqual(t, time.Since(start), 60*time.Millisecond,
+		"the delay applies across connections, not per connection")
+}
+
+func TestSendAllPoolMoreSendersThanMessages(t *testing.T) {
+	senders := []Sender{&poolSender{}, &poolSender{}, &poolSender{}}
+	cfg := config.MailConfig{From: "sender@example.com"}
+	msgs := []Message{{Name: "John", Address: "jd@example.com", Subject: "Hi", Body: "Hello"}}
+
+	var buf bytes.Buffer
+	result := NewPooledBatchSender(&buf, senders, cfg, SendOptions{}).SendAll(msgs)
+	assert.Equal(t, SendResult{Sent: 1}, result)
+	assert.Contains(t, buf.String(), "[1/1] - John <jd@example.com>")
+}
+
+func TestSendAllPerMessageFrom(t *testing.T) {
+	sender := &renderingSender{}
+	var out bytes.Buffer
+	cfg := config.MailConfig{From: "news@example.com", ReplyTo: "help@example.com"}
+	result := NewBatchSender(&out, sender, cfg, SendOptions{}).SendAll([]Message{
+		{Address: "a@example.com", Subject: "Hi", Body: "Hello"},
+		{Address: "b@example.com", Subject: "Hi", Body: "Hello", From: "Ann <ann@example.com>", ReplyTo: "ann@example.com"},
+	})
+	require.Equal(t, 2, result.Sent)
+	assert.Contains(t, string(sender.sent[0]), "From: <news@example.com>\r\n")
+	assert.Contains(t, string(sender.sent[0]), "Reply-To: <help@example.com>\r\n")
+	assert.Contains(t, string(sender.sent[1]), "From: \"Ann\" <ann@example.com>\r\n")
+	assert.Contains(t, string(sender.sent[1]), "Reply-To: <ann@example.com>\r\n")
+}
+
+func TestCheckSenders(t *testing.T) {
+	keyPath, _ := writeDKIMKey(t)
+	dkim, err := NewDKIMSigner(config.DKIMConfig{PrivateKey: keyPath, Selector: "gmt"}, "news@example.com")
+	require.NoError(t, err)
+
+	msgs := []Message{
+		{Address: "a@example.org"},
+		{Address: "b@example.org", From: "Ann <ann@emea.example.com>"},
+	}
+	require.NoError(t, CheckSenders(msgs, dkim, nil, nil))
+	require.NoError(t, CheckSenders(msgs, nil, nil, nil))
+
+	msgs = append(msgs, Message{Address: "c@example.org", From: "bob@example.net"})
+	err = CheckSenders(msgs, dkim, nil, nil)
+	assert.ErrorContains(t, err, `from for recipient c@example.org: dkim: signing domain "example.com" does not match the From domain "example.net"`)
+}
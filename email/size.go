@@ -0,0 +1,165 @@
+// gmt sends emails in bulk based on a template and a config file.
+// Copyright (C) 2019-2025  "Muharem Hrnjadovic" <muharem@linux.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package email
+
+import (
+	"cmp"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/al-maisan/gmt/config"
+)
+
+// signatureOverhead is a generous allowance for the DKIM, S/MIME or OpenPGP
+// signature (with its certificate chain) added to a message.
+const signatureOverhead = 8 << 10
+
+// sizeUnits maps the unit suffixes accepted by ParseSize to byte counts.
+var sizeUnits = map[string]int64{
+	"": 1, "b": 1,
+	"k": 1e3, "kb": 1e3, "kib": 1 << 10,
+	"m": 1e6, "mb": 1e6, "mib": 1 << 20,
+	"g": 1e9, "gb": 1e9, "gib": 1 << 30,
+}
+
+// sizeLimited is implemented by Senders whose server may advertise a maximum
+// message size (the SIZE extension of RFC 1870).
+type sizeLimited interface {
+	maxSize() (int64, error)
+}
+
+// ParseSize parses a message size such as "25MB", "10MiB", "512k" or a plain
+// byte count. KB, MB and GB are powers of 1000; KiB, MiB and GiB powers of
+// 1024.
+func ParseSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	digits := strings.IndexFunc(s, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+	if digits < 0 {
+		digits = len(s)
+	}
+	unit, ok := sizeUnits[strings.ToLower(strings.TrimSpace(s[digits:]))]
+	if !ok {
+		return 0, fmt.Errorf("invalid size %q: unknown unit %q", s, s[digits:])
+	}
+	n, err := strconv.ParseFloat(s[:digits], 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid size %q: want a positive number with an optional unit, e.g. 25MB", s)
+	}
+	return int64(n * float64(unit)), nil
+}
+
+// FormatSize formats a byte count for humans, e.g. "25.3 MB".
+func FormatSize(n int64) string {
+	switch {
+	case n >= 1e6:
+		return fmt.Sprintf("%.1f MB", float64(n)/1e6)
+	case n >= 1e3:
+		return fmt.Sprintf("%.1f kB", float64(n)/1e3)
+	default:
+		return fmt.Sprintf("%d bytes", n)
+	}
+}
+
+// MessageSizes returns the encoded size of each of msgs as it would go over
+// the wire, base64-encoded attachments included, without reading the
+// attachments: their encoded size follows from the file size. Signed and
+// encrypted messages are estimated generously, since their exact size is only
+// known once the signature is made.
+func MessageSizes(msgs []Message, cfg config.MailConfig) ([]int64, error) {
+	fileSizes := make(map[string]int64)
+	sizes := make([]int64, len(msgs))
+	for i, m := range msgs {
+		size, err := messageSize(m, cfg, fileSizes)
+		if err != nil {
+			return nil, fmt.Errorf("recipient %s: %w", m.Address, err)
+		}
+		sizes[i] = size
+	}
+	return sizes, nil
+}
+
+// ServerMaxSize returns the maximum message size advertised by the server s
+// delivers to, or 0 if it advertises none or s does not deliver to a server.
+func ServerMaxSize(s Sender) (int64, error) {
+	if sl, ok := s.(sizeLimited); ok {
+		return sl.maxSize()
+	}
+	return 0, nil
+}
+
+// --- internal ---
+
+// messageSize returns the encoded size of the message for m. The message is
+// rendered with empty attachments, and the encoded size of each file is added
+// to it; fileSizes caches the file sizes across messages.
+func messageSize(m Message, cfg config.MailConfig, fileSizes map[string]int64) (int64, error) {
+	msg, err := createMessage(cmp.Or(m.From, cfg.From), cmp.Or(m.ReplyTo, cfg.ReplyTo), m)
+	if err != nil {
+		return 0, err
+	}
+	var attached int64
+	for _, path := range m.Attachments {
+		size, ok := fileSizes[path]
+		if !ok {
+			fi, err := os.Stat(path)
+			if err != nil {
+				return 0, fmt.Errorf("attachment %s: %w", path, err)
+			}
+			size = fi.Size()
+			fileSizes[path] = size
+		}
+		if err := msg.AttachReader(filepath.Base(path), strings.NewReader("")); err != nil {
+			return 0, fmt.Errorf("attachment %s: %w", path, err)
+		}
+		attached += base64Size(size)
+	}
+	data, err := renderMessage(msg)
+	if err != nil {
+		return 0, err
+	}
+	size := int64(len(data)) + attached
+
+	pgp := cfg.PGP != nil
+	if m.SMIMECert != "" || pgp && (m.PGPKey != "" || cfg.PGP.Keyring != "") {
+		// The whole body is encrypted and base64-encoded once more.
+		size = base64Size(size)
+	}
+	if cfg.DKIM != nil || cfg.SMIME != nil || m.SMIMECert != "" || pgp {
+		size += signatureOverhead
+	}
+	return size, nil
+}
+
+// base64Size returns the size of n bytes base64-encoded in lines of 76
+// characters, as in a MIME part.
+func base64Size(n int64) int64 {
+	encoded := (n + 2) / 3 * 4
+	return encoded + (encoded+75)/76*2
+}
+
+// parseSizeParam returns the limit of a SIZE extension parameter; no
+// parameter, or 0, means no fixed limit.
+func parseSizeParam(param string) int64 {
+	n, err := strconv.ParseInt(strings.TrimSpace(param), 10, 64)
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
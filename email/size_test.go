@@ -0,0 +1,165 @@
+// gmt sends emails in bulk based on a template and a config file.
+// Copyright (C) 2019-2025  "Muharem Hrnjadovic" <muharem@linux.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package email
+
+import (
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/al-maisan/gmt/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSize(t *testing.T) {
+	for in, want := range map[string]int64{
+		"1048576": 1048576,
+		"512k":    512_000,
+		"25MB":    25_000_000,
+		"25 mb":   25_000_000,
+		"10MiB":   10 << 20,
+		"1.5GB":   1_500_000_000,
+		"100B":    100,
+	} {
+		got, err := ParseSize(in)
+		require.NoError(t, err, in)
+		assert.Equal(t, want, got, in)
+	}
+	for _, in := range []string{"", "MB", "0", "-5MB", "25XB", "1.2.3MB"} {
+		_, err := ParseSize(in)
+		assert.Error(t, err, in)
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	assert.Equal(t, "512 bytes", FormatSize(512))
+	assert.Equal(t, "1.5 kB", FormatSize(1500))
+	assert.Equal(t, "25.3 MB", FormatSize(25_300_000))
+}
+
+func TestMessageSizes(t *testing.T) {
+	dir := t.TempDir()
+	small := filepath.Join(dir, "notes.txt")
+	require.NoError(t, os.WriteFile(small, []byte("attached\n"), 0o600))
+	large := filepath.Join(dir, "scan.pdf")
+	require.NoError(t, os.WriteFile(large, []byte(strings.Repeat("0123456789", 100_001)), 0o600))
+
+	cfg := config.MailConfig{From: "sender@example.com", ReplyTo: "reply@example.com"}
+	msgs := []Message{
+		{Name: "Jane", Address: "jane@example.com", Subject: "Hi", Body: "Hello", Cc: []string{"cc@example.com"}},
+		{Name: "Joe", Address: "joe@example.com", Subject: "Hi", Body: "Hello", HTMLBody: "<p>Hello</p>", Attachments: []string{small, large}},
+	}
+	sizes, err := MessageSizes(msgs, cfg)
+	require.NoError(t, err)
+
+	for i, m := range msgs {
+		msg, err := createMessage(cfg.From, cfg.ReplyTo, m)
+		require.NoError(t, err)
+		require.NoError(t, attachFiles(msg, m.Attachments))
+		data, err := renderMessage(msg)
+		require.NoError(t, err)
+		assert.Equal(t, int64(len(data)), sizes[i], "encoded size of message to %s", m.Address)
+	}
+	assert.Greater(t, sizes[1], int64(1_350_000), "base64 overhead included")
+
+	_, err = MessageSizes([]Message{{Address: "a@example.com", Attachments: []string{filepath.Join(dir, "missing.pdf")}}}, cfg)
+	assert.ErrorContains(t, err, "recipient a@example.com: attachment")
+}
+
+func TestMessageSizesEncrypted(t *testing.T) {
+	cfg := config.MailConfig{From: "sender@example.com"}
+	m := Message{Address: "a@example.com", Subject: "Hi", Body: strings.Repeat("Hello ", 10_000)}
+	plain, err := MessageSizes([]Message{m}, cfg)
+	require.NoError(t, err)
+
+	m.SMIMECert = "a.pem"
+	encrypted, err := MessageSizes([]Message{m}, cfg)
+	require.NoError(t, err)
+	assert.Greater(t, encrypted[0], plain[0]*4/3+signatureOverhead-1)
+}
+
+func TestServerMaxSizeLMTP(t *testing.T) {
+	srv := startLMTPServer(t)
+	srv.mu.Lock()
+	srv.size = "1000000"
+	srv.mu.Unlock()
+	s, err := newLMTPSender(srv.path, 5*time.Second)
+	require.NoError(t, err)
+	defer s.Close()
+	size, err := ServerMaxSize(s)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1000000), size)
+
+	srv.mu.Lock()
+	srv.size = ""
+	srv.mu.Unlock()
+	require.NoError(t, s.Reconnect())
+	size, err = ServerMaxSize(s)
+	require.NoError(t, err)
+	assert.Zero(t, size)
+}
+
+func TestServerMaxSizeSMTP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				c := textproto.NewConn(conn)
+				defer c.Close()
+				_ = c.PrintfLine("220 test ESMTP")
+				for {
+					line, err := c.ReadLine()
+					if err != nil {
+						return
+					}
+					switch verb, _, _ := strings.Cut(line, " "); strings.ToUpper(verb) {
+					case "EHLO":
+						_ = c.PrintfLine("250-test\r\n250 SIZE 35882577")
+					case "QUIT":
+						_ = c.PrintfLine("221 bye")
+						return
+					default:
+						_ = c.PrintfLine("250 OK")
+					}
+				}
+			}()
+		}
+	}()
+
+	creds := SMTPCredentials{Host: "127.0.0.1", Port: ln.Addr().(*net.TCPAddr).Port, Auth: smtpAuthNone, TLS: smtpTLSNone, AllowInsecure: true}
+	sender, err := NewSMTPSender(creds, 5*time.Second)
+	require.NoError(t, err)
+	defer sender.Close()
+	size, err := ServerMaxSize(sender)
+	require.NoError(t, err)
+	assert.Equal(t, int64(35882577), size)
+
+	size, err = ServerMaxSize(&mockSender{})
+	require.NoError(t, err)
+	assert.Zero(t, size, "no server, no limit")
+}
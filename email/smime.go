@@ -0,0 +1,309 @@
+// gmt sends emails in bulk based on a template and a config file.
+// Copyright (C) 2019-2025  "Muharem Hrnjadovic" <muharem@linux.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package email
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/al-maisan/gmt/config"
+	"github.com/smallstep/pkcs7"
+	mail "github.com/wneessen/go-mail"
+)
+
+func init() {
+	// The pkcs7 default, DES-CBC, is obsolete; AES-256-CBC is what current
+	// mail clients expect in enveloped data.
+	pkcs7.ContentEncryptionAlgorithm = pkcs7.EncryptionAlgorithmAES256CBC
+}
+
+// SMIMESigner S/MIME-signs outgoing messages with a certificate and its key.
+// It is safe for concurrent use.
+type SMIMESigner struct {
+	cert  *x509.Certificate
+	chain []*x509.Certificate // intermediates included in the signature
+	key   crypto.PrivateKey
+	// encryptable says cert can be encrypted to, so encrypted messages are
+	// also encrypted to it and the sender can read the copies they keep.
+	encryptable bool
+}
+
+// NewSMIMESigner loads the signing certificate (and any intermediates after
+// it) and the private key named in cfg. The certificate must be currently
+// valid, match the key, allow email protection and, if it names email
+// addresses, name the address of from.
+func NewSMIMESigner(cfg config.SMIMEConfig, from string) (*SMIMESigner, error) {
+	certs, err := loadCertificates(cfg.Cert)
+	if err != nil {
+		return nil, fmt.Errorf("smime: %w", err)
+	}
+	cert := certs[0]
+	if err := checkCertificate(cert, time.Now()); err != nil {
+		return nil, fmt.Errorf("smime: certificate %q: %w", cfg.Cert, err)
+	}
+	key, err := loadPrivateKey(cfg.Key)
+	if err != nil {
+		return nil, fmt.Errorf("smime: %w", err)
+	}
+	if !publicKeyMatches(cert, key) {
+		return nil, fmt.Errorf("smime: key %q does not belong to certificate %q", cfg.Key, cfg.Cert)
+	}
+	if err := checkCertificateAddress(cert, from); err != nil {
+		return nil, fmt.Errorf("smime: certificate %q: %w", cfg.Cert, err)
+	}
+	return &SMIMESigner{cert: cert, chain: certs[1:], key: key, encryptable: checkEncryptionUsage(cert) == nil}, nil
+}
+
+// CheckFrom returns an error if the signing certificate names email
+// addresses and from is not one of them.
+func (s *SMIMESigner) CheckFrom(from string) error {
+	if err := checkCertificateAddress(s.cert, from); err != nil {
+		return fmt.Errorf("smime: signing certificate %w", err)
+	}
+	return nil
+}
+
+// Subject returns the signer's name: its first email address, or its
+// common name.
+func (s *SMIMESigner) Subject() string {
+	if len(s.cert.EmailAddresses) > 0 {
+		return s.cert.EmailAddresses[0]
+	}
+	return s.cert.Subject.CommonName
+}
+
+// NotAfter returns when the signing certificate expires.
+func (s *SMIMESigner) NotAfter() time.Time { return s.cert.NotAfter }
+
+// CheckCertificates verifies that every recipient's S/MIME certificate
+// exists, parses, is currently valid, can be used for encryption and, if it
+// names email addresses, names the recipient, so problems are reported up
+// front (e.g. during -validate) instead of mid-send. An encrypted message may
+// not have Cc or Bcc recipients: they have no certificate to encrypt to, so
+// they could not read it.
+func CheckCertificates(msgs []Message) error {
+	now := time.Now()
+	for _, m := range msgs {
+		if m.SMIMECert == "" {
+			continue
+		}
+		if _, err := loadEncryptionCert(m.SMIMECert, m.Address, now); err != nil {
+			return fmt.Errorf("smime_cert for recipient %s: %w", m.Address, err)
+		}
+		if copies := slices.Concat(m.Cc, m.Bcc); len(copies) > 0 {
+			return fmt.Errorf("smime_cert for recipient %s: its Cc and Bcc recipients (%s) have no certificate and could not read the encrypted message",
+				m.Address, strings.Join(copies, ", "))
+		}
+	}
+	return nil
+}
+
+// protectSMIME replaces the body of msg with an S/MIME-signed entity if
+// signer is set, then encrypts it to the certificate at certPath if that is
+// set, and to the signing certificate if it can be encrypted to, so the
+// sender can read the copies kept with -save-sent or -archive. Headers other
+// than the body's Content-Type stay in the clear.
+func protectSMIME(msg *mail.Msg, signer *SMIMESigner, certPath, address string) error {
+	e, err := bodyEntity(msg)
+	if err != nil {
+		return err
+	}
+	if signer != nil {
+		if e, err = signer.sign(e); err != nil {
+			return err
+		}
+	}
+	if certPath != "" {
+		cert, err := loadEncryptionCert(certPath, address, time.Now())
+		if err != nil {
+			return fmt.Errorf("smime: %w", err)
+		}
+		recipients := []*x509.Certificate{cert}
+		if signer != nil && signer.encryptable {
+			recipients = append(recipients, signer.cert)
+		}
+		der, err := pkcs7.Encrypt(e.bytes(), recipients)
+		if err != nil {
+			return fmt.Errorf("smime: failed to encrypt: %w", err)
+		}
+		e = mimeEntity{
+			contentType: `application/pkcs7-mime; smime-type=enveloped-data; name="smime.p7m"`,
+			encoding:    "base64",
+			body:        base64Body(der),
+		}
+	}
+	return replaceBody(msg, e)
+}
+
+// --- internal ---
+
+// sign wraps e in a multipart/signed entity with a detached SHA-256
+// signature.
+func (s *SMIMESigner) sign(e mimeEntity) (mimeEntity, error) {
+	content := e.bytes()
+	sd, err := pkcs7.NewSignedData(content)
+	if err != nil {
+		return mimeEntity{}, fmt.Errorf("smime: %w", err)
+	}
+	sd.SetDigestAlgorithm(pkcs7.OIDDigestAlgorithmSHA256)
+	if err := sd.AddSignerChain(s.cert, s.key, s.chain, pkcs7.SignerInfoConfig{}); err != nil {
+		return mimeEntity{}, fmt.Errorf("smime: failed to sign: %w", err)
+	}
+	sd.Detach()
+	der, err := sd.Finish()
+	if err != nil {
+		return mimeEntity{}, fmt.Errorf("smime: failed to sign: %w", err)
+	}
+	return multipartSigned(content, "application/pkcs7-signature", "sha-256", mimeEntity{
+		contentType: `application/pkcs7-signature; name="smime.p7s"`,
+		encoding:    "base64",
+		body:        base64Body(der),
+	})
+}
+
+// loadEncryptionCert loads the certificate at path and checks it is usable
+// to encrypt to address at now.
+func loadEncryptionCert(path, address string, now time.Time) (*x509.Certificate, error) {
+	certs, err := loadCertificates(path)
+	if err != nil {
+		return nil, err
+	}
+	cert := certs[0]
+	if err := checkCertificate(cert, now); err != nil {
+		return nil, fmt.Errorf("certificate %q: %w", path, err)
+	}
+	if err := checkEncryptionUsage(cert); err != nil {
+		return nil, fmt.Errorf("certificate %q: %w", path, err)
+	}
+	if err := checkCertificateAddress(cert, address); err != nil {
+		return nil, fmt.Errorf("certificate %q: %w", path, err)
+	}
+	return cert, nil
+}
+
+// checkEncryptionUsage returns an error if cert cannot be encrypted to.
+func checkEncryptionUsage(cert *x509.Certificate) error {
+	if _, ok := cert.PublicKey.(*rsa.PublicKey); !ok {
+		return fmt.Errorf("only RSA keys can be encrypted to")
+	}
+	if cert.KeyUsage != 0 && cert.KeyUsage&x509.KeyUsageKeyEncipherment == 0 {
+		return fmt.Errorf("key usage does not allow encryption")
+	}
+	return nil
+}
+
+// loadCertificates reads the PEM certificates in path, in order.
+func loadCertificates(path string) ([]*x509.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read certificate: %w", err)
+	}
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid certificate in %q: %w", path, err)
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no PEM certificate in %q", path)
+	}
+	return certs, nil
+}
+
+// loadPrivateKey reads a PEM private key: PKCS #1 RSA, SEC 1 EC or PKCS #8.
+func loadPrivateKey(path string) (crypto.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read private key: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block in private key file %q", path)
+	}
+	var key any
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q in %q", block.Type, path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid private key %q: %w", path, err)
+	}
+	return key, nil
+}
+
+// checkCertificate reports whether cert is within its validity period at now
+// and, if it restricts its extended key usage, allows email protection.
+func checkCertificate(cert *x509.Certificate, now time.Time) error {
+	if now.Before(cert.NotBefore) {
+		return fmt.Errorf("not valid before %s", cert.NotBefore.Format(time.DateOnly))
+	}
+	if now.After(cert.NotAfter) {
+		return fmt.Errorf("expired on %s", cert.NotAfter.Format(time.DateOnly))
+	}
+	if len(cert.ExtKeyUsage) > 0 &&
+		!slices.Contains(cert.ExtKeyUsage, x509.ExtKeyUsageEmailProtection) &&
+		!slices.Contains(cert.ExtKeyUsage, x509.ExtKeyUsageAny) {
+		return fmt.Errorf("not issued for email protection")
+	}
+	return nil
+}
+
+// checkCertificateAddress reports an error if cert names email addresses
+// and address (bare or RFC 5322) is not one of them.
+func checkCertificateAddress(cert *x509.Certificate, address string) error {
+	if len(cert.EmailAddresses) == 0 {
+		return nil
+	}
+	bare := plainAddress(address)
+	if containsFold(cert.EmailAddresses, bare) {
+		return nil
+	}
+	return fmt.Errorf("issued to %s, not %s", strings.Join(cert.EmailAddresses, ", "), bare)
+}
+
+// publicKeyMatches reports whether key is the private half of cert's key.
+func publicKeyMatches(cert *x509.Certificate, key crypto.PrivateKey) bool {
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return false
+	}
+	pub, ok := signer.Public().(interface{ Equal(crypto.PublicKey) bool })
+	return ok && pub.Equal(cert.PublicKey)
+}
@@ -0,0 +1,316 @@
+// gmt sends emails in bulk based on a template and a config file.
+// Copyright (C) 2019-2025  "Muharem Hrnjadovic" <muharem@linux.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package email
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"mime"
+	netmail "net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/al-maisan/gmt/config"
+	"github.com/smallstep/pkcs7"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// smimeCert is a self-signed RSA test certificate written to disk.
+type smimeCert struct {
+	cert     *x509.Certificate
+	key      *rsa.PrivateKey
+	certPath string
+	keyPath  string
+}
+
+// newSMIMECert issues a self-signed RSA certificate for email, valid for an
+// hour unless edit changes the template.
+func newSMIMECert(t *testing.T, email string, edit func(*x509.Certificate)) smimeCert {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:   big.NewInt(time.Now().UnixNano()),
+		Subject:        pkix.Name{CommonName: email},
+		EmailAddresses: []string{email},
+		NotBefore:      time.Now().Add(-time.Hour),
+		NotAfter:       time.Now().Add(time.Hour),
+		KeyUsage:       x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageEmailProtection},
+	}
+	if edit != nil {
+		edit(tmpl)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	c := smimeCert{cert: cert, key: key, certPath: filepath.Join(dir, "cert.pem"), keyPath: filepath.Join(dir, "key.pem")}
+	require.NoError(t, os.WriteFile(c.certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(c.keyPath, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}), 0o600))
+	return c
+}
+
+// readEntity parses data as a message or MIME entity and returns its media
+// type and parameters and its decoded body.
+func readEntity(t *testing.T, data []byte) (string, map[string]string, []byte) {
+	t.Helper()
+	m, err := netmail.ReadMessage(bytes.NewReader(data))
+	require.NoError(t, err)
+	mediaType, params, err := mime.ParseMediaType(m.Header.Get("Content-Type"))
+	require.NoError(t, err)
+	body := new(bytes.Buffer)
+	_, err = body.ReadFrom(m.Body)
+	require.NoError(t, err)
+	if strings.EqualFold(m.Header.Get("Content-Transfer-Encoding"), "base64") {
+		decoded, err := base64.StdEncoding.DecodeString(strings.NewReplacer("\r", "", "\n", "").Replace(body.String()))
+		require.NoError(t, err)
+		return mediaType, params, decoded
+	}
+	return mediaType, params, body.Bytes()
+}
+
+// verifySMIMESigned checks that data is a multipart/signed entity whose
+// signature by signer verifies, and returns the signed entity.
+func verifySMIMESigned(t *testing.T, data []byte, signer smimeCert) []byte {
+	t.Helper()
+	mediaType, params, body := readEntity(t, data)
+	require.Equal(t, "multipart/signed", mediaType)
+	assert.Equal(t, "application/pkcs7-signature", params["protocol"])
+	assert.Equal(t, "sha-256", params["micalg"])
+
+	delim := "--" + params["boundary"]
+	parts := strings.Split(string(body), "\r\n"+delim)
+	require.True(t, strings.HasPrefix(parts[0], delim+"\r\n"))
+	signed := []byte(strings.TrimPrefix(parts[0], delim+"\r\n"))
+
+	sigType, _, sig := readEntity(t, []byte(strings.TrimPrefix(parts[1], "\r\n")))
+	require.Equal(t, "application/pkcs7-signature", sigType)
+	p7, err := pkcs7.Parse(sig)
+	require.NoError(t, err)
+	p7.Content = signed
+	pool := x509.NewCertPool()
+	pool.AddCert(signer.cert)
+	require.NoError(t, p7.VerifyWithChain(pool))
+	return signed
+}
+
+func TestSMIMESign(t *testing.T) {
+	signer := newSMIMECert(t, "sender@example.com", nil)
+	s, err := NewSMIMESigner(config.SMIMEConfig{Cert: signer.certPath, Key: signer.keyPath}, `"Sender" <sender@example.com>`)
+	require.NoError(t, err)
+	assert.Equal(t, "sender@example.com", s.Subject())
+
+	attachment := filepath.Join(t.TempDir(), "notes.txt")
+	require.NoError(t, os.WriteFile(attachment, []byte("attached\n"), 0o600))
+	msg, err := createMessage("sender@example.com", "", Message{
+		Address: "jane@example.com", Subject: "Hi", Body: "Hello", HTMLBody: "<p>Hello</p>",
+	})
+	require.NoError(t, err)
+	require.NoError(t, attachFiles(msg, []string{attachment}))
+	require.NoError(t, protectSMIME(msg, s, "", "jane@example.com"))
+
+	data, err := renderMessage(msg)
+	require.NoError(t, err)
+	signed := verifySMIMESigned(t, data, signer)
+	assert.Contains(t, string(signed), "Content-Type: multipart/mixed")
+	assert.Contains(t, string(signed), "notes.txt")
+
+	// Rendering again, as DKIM and the transport do, yields the same bytes.
+	again, err := renderMessage(msg)
+	require.NoError(t, err)
+	assert.Equal(t, data, again)
+}
+
+func TestSMIMESignAndEncrypt(t *testing.T) {
+	signer := newSMIMECert(t, "sender@example.com", nil)
+	rcpt := newSMIMECert(t, "jane@example.com", nil)
+	s, err := NewSMIMESigner(config.SMIMEConfig{Cert: signer.certPath, Key: signer.keyPath}, "sender@example.com")
+	require.NoError(t, err)
+
+	msg, err := createMessage("sender@example.com", "", Message{Address: "jane@example.com", Subject: "Secret", Body: "Hello"})
+	require.NoError(t, err)
+	require.NoError(t, protectSMIME(msg, s, rcpt.certPath, "jane@example.com"))
+
+	data, err := renderMessage(msg)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "Hello")
+	assert.Contains(t, string(data), "Subject: Secret")
+	mediaType, params, body := readEntity(t, data)
+	require.Equal(t, "application/pkcs7-mime", mediaType)
+	assert.Equal(t, "enveloped-data", params["smime-type"])
+
+	p7, err := pkcs7.Parse(body)
+	require.NoError(t, err)
+	inner, err := p7.Decrypt(rcpt.cert, rcpt.key)
+	require.NoError(t, err)
+	signed := verifySMIMESigned(t, inner, signer)
+	_, _, text := readEntity(t, signed)
+	assert.Contains(t, string(text), "Hello")
+
+	// The sender can read the copies they keep.
+	own, err := p7.Decrypt(signer.cert, signer.key)
+	require.NoError(t, err)
+	assert.Equal(t, inner, own)
+}
+
+func TestSMIMESignOnlyCertNotEncryptedTo(t *testing.T) {
+	signer := newSMIMECert(t, "sender@example.com", func(c *x509.Certificate) {
+		c.KeyUsage = x509.KeyUsageDigitalSignature
+	})
+	rcpt := newSMIMECert(t, "jane@example.com", nil)
+	s, err := NewSMIMESigner(config.SMIMEConfig{Cert: signer.certPath, Key: signer.keyPath}, "sender@example.com")
+	require.NoError(t, err)
+
+	msg, err := createMessage("sender@example.com", "", Message{Address: "jane@example.com", Subject: "Secret", Body: "Hello"})
+	require.NoError(t, err)
+	require.NoError(t, protectSMIME(msg, s, rcpt.certPath, "jane@example.com"))
+
+	data, err := renderMessage(msg)
+	require.NoError(t, err)
+	_, _, body := readEntity(t, data)
+	p7, err := pkcs7.Parse(body)
+	require.NoError(t, err)
+	_, err = p7.Decrypt(rcpt.cert, rcpt.key)
+	require.NoError(t, err)
+	_, err = p7.Decrypt(signer.cert, signer.key)
+	assert.Error(t, err)
+}
+
+func TestSMIMEEncryptOnly(t *testing.T) {
+	rcpt := newSMIMECert(t, "jane@example.com", nil)
+	msg, err := createMessage("sender@example.com", "", Message{Address: "jane@example.com", Subject: "Secret", Body: "Hello"})
+	require.NoError(t, err)
+	require.NoError(t, protectSMIME(msg, nil, rcpt.certPath, "jane@example.com"))
+
+	data, err := renderMessage(msg)
+	require.NoError(t, err)
+	_, _, body := readEntity(t, data)
+	p7, err := pkcs7.Parse(body)
+	require.NoError(t, err)
+	inner, err := p7.Decrypt(rcpt.cert, rcpt.key)
+	require.NoError(t, err)
+	mediaType, _, text := readEntity(t, inner)
+	assert.Equal(t, "text/plain", mediaType)
+	assert.Contains(t, string(text), "Hello")
+}
+
+func TestNewSMIMESignerErrors(t *testing.T) {
+	signer := newSMIMECert(t, "sender@example.com", nil)
+	other := newSMIMECert(t, "sender@example.com", nil)
+	expired := newSMIMECert(t, "sender@example.com", func(c *x509.Certificate) {
+		c.NotBefore = time.Now().Add(-48 * time.Hour)
+		c.NotAfter = time.Now().Add(-24 * time.Hour)
+	})
+
+	tests := []struct {
+		name    string
+		cfg     config.SMIMEConfig
+		from    string
+		wantErr string
+	}{
+		{"missing cert", config.SMIMEConfig{Cert: "/nonexistent.pem", Key: signer.keyPath}, "sender@example.com", "failed to read certificate"},
+		{"key is not a cert", config.SMIMEConfig{Cert: signer.keyPath, Key: signer.keyPath}, "sender@example.com", "no PEM certificate"},
+		{"expired", config.SMIMEConfig{Cert: expired.certPath, Key: expired.keyPath}, "sender@example.com", "expired on"},
+		{"wrong key", config.SMIMEConfig{Cert: signer.certPath, Key: other.keyPath}, "sender@example.com", "does not belong to certificate"},
+		{"other address", config.SMIMEConfig{Cert: signer.certPath, Key: signer.keyPath}, "news@example.com", "issued to sender@example.com, not news@example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewSMIMESigner(tt.cfg, tt.from)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+		})
+	}
+}
+
+func TestCheckCertificates(t *testing.T) {
+	valid := newSMIMECert(t, "jane@example.com", nil)
+	expired := newSMIMECert(t, "jane@example.com", func(c *x509.Certificate) {
+		c.NotBefore = time.Now().Add(-48 * time.Hour)
+		c.NotAfter = time.Now().Add(-24 * time.Hour)
+	})
+	signOnly := newSMIMECert(t, "jane@example.com", func(c *x509.Certificate) {
+		c.KeyUsage = x509.KeyUsageDigitalSignature
+	})
+	serverCert := newSMIMECert(t, "jane@example.com", func(c *x509.Certificate) {
+		c.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	})
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	ecTmpl := &x509.Certificate{SerialNumber: big.NewInt(1), NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(time.Hour)}
+	ecDER, err := x509.CreateCertificate(rand.Reader, ecTmpl, ecTmpl, &ecKey.PublicKey, ecKey)
+	require.NoError(t, err)
+	ecPath := filepath.Join(t.TempDir(), "ec.pem")
+	require.NoError(t, os.WriteFile(ecPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ecDER}), 0o600))
+
+	assert.NoError(t, CheckCertificates([]Message{
+		{Address: "plain@example.com"},
+		{Address: "jane@example.com", SMIMECert: valid.certPath},
+	}))
+
+	tests := []struct {
+		name    string
+		msg     Message
+		wantErr string
+	}{
+		{"missing", Message{Address: "jane@example.com", SMIMECert: "/nonexistent.pem"}, "smime_cert for recipient jane@example.com: failed to read certificate"},
+		{"expired", Message{Address: "jane@example.com", SMIMECert: expired.certPath}, "expired on"},
+		{"no key encipherment", Message{Address: "jane@example.com", SMIMECert: signOnly.certPath}, "key usage does not allow encryption"},
+		{"wrong purpose", Message{Address: "jane@example.com", SMIMECert: serverCert.certPath}, "not issued for email protection"},
+		{"not RSA", Message{Address: "jane@example.com", SMIMECert: ecPath}, "only RSA keys"},
+		{"other address", Message{Address: "john@example.com", SMIMECert: valid.certPath}, "issued to jane@example.com, not john@example.com"},
+		{"cc", Message{Address: "jane@example.com", SMIMECert: valid.certPath, Cc: []string{"boss@example.com"}}, "its Cc and Bcc recipients (boss@example.com) have no certificate"},
+		{"bcc", Message{Address: "jane@example.com", SMIMECert: valid.certPath, Bcc: []string{"audit@example.com"}}, "(audit@example.com)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckCertificates([]Message{{Address: "plain@example.com"}, tt.msg})
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+		})
+	}
+}
+
+func TestSendAllEncryptsPerRecipient(t *testing.T) {
+	rcpt := newSMIMECert(t, "jane@example.com", nil)
+	sender := &renderingSender{}
+	var out bytes.Buffer
+	result := NewBatchSender(&out, sender, config.MailConfig{From: "sender@example.com"}, SendOptions{}).SendAll([]Message{
+		{Address: "jane@example.com", Subject: "Hi", Body: "Hello Jane", SMIMECert: rcpt.certPath},
+		{Address: "john@example.com", Subject: "Hi", Body: "Hello John"},
+	})
+	require.Equal(t, 2, result.Sent)
+	require.Len(t, sender.sent, 2)
+	assert.Contains(t, string(sender.sent[0]), "application/pkcs7-mime")
+	assert.NotContains(t, string(sender.sent[0]), "Hello Jane")
+	assert.Contains(t, string(sender.sent[1]), "Hello John")
+}
@@ -0,0 +1,202 @@
+// gmt sends emails in bulk based on a template and a config file.
+// Copyright (C) 2019-2025  "Muharem Hrnjadovic" <muharem@linux.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package email
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+
+	mail "github.com/wneessen/go-mail"
+)
+
+// SMTP authentication mechanisms, selected with SMTP_AUTH.
+const (
+	smtpAuthPlain       = "plain"
+	smtpAuthLogin       = "login"
+	smtpAuthCramMD5     = "cram-md5"
+	smtpAuthSCRAMSHA1   = "scram-sha-1"
+	smtpAuthSCRAMSHA256 = "scram-sha-256"
+	smtpAuthXOAUTH2     = "xoauth2"
+	smtpAuthAuto        = "auto"
+	smtpAuthNone        = "none"
+)
+
+// SMTP TLS modes, selected with SMTP_TLS.
+const (
+	smtpTLSStartTLS      = "starttls"      // STARTTLS, fail if the server does not offer it
+	smtpTLSOpportunistic = "opportunistic" // STARTTLS if offered, plaintext otherwise
+	smtpTLSImplicit      = "tls"           // TLS from the first byte, usually port 465
+	smtpTLSNone          = "none"          // plaintext
+)
+
+var smtpAuthTypes = map[string]mail.SMTPAuthType{
+	smtpAuthPlain:       mail.SMTPAuthPlain,
+	smtpAuthLogin:       mail.SMTPAuthLogin,
+	smtpAuthCramMD5:     mail.SMTPAuthCramMD5,
+	smtpAuthSCRAMSHA1:   mail.SMTPAuthSCRAMSHA1,
+	smtpAuthSCRAMSHA256: mail.SMTPAuthSCRAMSHA256,
+	smtpAuthXOAUTH2:     mail.SMTPAuthXOAUTH2,
+	smtpAuthAuto:        mail.SMTPAuthAutoDiscover,
+	smtpAuthNone:        mail.SMTPAuthNoAuth,
+}
+
+var smtpTLSModes = []string{smtpTLSStartTLS, smtpTLSOpportunistic, smtpTLSImplicit, smtpTLSNone}
+
+// smtpAuthNames lists the SMTP_AUTH values in the order they are documented.
+var smtpAuthNames = []string{
+	smtpAuthPlain, smtpAuthLogin, smtpAuthCramMD5, smtpAuthSCRAMSHA1,
+	smtpAuthSCRAMSHA256, smtpAuthXOAUTH2, smtpAuthAuto, smtpAuthNone,
+}
+
+// loadSMTPSecurity reads the optional SMTP_AUTH, SMTP_TLS,
+// SMTP_ALLOW_INSECURE, SMTP_CA_FILE, SMTP_CLIENT_CERT, SMTP_CLIENT_KEY and
+// SMTP_HELO variables into creds.
+func loadSMTPSecurity(creds *SMTPCredentials) error {
+	creds.Auth = strings.ToLower(os.Getenv("SMTP_AUTH"))
+	creds.TLS = strings.ToLower(os.Getenv("SMTP_TLS"))
+	if v := os.Getenv("SMTP_ALLOW_INSECURE"); v != "" {
+		allow, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("SMTP_ALLOW_INSECURE must be true or false, got %q", v)
+		}
+		creds.AllowInsecure = allow
+	}
+	creds.CAFile = os.Getenv("SMTP_CA_FILE")
+	creds.ClientCert = os.Getenv("SMTP_CLIENT_CERT")
+	creds.ClientKey = os.Getenv("SMTP_CLIENT_KEY")
+	creds.HELO = os.Getenv("SMTP_HELO")
+	return nil
+}
+
+// authMechanism returns the configured SMTP_AUTH value, defaulting to plain.
+func (c SMTPCredentials) authMechanism() string {
+	if c.Auth == "" {
+		return smtpAuthPlain
+	}
+	return c.Auth
+}
+
+// tlsMode returns the configured SMTP_TLS value, defaulting to starttls.
+func (c SMTPCredentials) tlsMode() string {
+	if c.TLS == "" {
+		return smtpTLSStartTLS
+	}
+	return c.TLS
+}
+
+// validate checks the combination of settings, everything except the
+// files, which clientOptions loads.
+func (c SMTPCredentials) validate() error {
+	auth, mode := c.authMechanism(), c.tlsMode()
+	if _, ok := smtpAuthTypes[auth]; !ok {
+		return fmt.Errorf("SMTP_AUTH must be one of %s, got %q", strings.Join(smtpAuthNames, ", "), c.Auth)
+	}
+	if !slices.Contains(smtpTLSModes, mode) {
+		return fmt.Errorf("SMTP_TLS must be one of %s, got %q", strings.Join(smtpTLSModes, ", "), c.TLS)
+	}
+	if (mode == smtpTLSNone || mode == smtpTLSOpportunistic) && !c.AllowInsecure {
+		return fmt.Errorf("SMTP_TLS=%s may send mail and credentials in plaintext; set SMTP_ALLOW_INSECURE=true to allow it", mode)
+	}
+	if auth == smtpAuthNone && (c.User != "" || c.Password != "") {
+		return fmt.Errorf("SENDER_EMAIL and SENDER_PASSWORD must not be set with SMTP_AUTH=none")
+	}
+	if mode == smtpTLSNone && (c.CAFile != "" || c.ClientCert != "" || c.ClientKey != "") {
+		return fmt.Errorf("SMTP_CA_FILE, SMTP_CLIENT_CERT and SMTP_CLIENT_KEY have no effect with SMTP_TLS=none")
+	}
+	if (c.ClientCert == "") != (c.ClientKey == "") {
+		return fmt.Errorf("SMTP_CLIENT_CERT and SMTP_CLIENT_KEY must be set together")
+	}
+	if strings.ContainsFunc(c.HELO, func(r rune) bool { return unicode.IsSpace(r) || unicode.IsControl(r) }) {
+		return fmt.Errorf("SMTP_HELO must be a single host name, got %q", c.HELO)
+	}
+	return nil
+}
+
+// clientOptions returns the go-mail options for c, loading the CA bundle and
+// client certificate if configured.
+func (c SMTPCredentials) clientOptions() ([]mail.Option, error) {
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	opts := []mail.Option{mail.WithPort(c.Port)}
+
+	auth := smtpAuthTypes[c.authMechanism()]
+	if auth != mail.SMTPAuthNoAuth {
+		// The user opted into plaintext, so PLAIN and LOGIN may send the
+		// password unencrypted if the server does not offer STARTTLS.
+		if c.AllowInsecure && auth == mail.SMTPAuthPlain {
+			auth = mail.SMTPAuthPlainNoEnc
+		}
+		if c.AllowInsecure && auth == mail.SMTPAuthLogin {
+			auth = mail.SMTPAuthLoginNoEnc
+		}
+		opts = append(opts, mail.WithSMTPAuth(auth), mail.WithUsername(c.User), mail.WithPassword(c.Password))
+	}
+
+	switch c.tlsMode() {
+	case smtpTLSStartTLS:
+		opts = append(opts, mail.WithTLSPolicy(mail.TLSMandatory))
+	case smtpTLSOpportunistic:
+		opts = append(opts, mail.WithTLSPolicy(mail.TLSOpportunistic))
+	case smtpTLSImplicit:
+		opts = append(opts, mail.WithSSL())
+	case smtpTLSNone:
+		opts = append(opts, mail.WithTLSPolicy(mail.NoTLS))
+	}
+	if c.tlsMode() != smtpTLSNone {
+		tlsConfig, err := c.tlsConfig()
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, mail.WithTLSConfig(tlsConfig))
+	}
+
+	if c.HELO != "" {
+		opts = append(opts, mail.WithHELO(c.HELO))
+	}
+	return opts, nil
+}
+
+// tlsConfig returns the TLS configuration for connecting to c.Host: the
+// system roots or SMTP_CA_FILE, and the client certificate if any.
+func (c SMTPCredentials) tlsConfig() (*tls.Config, error) {
+	cfg := &tls.Config{ServerName: c.Host, MinVersion: tls.VersionTLS12}
+	if c.CAFile != "" {
+		pem, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("SMTP_CA_FILE: %w", err)
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("SMTP_CA_FILE: no PEM certificates found in %q", c.CAFile)
+		}
+	}
+	if c.ClientCert != "" {
+		cert, err := tls.LoadX509KeyPair(c.ClientCert, c.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("SMTP_CLIENT_CERT/SMTP_CLIENT_KEY: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
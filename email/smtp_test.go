@@ -0,0 +1,218 @@
+// gmt sends emails in bulk based on a template and a config file.
+// Copyright (C) 2019-2025  "Muharem Hrnjadovic" <muharem@linux.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package email
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testPKI is a throwaway CA with a server and a client certificate, written
+// as PEM files.
+type testPKI struct {
+	caFile, clientCert, clientKey string
+	server                        tls.Certificate
+	pool                          *x509.CertPool
+}
+
+func newTestPKI(t *testing.T) testPKI {
+	t.Helper()
+	dir := t.TempDir()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "gmt test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+	require.NoError(t, err)
+	ca, err := x509.ParseCertificate(caDER)
+	require.NoError(t, err)
+
+	issue := func(serial int64, usage x509.ExtKeyUsage) ([]byte, *ecdsa.PrivateKey) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+		tmpl := &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: "localhost"},
+			DNSNames:     []string{"localhost"},
+			IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, &key.PublicKey, caKey)
+		require.NoError(t, err)
+		return der, key
+	}
+	writePEM := func(name, typ string, der []byte) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0o600))
+		return path
+	}
+	keyDER := func(key *ecdsa.PrivateKey) []byte {
+		der, err := x509.MarshalECPrivateKey(key)
+		require.NoError(t, err)
+		return der
+	}
+
+	pki := testPKI{caFile: writePEM("ca.pem", "CERTIFICATE", caDER), pool: x509.NewCertPool()}
+	pki.pool.AddCert(ca)
+	serverDER, serverKey := issue(2, x509.ExtKeyUsageServerAuth)
+	pki.server = tls.Certificate{Certificate: [][]byte{serverDER}, PrivateKey: serverKey}
+	clientDER, clientKey := issue(3, x509.ExtKeyUsageClientAuth)
+	pki.clientCert = writePEM("client.pem", "CERTIFICATE", clientDER)
+	pki.clientKey = writePEM("client.key", "EC PRIVATE KEY", keyDER(clientKey))
+	return pki
+}
+
+func TestLoadSMTPCredentialsSecurity(t *testing.T) {
+	pki := newTestPKI(t)
+	base := map[string]string{
+		"SMTP_HOST": "smtp.example.com", "SMTP_PORT": "587", "SENDER_EMAIL": "u@example.com", "SENDER_PASSWORD": "secret",
+		"SMTP_AUTH": "", "SMTP_TLS": "", "SMTP_ALLOW_INSECURE": "", "SMTP_CA_FILE": "",
+		"SMTP_CLIENT_CERT": "", "SMTP_CLIENT_KEY": "", "SMTP_HELO": "",
+	}
+	tests := []struct {
+		name    string
+		env     map[string]string
+		wantErr string
+	}{
+		{"defaults", nil, ""},
+		{"login over implicit TLS", map[string]string{"SMTP_AUTH": "LOGIN", "SMTP_TLS": "tls", "SMTP_PORT": "465"}, ""},
+		{"cram-md5", map[string]string{"SMTP_AUTH": "cram-md5"}, ""},
+		{"unknown auth", map[string]string{"SMTP_AUTH": "ntlm"}, "SMTP_AUTH must be one of"},
+		{"unknown tls", map[string]string{"SMTP_TLS": "ssl"}, "SMTP_TLS must be one of"},
+		{"no tls without opt-in", map[string]string{"SMTP_TLS": "none"}, "SMTP_ALLOW_INSECURE=true"},
+		{"opportunistic without opt-in", map[string]string{"SMTP_TLS": "opportunistic"}, "SMTP_ALLOW_INSECURE=true"},
+		{"no tls with opt-in", map[string]string{"SMTP_TLS": "none", "SMTP_ALLOW_INSECURE": "true"}, ""},
+		{"bad opt-in", map[string]string{"SMTP_ALLOW_INSECURE": "yes please"}, "SMTP_ALLOW_INSECURE must be true or false"},
+		{"no auth needs no credentials", map[string]string{"SMTP_AUTH": "none", "SENDER_EMAIL": "", "SENDER_PASSWORD": ""}, ""},
+		{"no auth with credentials", map[string]string{"SMTP_AUTH": "none"}, "must not be set with SMTP_AUTH=none"},
+		{"auth needs password", map[string]string{"SENDER_PASSWORD": ""}, "SENDER_PASSWORD"},
+		{"custom CA and client cert", map[string]string{"SMTP_CA_FILE": pki.caFile, "SMTP_CLIENT_CERT": pki.clientCert, "SMTP_CLIENT_KEY": pki.clientKey}, ""},
+		{"missing CA file", map[string]string{"SMTP_CA_FILE": "/nonexistent/ca.pem"}, "SMTP_CA_FILE"},
+		{"CA file without certificates", map[string]string{"SMTP_CA_FILE": pki.clientKey}, "no PEM certificates"},
+		{"cert without key", map[string]string{"SMTP_CLIENT_CERT": pki.clientCert}, "must be set together"},
+		{"key does not match", map[string]string{"SMTP_CLIENT_CERT": pki.caFile, "SMTP_CLIENT_KEY": pki.clientKey}, "SMTP_CLIENT_CERT/SMTP_CLIENT_KEY"},
+		{"CA with no tls", map[string]string{"SMTP_TLS": "none", "SMTP_ALLOW_INSECURE": "true", "SMTP_CA_FILE": pki.caFile}, "no effect with SMTP_TLS=none"},
+		{"helo", map[string]string{"SMTP_HELO": "mailer.example.com"}, ""},
+		{"bad helo", map[string]string{"SMTP_HELO": "mailer.example.com\r\nRSET"}, "SMTP_HELO"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range base {
+				t.Setenv(k, v)
+			}
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			_, err := LoadSMTPCredentials()
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+		})
+	}
+}
+
+// TestNewSMTPSenderImplicitTLS connects over implicit TLS to a server whose
+// certificate is signed by a private CA and which requires a client
+// certificate, without authentication and with a custom HELO name.
+func TestNewSMTPSenderImplicitTLS(t *testing.T) {
+	pki := newTestPKI(t)
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{pki.server},
+		ClientCAs:    pki.pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		MinVersion:   tls.VersionTLS12,
+	})
+	require.NoError(t, err)
+	defer ln.Close()
+
+	helo := make(chan string, 1)
+	serve := func(conn net.Conn) {
+		c := textproto.NewConn(conn)
+		defer c.Close()
+		_ = c.PrintfLine("220 test ESMTP")
+		for {
+			line, err := c.ReadLine()
+			if err != nil {
+				return
+			}
+			verb, arg, _ := strings.Cut(line, " ")
+			switch strings.ToUpper(verb) {
+			case "EHLO":
+				helo <- arg
+				_ = c.PrintfLine("250 test")
+			case "QUIT":
+				_ = c.PrintfLine("221 bye")
+				return
+			default:
+				_ = c.PrintfLine("250 OK")
+			}
+		}
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serve(conn)
+		}
+	}()
+
+	port := ln.Addr().(*net.TCPAddr).Port
+	creds := SMTPCredentials{
+		Host: "127.0.0.1", Port: port, Auth: smtpAuthNone, TLS: smtpTLSImplicit,
+		CAFile: pki.caFile, ClientCert: pki.clientCert, ClientKey: pki.clientKey, HELO: "mailer.example.com",
+	}
+	sender, err := NewSMTPSender(creds, 5*time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, "mailer.example.com", <-helo)
+	assert.NoError(t, sender.Close())
+
+	// Without the private CA the server certificate is not trusted.
+	creds.CAFile = ""
+	_, err = NewSMTPSender(creds, 5*time.Second)
+	assert.Error(t, err)
+}
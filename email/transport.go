@@ -0,0 +1,214 @@
+// gmt sends emails in bulk based on a template and a config file.
+// Copyright (C) 2019-2025  "Muharem Hrnjadovic" <muharem@linux.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package email
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	mail "github.com/wneessen/go-mail"
+)
+
+// Transports selectable with -transport.
+const (
+	TransportSMTP     = "smtp"
+	TransportSendmail = "sendmail"
+	TransportLMTP     = "lmtp"
+	TransportMaildir  = "maildir"
+	TransportMbox     = "mbox"
+)
+
+// Transports lists the valid transport names.
+var Transports = []string{TransportSMTP, TransportSendmail, TransportLMTP, TransportMaildir, TransportMbox}
+
+// defaultSendmailPath is used when SENDMAIL_PATH is not set.
+const defaultSendmailPath = "/usr/sbin/sendmail"
+
+// TransportConfig selects a transport and holds its settings, read from the
+// environment by LoadTransportConfig.
+type TransportConfig struct {
+	Name         string
+	SMTP         SMTPCredentials // smtp
+	SendmailPath string          // sendmail: SENDMAIL_PATH
+	LMTPAddress  string          // lmtp: LMTP_ADDRESS, a Unix socket path or host:port
+	MaildirPath  string          // maildir: MAILDIR_PATH
+	MboxPath     string          // mbox: MBOX_PATH
+}
+
+// LoadTransportConfig reads the settings for the named transport from
+// environment variables, returning an error naming any that are missing.
+func LoadTransportConfig(name string) (TransportConfig, error) {
+	tc := TransportConfig{Name: name}
+	var err error
+	switch name {
+	case TransportSMTP:
+		tc.SMTP, err = LoadSMTPCredentials()
+	case TransportSendmail:
+		tc.SendmailPath = os.Getenv("SENDMAIL_PATH")
+		if tc.SendmailPath == "" {
+			tc.SendmailPath = defaultSendmailPath
+		}
+	case TransportLMTP:
+		tc.LMTPAddress, err = requireEnv("LMTP_ADDRESS")
+	case TransportMaildir:
+		tc.MaildirPath, err = requireEnv("MAILDIR_PATH")
+	case TransportMbox:
+		tc.MboxPath, err = requireEnv("MBOX_PATH")
+	default:
+		err = fmt.Errorf("unknown transport %q (want one of %s)", name, strings.Join(Transports, ", "))
+	}
+	return tc, err
+}
+
+// NewSender creates a Sender for the configured transport. A positive timeout
+// bounds connecting and sending for the network and sendmail transports. The
+// caller must call Close when done.
+func NewSender(tc TransportConfig, timeout time.Duration) (Sender, error) {
+	switch tc.Name {
+	case TransportSMTP:
+		return NewSMTPSender(tc.SMTP, timeout)
+	case TransportSendmail:
+		return &sendmailSender{path: tc.SendmailPath, timeout: timeout}, nil
+	case TransportLMTP:
+		return newLMTPSender(tc.LMTPAddress, timeout)
+	case TransportMaildir:
+		return newMaildirSender(tc.MaildirPath)
+	case TransportMbox:
+		return newMboxSender(tc.MboxPath)
+	default:
+		return nil, fmt.Errorf("unknown transport %q", tc.Name)
+	}
+}
+
+// --- internal ---
+
+// requireEnv returns the value of the environment variable name, or an error
+// if it is unset or empty.
+func requireEnv(name string) (string, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return "", fmt.Errorf("missing required environment variable(s): %s", name)
+	}
+	return v, nil
+}
+
+// renderMessage serializes msg as it would go over the wire.
+func renderMessage(msg *mail.Msg) ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := msg.WriteTo(&buf); err != nil {
+		return nil, fmt.Errorf("failed to render message: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// renderCopy serializes msg for a copy kept by the sender (an archive or the
+// Sent folder). go-mail never writes a Bcc header, so the blind copies would
+// not show in it; as mail clients do for their own Sent copies, a Bcc header
+// naming them is added.
+func renderCopy(msg *mail.Msg) ([]byte, error) {
+	data, err := renderMessage(msg)
+	if err != nil || len(msg.GetBcc()) == 0 {
+		return data, err
+	}
+	var bcc []string
+	for _, addr := range msg.GetBcc() {
+		bcc = append(bcc, addr.String())
+	}
+	return append([]byte("Bcc: "+strings.Join(bcc, ", ")+"\r\n"), data...), nil
+}
+
+// envelopeFrom returns the bare envelope sender address of msg.
+func envelopeFrom(msg *mail.Msg) (string, error) {
+	from, err := msg.GetSender(false)
+	if err != nil {
+		return "", fmt.Errorf("failed to get envelope sender: %w", err)
+	}
+	return bareAddress(from), nil
+}
+
+// envelopeRecipients returns the bare To, Cc and Bcc addresses of msg.
+func envelopeRecipients(msg *mail.Msg) ([]string, error) {
+	rcpts, err := msg.GetRecipients()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get recipients: %w", err)
+	}
+	for i, r := range rcpts {
+		rcpts[i] = bareAddress(r)
+	}
+	return rcpts, nil
+}
+
+// bareAddress strips the angle brackets go-mail puts around addresses.
+func bareAddress(addr string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(addr, "<"), ">")
+}
+
+// sendmailSender pipes each message to a sendmail-compatible binary, which
+// takes the recipients from the message headers (-t) and does not treat a
+// line with a single dot as the end of the message (-oi). A message with Bcc
+// has no header naming the blind copies, so its recipients are passed as
+// arguments instead.
+type sendmailSender struct {
+	path    string
+	timeout time.Duration
+}
+
+func (s *sendmailSender) Send(msg *mail.Msg) error {
+	data, err := renderMessage(msg)
+	if err != nil {
+		return err
+	}
+	if len(msg.GetBcc()) == 0 {
+		return s.run(toLF(data), "-oi", "-t")
+	}
+	rcpts, err := envelopeRecipients(msg)
+	if err != nil {
+		return err
+	}
+	return s.run(toLF(data), append([]string{"-oi", "--"}, rcpts...)...)
+}
+
+// run invokes sendmail with args, feeding it data on stdin. A non-zero exit
+// status is an error carrying sendmail's stderr.
+func (s *sendmailSender) run(data []byte, args ...string) error {
+	ctx := context.Background()
+	if s.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.timeout)
+		defer cancel()
+	}
+	cmd := exec.CommandContext(ctx, s.path, args...)
+	cmd.Stdin = bytes.NewReader(data)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("%s failed: %w: %s", s.path, err, msg)
+		}
+		return fmt.Errorf("%s failed: %w", s.path, err)
+	}
+	return nil
+}
+
+// Reconnect is a no-op: every message is a new sendmail process.
+func (s *sendmailSender) Reconnect() error { return nil }
+func (s *sendmailSender) Close() error     { return nil }
@@ -0,0 +1,117 @@
+// gmt sends emails in bulk based on a template and a config file.
+// Copyright (C) 2019-2025  "Muharem Hrnjadovic" <muharem@linux.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package email
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSendmail writes a shell script standing in for sendmail that saves its
+// arguments and stdin into dir and then runs tail, e.g. to fail.
+func fakeSendmail(t *testing.T, tail string) (path, dir string) {
+	t.Helper()
+	dir = t.TempDir()
+	path = filepath.Join(dir, "sendmail")
+	script := "#!/bin/sh\necho \"$@\" > " + dir + "/args\ncat > " + dir + "/stdin\n" + tail + "\n"
+	require.NoError(t, os.WriteFile(path, []byte(script), 0o755))
+	return path, dir
+}
+
+func TestLoadTransportConfig(t *testing.T) {
+	t.Setenv("SENDMAIL_PATH", "")
+	tc, err := LoadTransportConfig(TransportSendmail)
+	require.NoError(t, err)
+	assert.Equal(t, defaultSendmailPath, tc.SendmailPath)
+
+	t.Setenv("MBOX_PATH", "/tmp/out.mbox")
+	tc, err = LoadTransportConfig(TransportMbox)
+	require.NoError(t, err)
+	assert.Equal(t, "/tmp/out.mbox", tc.MboxPath)
+
+	for name, env := range map[string]string{TransportLMTP: "LMTP_ADDRESS", TransportMaildir: "MAILDIR_PATH"} {
+		t.Setenv(env, "")
+		_, err := LoadTransportConfig(name)
+		require.Error(t, err, name)
+		assert.Contains(t, err.Error(), env)
+	}
+
+	_, err = LoadTransportConfig("pigeon")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unknown transport")
+}
+
+func TestSendmailSender(t *testing.T) {
+	path, dir := fakeSendmail(t, "exit 0")
+	msg, err := createMessage("sender@example.com", "", Message{Name: "Jane", Address: "jane@example.com", Subject: "Hi", Body: "Hello\n.\nJane"})
+	require.NoError(t, err)
+
+	s := &sendmailSender{path: path}
+	require.NoError(t, s.Send(msg))
+
+	args, err := os.ReadFile(filepath.Join(dir, "args"))
+	require.NoError(t, err)
+	assert.Equal(t, "-oi -t\n", string(args))
+	stdin, err := os.ReadFile(filepath.Join(dir, "stdin"))
+	require.NoError(t, err)
+	assert.Contains(t, string(stdin), "To: \"Jane\" <jane@example.com>\n")
+	assert.NotContains(t, string(stdin), "\r\n")
+}
+
+func TestSendmailSenderFailure(t *testing.T) {
+	path, _ := fakeSendmail(t, "echo 'no such user' >&2; exit 67")
+	msg, err := createMessage("sender@example.com", "", Message{Address: "jane@example.com", Subject: "Hi", Body: "Hello"})
+	require.NoError(t, err)
+
+	err = (&sendmailSender{path: path}).Send(msg)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "exit status 67")
+	assert.Contains(t, err.Error(), "no such user")
+}
+
+func TestSendmailSenderStderrIsNotFailure(t *testing.T) {
+	path, _ := fakeSendmail(t, "echo 'warning: queued' >&2; exit 0")
+	msg, err := createMessage("sender@example.com", "", Message{Address: "jane@example.com", Subject: "Hi", Body: "Hello"})
+	require.NoError(t, err)
+	assert.NoError(t, (&sendmailSender{path: path}).Send(msg))
+}
+
+func TestNewSenderUnknownTransport(t *testing.T) {
+	_, err := NewSender(TransportConfig{Name: "pigeon"}, 0)
+	require.Error(t, err)
+	assert.True(t, strings.Contains(err.Error(), "pigeon"))
+}
+
+func TestSendmailSenderBcc(t *testing.T) {
+	path, dir := fakeSendmail(t, "exit 0")
+	msg, err := createMessage("sender@example.com", "", Message{Address: "jane@example.com", Cc: []string{"cc@example.com"}, Bcc: []string{"audit@example.com"}, Subject: "Hi", Body: "Hello"})
+	require.NoError(t, err)
+	require.NoError(t, (&sendmailSender{path: path}).Send(msg))
+
+	// sendmail -t would miss the blind copy, which is in no header.
+	args, err := os.ReadFile(filepath.Join(dir, "args"))
+	require.NoError(t, err)
+	assert.Equal(t, "-oi -- jane@example.com cc@example.com audit@example.com\n", string(args))
+	stdin, err := os.ReadFile(filepath.Join(dir, "stdin"))
+	require.NoError(t, err)
+	assert.NotContains(t, string(stdin), "audit@example.com")
+}
@@ -0,0 +1,163 @@
+// gmt sends emails in bulk based on a template and a config file.
+// Copyright (C) 2019-2025  "Muharem Hrnjadovic" <muharem@linux.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package email
+
+import (
+	"fmt"
+	netmail "net/mail"
+	"net/url"
+	"strings"
+
+	mail "github.com/wneessen/go-mail"
+
+	"github.com/al-maisan/gmt/config"
+)
+
+// unsubscribeOneClick is the List-Unsubscribe-Post value defined by RFC 8058.
+const unsubscribeOneClick = "List-Unsubscribe=One-Click"
+
+// unsubscribeTemplates are the parsed unsubscribe_mailto and unsubscribe_url
+// settings; either may be nil.
+type unsubscribeTemplates struct {
+	mailto textTemplate
+	url    textTemplate
+}
+
+// parseUnsubscribe parses the unsubscribe targets in cfg with its template
+// engine. The placeholder engine URL-escapes substituted values; with the go
+// engine, values are escaped with the urlquery function.
+func parseUnsubscribe(cfg *config.MailConfig) (unsubscribeTemplates, error) {
+	var u unsubscribeTemplates
+	var err error
+	if cfg.UnsubscribeMailto != "" {
+		if u.mailto, err = parseURLTemplate(cfg.TemplateEngine, "unsubscribe_mailto", cfg.UnsubscribeMailto); err != nil {
+			return u, err
+		}
+	}
+	if cfg.UnsubscribeURL != "" {
+		if u.url, err = parseURLTemplate(cfg.TemplateEngine, "unsubscribe_url", cfg.UnsubscribeURL); err != nil {
+			return u, err
+		}
+	}
+	return u, nil
+}
+
+// parseURLTemplate is parseTemplate for URLs: the placeholder engine
+// percent-encodes substituted values.
+func parseURLTemplate(engine, name, text string) (textTemplate, error) {
+	if engine == "" || engine == config.TemplateEnginePlaceholder {
+		return placeholderTemplate{text: text, escape: urlEscape}, nil
+	}
+	return parseTemplate(engine, name, text)
+}
+
+// urlEscape percent-encodes s for use in a URL query or path, and in a
+// mailto: URL, where "+" does not stand for a space.
+func urlEscape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+// unsubscribeURIs returns the rendered unsubscribe targets, either of which
+// may be empty, as List-Unsubscribe URIs, mailto: first. It returns an error
+// if either is malformed.
+func unsubscribeURIs(mailto, link string) ([]string, error) {
+	var uris []string
+	if mailto != "" {
+		uri, err := mailtoURI(mailto)
+		if err != nil {
+			return nil, fmt.Errorf("unsubscribe_mailto: %w", err)
+		}
+		uris = append(uris, uri)
+	}
+	if link != "" {
+		if err := checkUnsubscribeURL(link); err != nil {
+			return nil, fmt.Errorf("unsubscribe_url: %w", err)
+		}
+		uris = append(uris, link)
+	}
+	return uris, nil
+}
+
+// mailtoURI returns s, an address or a mailto: URL (RFC 6068), as a mailto:
+// URL, checking that it names a valid address.
+func mailtoURI(s string) (string, error) {
+	if !strings.HasPrefix(strings.ToLower(s), "mailto:") {
+		s = "mailto:" + s
+	}
+	if strings.ContainsAny(s, " \t\r\n<>,") {
+		return "", fmt.Errorf("%q contains whitespace, '<', '>' or ','", s)
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return "", fmt.Errorf("invalid mailto URL %q: %w", s, err)
+	}
+	addr, err := url.PathUnescape(u.Opaque)
+	if err == nil {
+		_, err = netmail.ParseAddress(addr)
+	}
+	if err != nil {
+		return "", fmt.Errorf("invalid address in %q: %w", s, err)
+	}
+	return s, nil
+}
+
+// checkUnsubscribeURL checks that s is an absolute https URL. RFC 8058 only
+// allows one-click unsubscription over HTTPS.
+func checkUnsubscribeURL(s string) error {
+	if strings.ContainsAny(s, " \t\r\n<>,") {
+		return fmt.Errorf("%q contains whitespace, '<', '>' or ','", s)
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return fmt.Errorf("invalid URL %q: %w", s, err)
+	}
+	if u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("%q is not an https URL", s)
+	}
+	return nil
+}
+
+// ListUnsubscribe returns the List-Unsubscribe header (RFC 2369) for m, or ""
+// if it has no unsubscribe targets.
+func ListUnsubscribe(m Message) string {
+	if len(m.Unsubscribe) == 0 {
+		return ""
+	}
+	return "<" + strings.Join(m.Unsubscribe, ">, <") + ">"
+}
+
+// ListUnsubscribePost returns the List-Unsubscribe-Post header (RFC 8058)
+// for m: one-click unsubscription is offered if m has an https target.
+func ListUnsubscribePost(m Message) string {
+	for _, uri := range m.Unsubscribe {
+		if strings.HasPrefix(uri, "https:") {
+			return unsubscribeOneClick
+		}
+	}
+	return ""
+}
+
+// setUnsubscribeHeaders adds m's List-Unsubscribe and List-Unsubscribe-Post
+// headers to msg.
+func setUnsubscribeHeaders(msg *mail.Msg, m Message) {
+	if v := ListUnsubscribe(m); v != "" {
+		msg.SetGenHeader(mail.HeaderListUnsubscribe, v)
+	}
+	if v := ListUnsubscribePost(m); v != "" {
+		msg.SetGenHeader(mail.HeaderListUnsubscribePost, v)
+	}
+}
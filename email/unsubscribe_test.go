@@ -0,0 +1,131 @@
+// gmt sends emails in bulk based on a template and a config file.
+// Copyright (C) 2019-2025  "Muharem Hrnjadovic" <muharem@linux.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package email
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/al-maisan/gmt/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrepMailsUnsubscribe(t *testing.T) {
+	cfg := config.MailConfig{
+		Subject:           "Hi",
+		UnsubscribeMailto: "unsubscribe@example.com?subject=unsubscribe%20%EA%",
+		UnsubscribeURL:    "https://example.com/unsubscribe?email=%EA%&list=%LIST%",
+		Recipients: []config.Recipient{
+			{Email: "jane+news@example.org", First: "Jane", Data: map[string]string{"LIST": "news & offers"}},
+		},
+	}
+	mails, _, err := PrepMails(&cfg, Templates{Text: "Hello"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"mailto:unsubscribe@example.com?subject=unsubscribe%20jane%2Bnews%40example.org",
+		"https://example.com/unsubscribe?email=jane%2Bnews%40example.org&list=news%20%26%20offers",
+	}, mails[0].Unsubscribe)
+
+	cfg.TemplateEngine = config.TemplateEngineGo
+	cfg.UnsubscribeMailto = ""
+	cfg.UnsubscribeURL = "https://example.com/u?e={{.EA | urlquery}}"
+	mails, _, err = PrepMails(&cfg, Templates{Text: "Hello"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"https://example.com/u?e=jane%2Bnews%40example.org"}, mails[0].Unsubscribe)
+}
+
+func TestPrepMailsUnsubscribeErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		mailto  string
+		url     string
+		wantErr string
+	}{
+		{"http URL", "", "http://example.com/u?e=%EA%", `unsubscribe_url: "http://example.com/u?e=jane%40example.org" is not an https URL`},
+		{"relative URL", "", "/u?e=%EA%", `unsubscribe_url: "/u?e=jane%40example.org" is not an https URL`},
+		{"space in URL", "", "https://example.com/u?e=%EA% x", `unsubscribe_url: "https://example.com/u?e=jane%40example.org x" contains whitespace`},
+		{"unresolved placeholder", "", "https://example.com/u?id=%ID%", "unsubscribe_url: unresolved placeholder(s): %ID%"},
+		{"bad address", "unsubscribe", "", `unsubscribe_mailto: invalid address in "mailto:unsubscribe"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.MailConfig{
+				Subject:           "Hi",
+				UnsubscribeMailto: tt.mailto,
+				UnsubscribeURL:    tt.url,
+				Recipients:        []config.Recipient{{Email: "jane@example.org", First: "Jane"}},
+			}
+			_, _, err := PrepMails(&cfg, Templates{Text: "Hello"})
+			assert.ErrorContains(t, err, "recipient 'jane@example.org': "+tt.wantErr)
+		})
+	}
+
+	cfg := config.MailConfig{
+		Subject:        "Hi",
+		UnsubscribeURL: "http://example.com/u",
+		Recipients:     []config.Recipient{{Email: "jane@example.org", First: "Jane"}},
+	}
+	_, _, err := PrepMails(&cfg, Templates{Text: "Hello"})
+	require.Error(t, err)
+	assert.True(t, strings.HasPrefix(err.Error(), "invalid unsubscribe links:\n"), err.Error())
+
+	cfg = config.MailConfig{
+		Subject:        "Hi",
+		TemplateEngine: config.TemplateEngineGo,
+		UnsubscribeURL: "https://example.com/u?e={{.EA",
+		Recipients:     []config.Recipient{{Email: "jane@example.org", First: "Jane"}},
+	}
+	_, _, err = PrepMails(&cfg, Templates{Text: "Hello"})
+	assert.ErrorContains(t, err, "invalid unsubscribe_url template")
+}
+
+func TestCreateMessageUnsubscribeHeaders(t *testing.T) {
+	m := Message{Address: "jane@example.org", Subject: "Hi", Body: "Hello",
+		Unsubscribe: []string{"mailto:unsubscribe@example.com", "https://example.com/unsubscribe?email=jane%40example.org"}}
+	msg, err := createMessage("sender@example.com", "", m)
+	require.NoError(t, err)
+	data, err := renderMessage(msg)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "List-Unsubscribe: <mailto:unsubscribe@example.com>,\r\n <https://example.com/unsubscribe?email=jane%40example.org>\r\n")
+	assert.Contains(t, string(data), "List-Unsubscribe-Post: List-Unsubscribe=One-Click\r\n")
+
+	// Without an https target there is no one-click unsubscription.
+	m.Unsubscribe = m.Unsubscribe[:1]
+	assert.Equal(t, "<mailto:unsubscribe@example.com>", ListUnsubscribe(m))
+	assert.Empty(t, ListUnsubscribePost(m))
+	msg, err = createMessage("sender@example.com", "", Message{Address: "jane@example.org", Subject: "Hi", Body: "Hello"})
+	require.NoError(t, err)
+	data, err = renderMessage(msg)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "List-Unsubscribe")
+}
+
+func TestDKIMSignsUnsubscribeHeaders(t *testing.T) {
+	keyPath, txt := writeDKIMKey(t)
+	signer, err := NewDKIMSigner(config.DKIMConfig{PrivateKey: keyPath, Selector: "gmt"}, "sender@example.com")
+	require.NoError(t, err)
+	msg, err := createMessage("sender@example.com", "", Message{Address: "jane@example.org", Subject: "Hi", Body: "Hello",
+		Unsubscribe: []string{"https://example.com/unsubscribe?email=jane%40example.org"}})
+	require.NoError(t, err)
+	require.NoError(t, signer.Sign(msg))
+
+	v := verifyDKIM(t, msg, txt)
+	assert.NoError(t, v.Err)
+	assert.Contains(t, v.HeaderKeys, "List-Unsubscribe")
+	assert.Contains(t, v.HeaderKeys, "List-Unsubscribe-Post")
+}
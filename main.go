@@ -17,11 +17,15 @@
 package main
 
 import (
+	"bufio"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"time"
 
@@ -36,6 +40,8 @@ const (
 	exitConfigError = 2
 	exitSMTPError   = 3
 	exitSendFailure = 4
+	exitPending     = 5
+	exitHeld        = 6
 )
 
 var (
@@ -60,6 +66,19 @@ func requireFlag(value, name string) {
 	}
 }
 
+// checkRedirect rejects the flags a -redirect test run cannot be combined
+// with: a test run must not mark the real recipients as sent in a journal or
+// list them as sent in a delivery report.
+func checkRedirect(journalPath, reportPath string) error {
+	if journalPath != "" {
+		return fmt.Errorf("-redirect cannot be combined with -journal")
+	}
+	if reportPath != "" {
+		return fmt.Errorf("-redirect cannot be combined with -report")
+	}
+	return nil
+}
+
 func main() {
 	log.SetFlags(0)
 
@@ -72,17 +91,39 @@ func main() {
 	doDryRun := flag.Bool("dry-run", false, "show what would be done but execute no action")
 	doValidate := flag.Bool("validate", false, "validate config and template without sending")
 	templatePath := flag.String("template-path", "", "path to the template file")
+	htmlTemplatePath := flag.String("html-template-path", "", "path to an HTML template file; overrides html_template in the config")
+	templateEngine := flag.String("template-engine", "", "template engine: placeholder (%KEY% substitution) or go (text/template); overrides template_engine in the config")
 	doSampleConfig := flag.Bool("sample-config", false, "output sample configuration to stdout")
 	doSampleTemplate := flag.Bool("sample-template", false, "output sample template to stdout")
 	doVersion := flag.Bool("version", false, "print version and exit")
 	delay := flag.Duration("delay", 0, "delay between emails (e.g., 1s, 500ms)")
+	rate := flag.String("rate", "", "rate limits as COUNT/WINDOW pairs, e.g. 10/s,100/m,2000/d; a daily limit stops the run when exhausted")
 	retries := flag.Int("retries", 1, "max retry attempts per failed send")
-	retryDelay := flag.Duration("retry-delay", 2*time.Second, "backoff between retries")
-	timeout := flag.Duration("timeout", 30*time.Second, "SMTP connect/send timeout (covers the full attachment upload)")
+	retryDelay := flag.Duration("retry-delay", 2*time.Second, "backoff before the first retry, doubled for each further one")
+	retryMaxDelay := flag.Duration("retry-max-delay", time.Minute, "upper bound for the retry backoff (0 for none)")
+	reportPath := flag.String("report", "", "write a per-recipient delivery report to this file (CSV for a .csv file, JSON lines otherwise)")
+	reportFormat := flag.String("report-format", "", "report format: jsonl or csv; overrides the -report file extension")
+	archivePath := flag.String("archive", "", "keep a copy of each sent message: appended to this mbox file if it ends in .mbox, otherwise as .eml files in this directory")
+	archiveFormat := flag.String("archive-format", "", "archive format: mbox or eml; overrides the -archive file extension")
+	maxSize := flag.String("max-size", "", "maximum encoded message size, e.g. 25MB; if any message is larger, nothing is sent")
+	connections := flag.Int("connections", 1, "number of parallel transport connections")
+	timeout := flag.Duration("timeout", 30*time.Second, "SMTP/LMTP connect/send and sendmail run timeout (covers the full attachment upload)")
+	transport := flag.String("transport", email.TransportSMTP, "how to deliver: "+strings.Join(email.Transports, ", "))
+	journalPath := flag.String("journal", "", "path to a delivery journal; recipients it marks as sent are skipped, so an interrupted run can be resumed")
+	doJournalList := flag.Bool("journal-list", false, "list the entries of the -journal file and exit")
+	doJournalReset := flag.Bool("journal-reset", false, "delete the -journal file and exit")
+	retryFailed := flag.Bool("retry-failed", false, "only send to recipients the -journal marks as failed")
+	canary := flag.String("canary", "", "staged rollout: send to the first N recipients, or to these comma-separated recipient addresses, then stop for confirmation (requires -journal)")
+	doContinue := flag.Bool("continue", false, "send to the recipients held back after a -canary run (requires -journal)")
+	yes := flag.Bool("yes", false, "confirm the -canary checkpoint without asking")
+	redirect := flag.String("redirect", "", "send every message to these comma-separated test addresses instead of its recipients")
+	bouncesPath := flag.String("bounces", "", "read the bounce reports (DSNs) in this mbox file or Maildir, add the failed recipients to the suppression list (-suppression-list, or suppression_list in -config-path) and exit")
+	suppressionList := flag.String("suppression-list", "", "file of addresses not to mail; overrides suppression_list in the config")
+	saveSent := flag.Bool("save-sent", false, "append a copy of each sent message to the IMAP folder given by IMAP_FOLDER (default Sent)")
 
 	flag.Parse()
 
-	if actionFlagCount(*doVersion, *doSampleConfig, *doSampleTemplate, *doValidate, *doDryRun) > 1 {
+	if actionFlagCount(*doVersion, *doSampleConfig, *doSampleTemplate, *doJournalList, *doJournalReset, *bouncesPath != "", *doValidate, *doDryRun) > 1 {
 		log.Printf("Warning: multiple action flags set; only the first in precedence order takes effect")
 	}
 
@@ -99,16 +140,125 @@ func main() {
 		os.Exit(exitOK)
 	}
 
+	if *doJournalList || *doJournalReset || *retryFailed {
+		requireFlag(*journalPath, "-journal")
+	}
+	if *doJournalList {
+		entries, err := email.ReadJournal(*journalPath)
+		if err != nil {
+			log.Printf("Error: %v", err)
+			os.Exit(exitConfigError)
+		}
+		printJournal(entries)
+		os.Exit(exitOK)
+	}
+	if *doJournalReset {
+		if err := email.ResetJournal(*journalPath); err != nil {
+			log.Printf("Error: %v", err)
+			os.Exit(exitConfigError)
+		}
+		fmt.Printf("Journal %s reset\n", *journalPath)
+		os.Exit(exitOK)
+	}
+	if *bouncesPath != "" {
+		listPath, err := bounceListPath(*suppressionList, *configPath)
+		if err != nil {
+			log.Printf("Error: %v", err)
+			flag.Usage()
+			os.Exit(exitUsageError)
+		}
+		if err := addBounces(*bouncesPath, listPath); err != nil {
+			log.Printf("Error: %v", err)
+			os.Exit(exitConfigError)
+		}
+		os.Exit(exitOK)
+	}
+
 	requireFlag(*configPath, "-config-path")
-	requireFlag(*templatePath, "-template-path")
 
+	if *connections < 1 {
+		log.Printf("Error: -connections must be >= 1")
+		flag.Usage()
+		os.Exit(exitUsageError)
+	}
 	if *retries < 0 {
 		log.Printf("Error: -retries must be >= 0")
 		flag.Usage()
 		os.Exit(exitUsageError)
 	}
-	if *delay < 0 || *retryDelay < 0 || *timeout < 0 {
-		log.Printf("Error: -delay, -retry-delay and -timeout must be >= 0")
+	if *delay < 0 || *retryDelay < 0 || *retryMaxDelay < 0 || *timeout < 0 {
+		log.Printf("Error: -delay, -retry-delay, -retry-max-delay and -timeout must be >= 0")
+		flag.Usage()
+		os.Exit(exitUsageError)
+	}
+	switch *reportFormat {
+	case "", email.ReportJSONL, email.ReportCSV:
+	default:
+		log.Printf("Error: -report-format must be %q or %q", email.ReportJSONL, email.ReportCSV)
+		flag.Usage()
+		os.Exit(exitUsageError)
+	}
+	switch *archiveFormat {
+	case "", email.ArchiveMbox, email.ArchiveEML:
+	default:
+		log.Printf("Error: -archive-format must be %q or %q", email.ArchiveMbox, email.ArchiveEML)
+		flag.Usage()
+		os.Exit(exitUsageError)
+	}
+	if !slices.Contains(email.Transports, *transport) {
+		log.Printf("Error: -transport must be one of %s", strings.Join(email.Transports, ", "))
+		flag.Usage()
+		os.Exit(exitUsageError)
+	}
+	rateLimits, err := email.ParseRateLimits(*rate)
+	if err != nil {
+		log.Printf("Error: -rate: %v", err)
+		flag.Usage()
+		os.Exit(exitUsageError)
+	}
+	var sizeLimit int64
+	if *maxSize != "" {
+		if sizeLimit, err = email.ParseSize(*maxSize); err != nil {
+			log.Printf("Error: -max-size: %v", err)
+			flag.Usage()
+			os.Exit(exitUsageError)
+		}
+	}
+	var canarySpec *email.Canary
+	if *canary != "" {
+		c, err := email.ParseCanary(*canary)
+		if err != nil {
+			log.Printf("Error: -canary: %v", err)
+			flag.Usage()
+			os.Exit(exitUsageError)
+		}
+		canarySpec = &c
+	}
+	if *canary != "" || *doContinue {
+		requireFlag(*journalPath, "-journal")
+	}
+	if *canary != "" && *doContinue {
+		log.Printf("Error: -canary and -continue are mutually exclusive")
+		flag.Usage()
+		os.Exit(exitUsageError)
+	}
+	var redirectTo []string
+	if *redirect != "" {
+		if redirectTo, err = email.ParseRedirect(*redirect); err != nil {
+			log.Printf("Error: -redirect: %v", err)
+			flag.Usage()
+			os.Exit(exitUsageError)
+		}
+		if err := checkRedirect(*journalPath, *reportPath); err != nil {
+			log.Printf("Error: %v", err)
+			flag.Usage()
+			os.Exit(exitUsageError)
+		}
+	}
+	switch *templateEngine {
+	case "", config.TemplateEnginePlaceholder, config.TemplateEngineGo:
+	default:
+		log.Printf("Error: -template-engine must be %q or %q", config.TemplateEnginePlaceholder, config.TemplateEngineGo)
 		flag.Usage()
 		os.Exit(exitUsageError)
 	}
@@ -118,60 +268,303 @@ func main() {
 		log.Printf("Error: %v", err)
 		os.Exit(exitConfigError)
 	}
+	if *templateEngine != "" {
+		cfg.TemplateEngine = *templateEngine
+	}
+	if *htmlTemplatePath != "" {
+		cfg.HTMLTemplate = *htmlTemplatePath
+	}
+	if *suppressionList != "" {
+		cfg.SuppressionList = *suppressionList
+	}
+	if err := cfg.LoadSuppressionList(); err != nil {
+		log.Printf("Error: %v", err)
+		os.Exit(exitConfigError)
+	}
+	if *templatePath == "" && cfg.HTMLTemplate == "" {
+		log.Printf("Error: -template-path flag is required (unless an HTML template is given via -html-template-path or html_template)")
+		flag.Usage()
+		os.Exit(exitUsageError)
+	}
 
-	msgs, err := prepMails(&cfg, *templatePath)
+	msgs, suppressed, err := prepMails(&cfg, *templatePath, cfg.HTMLTemplate)
 	if err != nil {
 		log.Printf("Error: %v", err)
 		os.Exit(exitConfigError)
 	}
+	printSuppressed(cfg, suppressed)
 
 	if err := email.CheckAttachments(msgs); err != nil {
 		log.Printf("Error: %v", err)
 		os.Exit(exitConfigError)
 	}
+	if err := email.CheckCertificates(msgs); err != nil {
+		log.Printf("Error: %v", err)
+		os.Exit(exitConfigError)
+	}
+	if canarySpec != nil {
+		if err := canarySpec.Check(msgs); err != nil {
+			log.Printf("Error: %v", err)
+			os.Exit(exitConfigError)
+		}
+	}
+	sizes, err := email.MessageSizes(msgs, cfg)
+	if err != nil {
+		log.Printf("Error: %v", err)
+		os.Exit(exitConfigError)
+	}
+	if reportOversized(msgs, sizes, sizeLimit, "set by -max-size") {
+		os.Exit(exitConfigError)
+	}
+
+	var smime *email.SMIMESigner
+	if cfg.SMIME != nil {
+		if smime, err = email.NewSMIMESigner(*cfg.SMIME, cfg.From); err != nil {
+			log.Printf("Error: %v", err)
+			os.Exit(exitConfigError)
+		}
+	}
+
+	var dkim *email.DKIMSigner
+	if cfg.DKIM != nil {
+		if dkim, err = email.NewDKIMSigner(*cfg.DKIM, cfg.From); err != nil {
+			log.Printf("Error: %v", err)
+			os.Exit(exitConfigError)
+		}
+	}
+
+	var pgp *email.PGP
+	if cfg.PGP != nil {
+		if pgp, err = email.NewPGP(*cfg.PGP, cfg.From, cfg.Recipients); err != nil {
+			log.Printf("Error: %v", err)
+			os.Exit(exitConfigError)
+		}
+		if err := pgp.CheckKeys(msgs); err != nil {
+			log.Printf("Error: %v", err)
+			os.Exit(exitConfigError)
+		}
+	}
+	if err := email.CheckSenders(msgs, dkim, smime, pgp); err != nil {
+		log.Printf("Error: %v", err)
+		os.Exit(exitConfigError)
+	}
 
 	if *doValidate {
 		fmt.Printf("Config and template are valid: %d recipient(s)\n", len(msgs))
+		if dkim != nil {
+			fmt.Printf("DKIM key is valid: signing as d=%s, s=%s\n", dkim.Domain(), dkim.Selector())
+		}
+		if smime != nil {
+			fmt.Printf("S/MIME certificate is valid: signing as %s (expires %s)\n", smime.Subject(), smime.NotAfter().Format(time.DateOnly))
+		}
+		if pgp != nil && pgp.Signer() != "" {
+			fmt.Printf("OpenPGP key is valid: signing as %s\n", pgp.Signer())
+		}
+		if v := email.ListUnsubscribe(msgs[0]); v != "" {
+			fmt.Printf("Unsubscribe links are valid: e.g. %s\n", v)
+		}
+		largest := slices.Index(sizes, slices.Max(sizes))
+		fmt.Printf("Largest message: %s, to %s\n", email.FormatSize(sizes[largest]), msgs[largest].Address)
 		os.Exit(exitOK)
 	}
 
 	if *doDryRun {
-		printDryRun(msgs)
+		printDryRun(msgs, sizes, pgp)
 		os.Exit(exitOK)
 	}
 
-	creds, err := email.LoadSMTPCredentials()
+	// Open the journal before connecting, so a bad path fails fast.
+	var journal *email.Journal
+	if *journalPath != "" {
+		key, err := runKey(cfg.TemplateEngine, *configPath, *templatePath, cfg.HTMLTemplate, cfg.RecipientsCSV)
+		if err != nil {
+			log.Printf("Error: %v", err)
+			os.Exit(exitConfigError)
+		}
+		if journal, err = email.OpenJournal(*journalPath, key); err != nil {
+			log.Printf("Error: %v", err)
+			os.Exit(exitConfigError)
+		}
+		// Without a canary on record, -continue would start a full run
+		// unchecked (e.g. after a template edit changed the run key).
+		if *doContinue && !anyDelivered(journal, msgs) {
+			log.Printf("Error: journal %s records no sends for this configuration and template; run with -canary first", *journalPath)
+			os.Exit(exitConfigError)
+		}
+	}
+
+	var report *email.Report
+	var reportFile *os.File
+	if *reportPath != "" {
+		if report, reportFile, err = openReport(*reportPath, *reportFormat); err != nil {
+			log.Printf("Error: %v", err)
+			os.Exit(exitConfigError)
+		}
+	}
+
+	var archive *email.Archive
+	if *archivePath != "" {
+		format := *archiveFormat
+		if format == "" {
+			format = email.ArchiveFormatFor(*archivePath)
+		}
+		if archive, err = email.OpenArchive(*archivePath, format); err != nil {
+			log.Printf("Error: %v", err)
+			os.Exit(exitConfigError)
+		}
+	}
+
+	tc, err := email.LoadTransportConfig(*transport)
 	if err != nil {
-		log.Printf("SMTP configuration error: %v", err)
+		log.Printf("%s configuration error: %v", *transport, err)
 		os.Exit(exitSMTPError)
 	}
 
-	sender, err := email.NewSMTPSender(creds, *timeout)
+	var imapCfg email.IMAPConfig
+	if *saveSent {
+		if imapCfg, err = email.LoadIMAPConfig(); err != nil {
+			log.Printf("IMAP configuration error: %v", err)
+			os.Exit(exitSMTPError)
+		}
+	}
+
+	senders, err := openSenders(tc, *timeout, min(*connections, len(msgs)))
 	if err != nil {
-		log.Printf("SMTP error: %v", err)
+		log.Printf("%s error: %v", *transport, err)
 		os.Exit(exitSMTPError)
 	}
 
+	serverLimit, err := email.ServerMaxSize(senders[0])
+	if err != nil {
+		closeSenders(senders)
+		log.Printf("%s error: %v", *transport, err)
+		os.Exit(exitSMTPError)
+	}
+	if reportOversized(msgs, sizes, serverLimit, "advertised by the server") {
+		closeSenders(senders)
+		os.Exit(exitConfigError)
+	}
+
+	var sentFolder *email.IMAPAppender
+	if *saveSent {
+		if sentFolder, err = email.NewIMAPAppender(imapCfg, *timeout); err != nil {
+			closeSenders(senders)
+			log.Printf("IMAP error: %v", err)
+			os.Exit(exitSMTPError)
+		}
+	}
+
 	opts := email.SendOptions{
-		Delay:      *delay,
-		Retries:    *retries,
-		RetryDelay: *retryDelay,
+		Delay:         *delay,
+		RateLimits:    rateLimits,
+		Retries:       *retries,
+		RetryDelay:    *retryDelay,
+		RetryMaxDelay: *retryMaxDelay,
+		Journal:       journal,
+		RetryFailed:   *retryFailed,
+		Report:        report,
+		SMIME:         smime,
+		PGP:           pgp,
+		DKIM:          dkim,
+		SaveSent:      sentFolder,
+		Archive:       archive,
+		Redirect:      redirectTo,
+	}
+	if canarySpec != nil {
+		canarySpec.Confirm = func(email.SendResult, int) bool { return confirmCanary(*yes) }
+		opts.Canary = canarySpec
+	}
+	batch := email.NewPooledBatchSender(os.Stdout, senders, cfg, opts)
+	if len(redirectTo) > 0 {
+		fmt.Printf("\nRedirecting all emails to %s\n", strings.Join(redirectTo, ", "))
 	}
-	batch := email.NewBatchSender(os.Stdout, sender, cfg, opts)
 	fmt.Println("\nSending emails now..")
 	result := batch.SendAll(msgs)
 
-	// Close explicitly (not via defer) so the graceful SMTP QUIT runs even on
+	// Close explicitly (not via defer) so the graceful SMTP/LMTP QUIT runs even on
 	// the exitSendFailure path below — os.Exit does not run deferred calls.
-	if cerr := sender.Close(); cerr != nil {
-		log.Printf("Warning: failed to close SMTP connection: %v", cerr)
+	closeSenders(senders)
+	if sentFolder != nil {
+		if err := sentFolder.Close(); err != nil {
+			log.Printf("Warning: failed to close IMAP connection: %v", err)
+		}
+	}
+
+	if reportFile != nil {
+		if rerr := reportFile.Close(); rerr != nil {
+			log.Printf("Warning: failed to close report: %v", rerr)
+		}
+	}
+	if archive != nil {
+		if aerr := archive.Close(); aerr != nil {
+			log.Printf("Warning: failed to close archive: %v", aerr)
+		}
+	}
+	if journal != nil {
+		if jerr := journal.Close(); jerr != nil {
+			log.Printf("Warning: failed to close journal: %v", jerr)
+		}
 	}
 
 	fmt.Printf("\nDone: %d sent, %d failed, %d total\n", result.Sent, result.Failed, result.Sent+result.Failed)
+	if result.Skipped > 0 {
+		fmt.Printf("Skipped %d recipient(s) per journal %s\n", result.Skipped, *journalPath)
+	}
+	if result.NotSaved > 0 {
+		fmt.Printf("Warning: %d sent message(s) could not be saved to IMAP folder %q\n", result.NotSaved, sentFolder.Folder())
+	}
+	if result.NotArchived > 0 {
+		fmt.Printf("Warning: %d sent message(s) could not be archived to %s\n", result.NotArchived, *archivePath)
+	}
+	if result.NoKey > 0 {
+		fmt.Printf("Skipped %d recipient(s) without an OpenPGP key\n", result.NoKey)
+	}
+
+	if len(result.Pending) > 0 {
+		printPending(result, *journalPath)
+	}
+	if len(result.Held) > 0 {
+		fmt.Printf("\nCanary: %d recipient(s) held back. Check the canary messages, then run the same command with -continue instead of -canary to send to them.\n", len(result.Held))
+	}
 
 	if result.Failed > 0 {
 		os.Exit(exitSendFailure)
 	}
+	if len(result.Pending) > 0 {
+		os.Exit(exitPending)
+	}
+	if len(result.Held) > 0 {
+		os.Exit(exitHeld)
+	}
+}
+
+// anyDelivered reports whether the journal marks any of msgs as delivered.
+func anyDelivered(journal *email.Journal, msgs []email.Message) bool {
+	for _, m := range msgs {
+		if status, ok := journal.Status(m.Address); ok && (status == email.StatusSent || status == email.StatusDeliveredDespiteError) {
+			return true
+		}
+	}
+	return false
+}
+
+// confirmCanary decides at the -canary checkpoint whether to send to the
+// remaining recipients: yes if -yes was given, otherwise by asking on a
+// terminal. A non-interactive run without -yes stops.
+func confirmCanary(yes bool) bool {
+	if yes {
+		return true
+	}
+	if fi, err := os.Stdin.Stat(); err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	fmt.Print("Send to the remaining recipients? [y/N] ")
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	}
+	return false
 }
 
 // actionFlagCount returns how many mutually-exclusive action flags are set.
@@ -199,36 +592,277 @@ func loadConfig(path string) (config.MailConfig, error) {
 	return cfg, nil
 }
 
-func prepMails(cfg *config.MailConfig, templatePath string) ([]email.Message, error) {
-	bs, err := os.ReadFile(templatePath)
+// prepMails reads the text and/or HTML template (either path may be empty,
+// but not both), splits the front matter off the templates, and prepares
+// one message per recipient, returning the addresses found on the suppression
+// list as well.
+func prepMails(cfg *config.MailConfig, templatePath, htmlTemplatePath string) ([]email.Message, []email.SuppressedAddress, error) {
+	var tmpl email.Templates
+	var err error
+	if templatePath != "" {
+		if tmpl.Text, err = readTemplate(templatePath); err != nil {
+			return nil, nil, err
+		}
+	}
+	if htmlTemplatePath != "" {
+		if tmpl.HTML, err = readTemplate(htmlTemplatePath); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	msgs, suppressed, err := email.PrepMails(cfg, tmpl)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(msgs) == 0 {
+		if len(suppressed) > 0 {
+			return nil, nil, fmt.Errorf("all %d recipient(s) are on suppression list %s", len(cfg.Recipients), cfg.SuppressionList)
+		}
+		return nil, nil, fmt.Errorf("no recipients found in config file")
+	}
+
+	return msgs, suppressed, nil
+}
+
+// readTemplate returns the contents of the template file at path, rejecting
+// an empty (or whitespace-only) file.
+func readTemplate(path string) (string, error) {
+	bs, err := os.ReadFile(path)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read template file %q: %w", templatePath, err)
+		return "", fmt.Errorf("failed to read template file %q: %w", path, err)
 	}
 	if len(strings.TrimSpace(string(bs))) == 0 {
-		return nil, fmt.Errorf("template file %q is empty", templatePath)
+		return "", fmt.Errorf("template file %q is empty", path)
 	}
+	return string(bs), nil
+}
 
-	msgs, err := email.PrepMails(cfg, string(bs))
+// openReport creates the report file at path. format may be empty to infer it
+// from the file extension.
+func openReport(path, format string) (*email.Report, *os.File, error) {
+	if format == "" {
+		format = email.ReportFormatFor(path)
+	}
+	f, err := os.Create(path)
 	if err != nil {
-		return nil, err
+		return nil, nil, fmt.Errorf("failed to create report %q: %w", path, err)
 	}
-	if len(msgs) == 0 {
-		return nil, fmt.Errorf("no recipients found in config file")
+	report, err := email.NewReport(f, format)
+	if err != nil {
+		_ = f.Close()
+		return nil, nil, fmt.Errorf("report %q: %w", path, err)
 	}
+	return report, f, nil
+}
 
-	return msgs, nil
+// openSenders opens n senders for the transport tc. If one fails, those
+// already opened are closed and the error returned.
+func openSenders(tc email.TransportConfig, timeout time.Duration, n int) ([]email.Sender, error) {
+	senders := make([]email.Sender, 0, n)
+	for i := range n {
+		sender, err := email.NewSender(tc, timeout)
+		if err != nil {
+			closeSenders(senders)
+			if n > 1 {
+				return nil, fmt.Errorf("connection %d of %d: %w", i+1, n, err)
+			}
+			return nil, err
+		}
+		senders = append(senders, sender)
+	}
+	return senders, nil
 }
 
-func printDryRun(msgs []email.Message) {
-	for _, m := range msgs {
+func closeSenders(senders []email.Sender) {
+	for _, sender := range senders {
+		if err := sender.Close(); err != nil {
+			log.Printf("Warning: failed to close connection: %v", err)
+		}
+	}
+}
+
+// runKey identifies a run for the journal: a digest of the template engine and
+// the contents of the config, template and recipients_csv files (empty paths
+// are skipped).
+func runKey(engine string, paths ...string) (string, error) {
+	inputs := [][]byte{[]byte(engine)}
+	for _, path := range paths {
+		if path == "" {
+			inputs = append(inputs, nil)
+			continue
+		}
+		bs, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read %q: %w", path, err)
+		}
+		inputs = append(inputs, bs)
+	}
+	return email.RunKey(inputs...), nil
+}
+
+func printPending(result email.SendResult, journalPath string) {
+	fmt.Printf("\nRate limit %s reached: %d recipient(s) pending, sending can resume at %s\n",
+		result.Limit, len(result.Pending), result.ResumeAt.Local().Format(time.DateTime))
+	for _, m := range result.Pending {
+		fmt.Printf("  %s <%s>\n", m.Name, m.Address)
+	}
+	if journalPath != "" {
+		fmt.Printf("Re-run the same command with -journal %s to send to them.\n", journalPath)
+	} else {
+		fmt.Println("Without -journal a re-run mails every recipient again; send to the pending recipients with a reduced config, or use -journal next time.")
+	}
+}
+
+func printJournal(entries []email.JournalEntry) {
+	counts := make(map[email.DeliveryStatus]int)
+	for _, e := range entries {
+		counts[e.Status]++
+		fmt.Printf("%s  %-23s  %s", e.Time.Local().Format(time.DateTime), e.Status, e.Address)
+		if e.Error != "" {
+			fmt.Printf("  (%s)", e.Error)
+		}
+		fmt.Println()
+	}
+	fmt.Printf("%d entries: %d sent, %d delivered despite error, %d failed\n", len(entries),
+		counts[email.StatusSent], counts[email.StatusDeliveredDespiteError], counts[email.StatusFailed])
+}
+
+// bounceListPath returns the suppression list -bounces updates: the
+// -suppression-list flag if given, else suppression_list from the config file
+// at configPath, if any.
+func bounceListPath(flagValue, configPath string) (string, error) {
+	if flagValue != "" {
+		return flagValue, nil
+	}
+	if configPath != "" {
+		cfg, err := loadConfig(configPath)
+		if err != nil {
+			return "", err
+		}
+		if cfg.SuppressionList != "" {
+			return cfg.SuppressionList, nil
+		}
+	}
+	return "", fmt.Errorf("-bounces needs -suppression-list, or -config-path with suppression_list in [general]")
+}
+
+// addBounces adds the failed recipients of the bounce reports in the mailbox
+// at path to the suppression list file listPath, creating it if needed.
+func addBounces(path, listPath string) error {
+	scan, err := email.ReadBounces(path)
+	if err != nil {
+		return err
+	}
+	list, err := config.ReadSuppressionList(listPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	merged := config.MergeSuppressions(list, scan.Failed)
+	if err := config.WriteSuppressionList(listPath, merged); err != nil {
+		return err
+	}
+	for _, s := range scan.Failed {
+		fmt.Printf("%-8s %s  %s\n", s.Status, s.Address, s.Diagnostic)
+	}
+	fmt.Printf("%d message(s), %d bounce report(s), %d failed recipient(s); %s now lists %d address(es) (%d new)\n",
+		scan.Messages, scan.Reports, len(scan.Failed), listPath, len(merged), len(merged)-len(list))
+	return nil
+}
+
+// printSuppressed lists the recipient, Cc and Bcc addresses on the
+// suppression list, and why: left out, or mailed with a warning, as
+// cfg.SuppressionMode says. A copy address is listed once, however many
+// messages it was on.
+func printSuppressed(cfg config.MailConfig, suppressed []email.SuppressedAddress) {
+	var lines []string
+	type copyAddress struct{ field, address string }
+	copies := make(map[copyAddress]int)
+	for _, s := range suppressed {
+		if s.Copy != "" {
+			copies[copyAddress{s.Copy, s.Address}]++
+		}
+	}
+	for _, s := range suppressed {
+		key := copyAddress{s.Copy, s.Address}
+		switch n := copies[key]; {
+		case s.Copy == "":
+			lines = append(lines, fmt.Sprintf("  %s: %s", s.Address, s.Entry.Reason()))
+		case n > 0:
+			lines = append(lines, fmt.Sprintf("  %s (%s of %d message(s)): %s", s.Address, s.Copy, n, s.Entry.Reason()))
+			copies[key] = 0
+		}
+	}
+	if len(lines) == 0 {
+		return
+	}
+	if cfg.SuppressionMode == config.SuppressionWarn {
+		log.Printf("Warning: %d address(es) on suppression list %s will be mailed anyway:\n%s", len(lines), cfg.SuppressionList, strings.Join(lines, "\n"))
+	} else {
+		log.Printf("Excluded %d address(es) on suppression list %s:\n%s", len(lines), cfg.SuppressionList, strings.Join(lines, "\n"))
+	}
+}
+
+// reportOversized lists the messages larger than limit, described by source,
+// and reports whether there are any. A limit of 0 means none.
+func reportOversized(msgs []email.Message, sizes []int64, limit int64, source string) bool {
+	if limit <= 0 {
+		return false
+	}
+	var lines []string
+	for i, m := range msgs {
+		if sizes[i] > limit {
+			lines = append(lines, fmt.Sprintf("  %s <%s>: %s", m.Name, m.Address, email.FormatSize(sizes[i])))
+		}
+	}
+	if len(lines) == 0 {
+		return false
+	}
+	log.Printf("Error: %d message(s) exceed the %s limit %s; nothing was sent:\n%s",
+		len(lines), email.FormatSize(limit), source, strings.Join(lines, "\n"))
+	return true
+}
+
+func printDryRun(msgs []email.Message, sizes []int64, pgp *email.PGP) {
+	for i, m := range msgs {
 		fmt.Printf("--\n\"%s\" <%s>\n", m.Name, m.Address)
+		if m.From != "" {
+			fmt.Printf("From: %s\n", m.From)
+		}
+		if m.ReplyTo != "" {
+			fmt.Printf("Reply-To: %s\n", m.ReplyTo)
+		}
 		if len(m.Cc) > 0 {
 			fmt.Printf("Cc: %s\n", strings.Join(m.Cc, ", "))
 		}
+		if len(m.Bcc) > 0 {
+			fmt.Printf("Bcc (envelope only, not in the headers): %s\n", strings.Join(m.Bcc, ", "))
+		}
 		fmt.Printf("Subject: %s\n", m.Subject)
+		for _, name := range slices.Sorted(maps.Keys(m.Headers)) {
+			fmt.Printf("%s: %s\n", name, m.Headers[name])
+		}
+		if v := email.ListUnsubscribe(m); v != "" {
+			fmt.Printf("List-Unsubscribe: %s\n", v)
+		}
+		if v := email.ListUnsubscribePost(m); v != "" {
+			fmt.Printf("List-Unsubscribe-Post: %s\n", v)
+		}
+		fmt.Printf("Size: %s\n", email.FormatSize(sizes[i]))
 		if len(m.Attachments) > 0 {
 			fmt.Printf("Attachments: %s\n", strings.Join(m.Attachments, ", "))
 		}
+		if m.SMIMECert != "" {
+			fmt.Printf("Encrypted to: %s\n", m.SMIMECert)
+		}
+		if pgp != nil {
+			// CheckKeys has already vetted every key.
+			if key, _ := pgp.RecipientKey(m); key != "" {
+				fmt.Printf("Encrypted to: OpenPGP key %s\n", key)
+			}
+		}
 		fmt.Printf("%s\n", m.Body)
+		if m.HTMLBody != "" {
+			fmt.Printf("-- text/html alternative --\n%s\n", m.HTMLBody)
+		}
 	}
 }
@@ -22,6 +22,7 @@ import (
 	"testing"
 
 	"github.com/al-maisan/gmt/config"
+	"github.com/al-maisan/gmt/email"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -66,14 +67,175 @@ func TestPrepMailsValid(t *testing.T) {
 	cfg, err := config.Parse([]byte(config.SampleConfig("0.0.0")))
 	require.NoError(t, err)
 
-	msgs, err := prepMails(&cfg, tmplPath)
+	msgs, _, err := prepMails(&cfg, tmplPath, "")
 	require.NoError(t, err)
 	assert.NotEmpty(t, msgs)
 }
 
 func TestPrepMailsMissingTemplate(t *testing.T) {
 	cfg := config.MailConfig{}
-	_, err := prepMails(&cfg, "/nonexistent/template.eml")
+	_, _, err := prepMails(&cfg, "/nonexistent/template.eml", "")
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "failed to read template")
 }
+
+func TestPrepMailsHTMLOnly(t *testing.T) {
+	dir := t.TempDir()
+	htmlPath := filepath.Join(dir, "template.html")
+	require.NoError(t, os.WriteFile(htmlPath, []byte("<p>Dear %FN%</p>"), 0o644))
+
+	cfg, err := config.Parse([]byte(config.SampleConfig("0.0.0")))
+	require.NoError(t, err)
+
+	msgs, _, err := prepMails(&cfg, "", htmlPath)
+	require.NoError(t, err)
+	require.NotEmpty(t, msgs)
+	assert.Equal(t, "<p>Dear John</p>", msgs[0].HTMLBody)
+	assert.Equal(t, "Dear John", msgs[0].Body)
+}
+
+func TestPrepMailsFrontMatter(t *testing.T) {
+	dir := t.TempDir()
+	tmplPath := filepath.Join(dir, "template.md")
+	require.NoError(t, os.WriteFile(tmplPath, []byte("---\nSubject: Hello %FN%\nFormat: markdown\n---\nDear **%FN%**\n"), 0o644))
+
+	cfg, err := config.Parse([]byte(config.SampleConfig("0.0.0")))
+	require.NoError(t, err)
+
+	msgs, _, err := prepMails(&cfg, tmplPath, "")
+	require.NoError(t, err)
+	require.NotEmpty(t, msgs)
+	assert.Equal(t, "Hello John", msgs[0].Subject)
+	assert.Equal(t, "Dear **John**\n", msgs[0].Body)
+	assert.Equal(t, "<p>Dear <strong>John</strong></p>\n", msgs[0].HTMLBody)
+}
+
+func TestPrepMailsHTMLFrontMatter(t *testing.T) {
+	dir := t.TempDir()
+	htmlPath := filepath.Join(dir, "template.html")
+	require.NoError(t, os.WriteFile(htmlPath, []byte("+++\nsubject = \"Hello %FN%\"\n[headers]\nX-Campaign = \"html\"\n+++\n<p>Dear %FN%</p>"), 0o644))
+
+	cfg, err := config.Parse([]byte(config.SampleConfig("0.0.0")))
+	require.NoError(t, err)
+
+	msgs, _, err := prepMails(&cfg, "", htmlPath)
+	require.NoError(t, err)
+	require.NotEmpty(t, msgs)
+	assert.Equal(t, "Hello John", msgs[0].Subject)
+	assert.Equal(t, map[string]string{"X-Campaign": "html"}, msgs[0].Headers)
+	assert.Equal(t, "<p>Dear John</p>", msgs[0].HTMLBody)
+	assert.Equal(t, "Dear John", msgs[0].Body)
+
+	tmplPath := filepath.Join(dir, "template.eml")
+	require.NoError(t, os.WriteFile(tmplPath, []byte("---\nSubject: Hi\n---\nDear %FN%"), 0o644))
+	_, _, err = prepMails(&cfg, tmplPath, htmlPath)
+	assert.ErrorContains(t, err, "both the text and the HTML template have front matter")
+}
+
+func TestPrepMailsBadFrontMatter(t *testing.T) {
+	dir := t.TempDir()
+	tmplPath := filepath.Join(dir, "template.eml")
+	require.NoError(t, os.WriteFile(tmplPath, []byte("+++\nsubject = \"Hi\"\n\nDear %FN%\n"), 0o644))
+
+	cfg := config.MailConfig{}
+	_, _, err := prepMails(&cfg, tmplPath, "")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `template: front matter: no closing "+++" line`)
+}
+
+func TestPrepMailsEmptyHTMLTemplate(t *testing.T) {
+	dir := t.TempDir()
+	htmlPath := filepath.Join(dir, "template.html")
+	require.NoError(t, os.WriteFile(htmlPath, []byte("  \n"), 0o644))
+
+	cfg := config.MailConfig{}
+	_, _, err := prepMails(&cfg, "", htmlPath)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "is empty")
+}
+
+func TestRunKey(t *testing.T) {
+	dir := t.TempDir()
+	cfgPath := filepath.Join(dir, "config.toml")
+	tmplPath := filepath.Join(dir, "template.eml")
+	require.NoError(t, os.WriteFile(cfgPath, []byte("config"), 0o644))
+	require.NoError(t, os.WriteFile(tmplPath, []byte("Dear %FN%"), 0o644))
+
+	k1, err := runKey("placeholder", cfgPath, tmplPath, "")
+	require.NoError(t, err)
+	k2, err := runKey("placeholder", cfgPath, tmplPath, "")
+	require.NoError(t, err)
+	assert.Equal(t, k1, k2)
+
+	k3, err := runKey("go", cfgPath, tmplPath, "")
+	require.NoError(t, err)
+	assert.NotEqual(t, k1, k3, "the template engine is part of the key")
+
+	require.NoError(t, os.WriteFile(tmplPath, []byte("Hi %FN%"), 0o644))
+	k4, err := runKey("placeholder", cfgPath, tmplPath, "")
+	require.NoError(t, err)
+	assert.NotEqual(t, k1, k4, "an edited template starts a new run")
+
+	csvPath := filepath.Join(dir, "recipients.csv")
+	require.NoError(t, os.WriteFile(csvPath, []byte("email,first,org\na@b.com,A,EFF\n"), 0o644))
+	k5, err := runKey("placeholder", cfgPath, tmplPath, "", csvPath)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(csvPath, []byte("email,first,org\na@b.com,A,FSF\n"), 0o644))
+	k6, err := runKey("placeholder", cfgPath, tmplPath, "", csvPath)
+	require.NoError(t, err)
+	assert.NotEqual(t, k5, k6, "edited recipients_csv data starts a new run")
+
+	_, err = runKey("placeholder", filepath.Join(dir, "missing.toml"))
+	assert.Error(t, err)
+}
+
+func TestAnyDelivered(t *testing.T) {
+	journal, err := email.OpenJournal(filepath.Join(t.TempDir(), "journal.jsonl"), "run")
+	require.NoError(t, err)
+	defer journal.Close()
+	msgs := []email.Message{{Address: "a@example.com"}, {Address: "b@example.com"}}
+
+	assert.False(t, anyDelivered(journal, msgs))
+	require.NoError(t, journal.Record("a@example.com", email.StatusFailed, assert.AnError))
+	assert.False(t, anyDelivered(journal, msgs), "a failed canary is not a delivery")
+	require.NoError(t, journal.Record("b@example.com", email.StatusSent, nil))
+	assert.True(t, anyDelivered(journal, msgs))
+}
+
+func TestCheckRedirect(t *testing.T) {
+	assert.NoError(t, checkRedirect("", ""))
+	assert.EqualError(t, checkRedirect("run.journal", ""), "-redirect cannot be combined with -journal")
+	assert.EqualError(t, checkRedirect("", "report.csv"), "-redirect cannot be combined with -report")
+}
+
+func TestBounceListPath(t *testing.T) {
+	dir := t.TempDir()
+	cfgPath := filepath.Join(dir, "config.toml")
+	require.NoError(t, os.WriteFile(cfgPath, []byte(`
+[general]
+from = "x <x@example.com>"
+subject = "Hi"
+suppression_list = "configured.csv"
+[[recipients]]
+email = "a@b.com"
+first = "A"
+`), 0o644))
+
+	path, err := bounceListPath("flag.csv", cfgPath)
+	require.NoError(t, err)
+	assert.Equal(t, "flag.csv", path, "the flag overrides the config")
+
+	path, err = bounceListPath("", cfgPath)
+	require.NoError(t, err)
+	assert.Equal(t, "configured.csv", path)
+
+	_, err = bounceListPath("", "")
+	assert.ErrorContains(t, err, "-bounces needs -suppression-list, or -config-path with suppression_list")
+
+	require.NoError(t, os.WriteFile(cfgPath, []byte(config.SampleConfig("0.0.0")), 0o644))
+	_, err = bounceListPath("", cfgPath)
+	assert.ErrorContains(t, err, "-bounces needs -suppression-list")
+
+	_, err = bounceListPath("", filepath.Join(dir, "missing.toml"))
+	assert.ErrorContains(t, err, "failed to read config file")
+}